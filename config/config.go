@@ -0,0 +1,408 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package config loads getty server and client configs from yaml/toml/json files and the environment, and
+// builds the servers and clients applying the session parameters of the configs to every new session.
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	getty "github.com/AlexStocks/getty/transport"
+)
+
+// the networks of the servers and clients
+const (
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
+	NetworkWS  = "ws"
+	NetworkWSS = "wss"
+)
+
+// Duration is a time.Duration written as a string such as "1.5s" or "1m" in the config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// ValidationError lists all of the illegal fields of a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "illegal getty config: " + strings.Join(e.Problems, "; ")
+}
+
+// validator collects the problems of a config
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...any) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) nonNegative(name string, value int64) {
+	if value < 0 {
+		v.addf("%s %d is negative", name, value)
+	}
+}
+
+func (v *validator) positive(name string, value int64) {
+	if value <= 0 {
+		v.addf("%s %d is not positive", name, value)
+	}
+}
+
+// keyPair checks that the certificate file @cert and the private key file @key can be loaded.
+func (v *validator) keyPair(cert, key string) {
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		v.addf("illegal cert %q or private_key %q: %v", cert, key, err)
+	}
+}
+
+// certificates checks that the file @name of field @field holds certificates whose last one can be parsed.
+func (v *validator) certificates(field, name string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		v.addf("illegal %s: %v", field, err)
+		return
+	}
+	var last []byte
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			last = block.Bytes
+		}
+	}
+	if last == nil {
+		v.addf("illegal %s: no certificate in %q", field, name)
+		return
+	}
+	if _, err = x509.ParseCertificates(last); err != nil {
+		v.addf("illegal %s %q: %v", field, name, err)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// SessionConfig holds the parameters applied to every session of a server or a client. The zero value of
+// a number or a duration keeps the default of getty.
+type SessionConfig struct {
+	CompressEncoding bool     `yaml:"compress_encoding" toml:"compress_encoding" json:"compress_encoding,omitempty"`
+	TcpNoDelay       bool     `yaml:"tcp_no_delay" toml:"tcp_no_delay" json:"tcp_no_delay,omitempty"`
+	TcpKeepAlive     bool     `yaml:"tcp_keep_alive" toml:"tcp_keep_alive" json:"tcp_keep_alive,omitempty"`
+	KeepAlivePeriod  Duration `yaml:"keep_alive_period" toml:"keep_alive_period" json:"keep_alive_period,omitempty"`
+	TcpRBufSize      int      `yaml:"tcp_r_buf_size" toml:"tcp_r_buf_size" json:"tcp_r_buf_size,omitempty"`
+	TcpWBufSize      int      `yaml:"tcp_w_buf_size" toml:"tcp_w_buf_size" json:"tcp_w_buf_size,omitempty"`
+	TcpReadTimeout   Duration `yaml:"tcp_read_timeout" toml:"tcp_read_timeout" json:"tcp_read_timeout,omitempty"`
+	TcpWriteTimeout  Duration `yaml:"tcp_write_timeout" toml:"tcp_write_timeout" json:"tcp_write_timeout,omitempty"`
+	WaitTimeout      Duration `yaml:"wait_timeout" toml:"wait_timeout" json:"wait_timeout,omitempty"`
+	CronPeriod       Duration `yaml:"cron_period" toml:"cron_period" json:"cron_period,omitempty"`
+	MaxMsgLen        int      `yaml:"max_msg_len" toml:"max_msg_len" json:"max_msg_len,omitempty"`
+	SessionName      string   `yaml:"session_name" toml:"session_name" json:"session_name,omitempty"`
+}
+
+// DefaultSessionConfig returns the session config enabling TCP_NODELAY and SO_KEEPALIVE.
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		TcpNoDelay:   true,
+		TcpKeepAlive: true,
+	}
+}
+
+func (c *SessionConfig) validate(v *validator, prefix string) {
+	v.nonNegative(prefix+"keep_alive_period", int64(c.KeepAlivePeriod))
+	v.nonNegative(prefix+"tcp_r_buf_size", int64(c.TcpRBufSize))
+	v.nonNegative(prefix+"tcp_w_buf_size", int64(c.TcpWBufSize))
+	v.nonNegative(prefix+"tcp_read_timeout", int64(c.TcpReadTimeout))
+	v.nonNegative(prefix+"tcp_write_timeout", int64(c.TcpWriteTimeout))
+	v.nonNegative(prefix+"wait_timeout", int64(c.WaitTimeout))
+	v.nonNegative(prefix+"max_msg_len", int64(c.MaxMsgLen))
+	if 0 < c.CronPeriod && c.CronPeriod < Duration(time.Millisecond) {
+		v.addf("%scron_period %s is shorter than 1ms", prefix, c.CronPeriod)
+	}
+	v.nonNegative(prefix+"cron_period", int64(c.CronPeriod))
+}
+
+// Validate returns a *ValidationError if any field of @c is illegal.
+func (c *SessionConfig) Validate() error {
+	var v validator
+	c.validate(&v, "")
+	return v.err()
+}
+
+// Apply sets the parameters of @c to @ss. The tcp parameters are applied to the tcp socket of a tcp, ws
+// or wss session. Servers and clients built by the config package call it with every new session.
+func (c *SessionConfig) Apply(ss getty.Session) error {
+	if c.CompressEncoding {
+		ss.SetCompressType(getty.CompressZip)
+	}
+	socketOptions := c.SocketOptions()
+	if err := socketOptions.Apply(ss.Conn()); err != nil {
+		return perrors.WithMessagef(err, "session %s", ss.Stat())
+	}
+	if c.SessionName != "" {
+		ss.SetName(c.SessionName)
+	}
+	if c.MaxMsgLen > 0 {
+		ss.SetMaxMsgLen(c.MaxMsgLen)
+	}
+	if c.TcpReadTimeout > 0 {
+		ss.SetReadTimeout(time.Duration(c.TcpReadTimeout))
+	}
+	if c.TcpWriteTimeout > 0 {
+		ss.SetWriteTimeout(time.Duration(c.TcpWriteTimeout))
+	}
+	if c.CronPeriod > 0 {
+		ss.SetCronPeriod(int(time.Duration(c.CronPeriod) / time.Millisecond))
+	}
+	if c.WaitTimeout > 0 {
+		ss.SetWaitTime(time.Duration(c.WaitTimeout))
+	}
+	return nil
+}
+
+// SocketOptions returns the tcp socket options of @c.
+func (c *SessionConfig) SocketOptions() getty.SocketOptions {
+	opts := getty.SocketOptions{
+		DisableNoDelay: !c.TcpNoDelay,
+		KeepAlive:      time.Duration(c.KeepAlivePeriod),
+		ReadBuffer:     c.TcpRBufSize,
+		WriteBuffer:    c.TcpWBufSize,
+	}
+	if !c.TcpKeepAlive {
+		opts.KeepAlive = -1
+	}
+	return opts
+}
+
+// ServerConfig is the config of a getty server.
+type ServerConfig struct {
+	// tcp(default), udp, ws or wss
+	Network string `yaml:"network" toml:"network" json:"network,omitempty"`
+	// the listening address
+	Addr string `yaml:"addr" toml:"addr" json:"addr,omitempty"`
+	// the websocket request path of a ws/wss server, e.g. /echo
+	Path string `yaml:"path" toml:"path" json:"path,omitempty"`
+	// the certificate, the private key and the root certificate files of a wss server
+	Cert       string `yaml:"cert" toml:"cert" json:"cert,omitempty"`
+	PrivateKey string `yaml:"private_key" toml:"private_key" json:"private_key,omitempty"`
+	CACert     string `yaml:"ca_cert" toml:"ca_cert" json:"ca_cert,omitempty"`
+
+	Session SessionConfig `yaml:"session" toml:"session" json:"session"`
+}
+
+// DefaultServerConfig returns the config of a tcp server with DefaultSessionConfig.
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Network: NetworkTCP,
+		Session: DefaultSessionConfig(),
+	}
+}
+
+// Validate returns a *ValidationError if any field of @c is illegal, so that NewServer does not panic. The
+// certificate files of a wss server are loaded to check them.
+func (c *ServerConfig) Validate() error {
+	var v validator
+	switch c.Network {
+	case NetworkTCP, NetworkUDP, NetworkWS, NetworkWSS:
+	default:
+		v.addf("illegal network %q", c.Network)
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		v.addf("illegal addr %q: %v", c.Addr, err)
+	}
+	if (c.Network == NetworkWS || c.Network == NetworkWSS) && !strings.HasPrefix(c.Path, "/") {
+		v.addf("the path %q of the %s server does not start with /", c.Path, c.Network)
+	}
+	if c.Network == NetworkWSS {
+		switch {
+		case c.Cert == "":
+			v.addf("cert of the wss server is empty")
+		case c.PrivateKey == "":
+			v.addf("private_key of the wss server is empty")
+		default:
+			v.keyPair(c.Cert, c.PrivateKey)
+		}
+		if c.CACert != "" {
+			v.certificates("ca_cert", c.CACert)
+		}
+	}
+	c.Session.validate(&v, "session.")
+	return v.err()
+}
+
+// NewServer validates @c and returns the server of it. @opts are applied after the options built from @c.
+// The session parameters are applied to every new session before the NewSessionCallback of RunEventLoop.
+func (c *ServerConfig) NewServer(opts ...getty.ServerOption) (getty.Server, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	session := c.Session
+	serverOpts := []getty.ServerOption{
+		getty.WithLocalAddress(c.Addr),
+		getty.WithServerSessionInit(session.Apply),
+	}
+	if c.Path != "" {
+		serverOpts = append(serverOpts, getty.WithWebsocketServerPath(c.Path))
+	}
+	if c.Network == NetworkWSS {
+		serverOpts = append(serverOpts,
+			getty.WithWebsocketServerCert(c.Cert),
+			getty.WithWebsocketServerPrivateKey(c.PrivateKey),
+			getty.WithWebsocketServerRootCert(c.CACert),
+		)
+	}
+	serverOpts = append(serverOpts, opts...)
+
+	switch c.Network {
+	case NetworkUDP:
+		return getty.NewUDPEndPoint(serverOpts...), nil
+	case NetworkWS:
+		return getty.NewWSServer(serverOpts...), nil
+	case NetworkWSS:
+		return getty.NewWSSServer(serverOpts...), nil
+	}
+	return getty.NewTCPServer(serverOpts...), nil
+}
+
+// ClientConfig is the config of a getty client.
+type ClientConfig struct {
+	// tcp(default), udp, ws or wss
+	Network string `yaml:"network" toml:"network" json:"network,omitempty"`
+	// the server address, the url such as ws://127.0.0.1:8080/echo of a ws/wss server
+	Addr string `yaml:"addr" toml:"addr" json:"addr,omitempty"`
+	// the number of the connections to the server
+	ConnectionNumber int `yaml:"connection_number" toml:"connection_number" json:"connection_number,omitempty"`
+	// the interval and the maximum number of the reconnections
+	ReconnectInterval Duration `yaml:"reconnect_interval" toml:"reconnect_interval" json:"reconnect_interval,omitempty"`
+	ReconnectAttempts int      `yaml:"reconnect_attempts" toml:"reconnect_attempts" json:"reconnect_attempts,omitempty"`
+	// the certificate file of the wss server
+	Cert string `yaml:"cert" toml:"cert" json:"cert,omitempty"`
+
+	Session SessionConfig `yaml:"session" toml:"session" json:"session"`
+}
+
+// DefaultClientConfig returns the config of a tcp client of one connection with DefaultSessionConfig.
+func DefaultClientConfig() *ClientConfig {
+	return &ClientConfig{
+		Network:          NetworkTCP,
+		ConnectionNumber: 1,
+		Session:          DefaultSessionConfig(),
+	}
+}
+
+// Validate returns a *ValidationError if any field of @c is illegal, so that NewClient does not panic. The
+// certificate file of a wss client is loaded to check it.
+func (c *ClientConfig) Validate() error {
+	var v validator
+	switch c.Network {
+	case NetworkTCP, NetworkUDP:
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			v.addf("illegal addr %q: %v", c.Addr, err)
+		}
+	case NetworkWS, NetworkWSS:
+		if !strings.HasPrefix(c.Addr, c.Network+"://") {
+			v.addf("the prefix of addr %q is not %s://", c.Addr, c.Network)
+		}
+		if c.Network == NetworkWSS {
+			if c.Cert == "" {
+				v.addf("cert of the wss client is empty")
+			} else {
+				v.certificates("cert", c.Cert)
+			}
+		}
+	default:
+		v.addf("illegal network %q", c.Network)
+	}
+	v.positive("connection_number", int64(c.ConnectionNumber))
+	v.nonNegative("reconnect_interval", int64(c.ReconnectInterval))
+	v.nonNegative("reconnect_attempts", int64(c.ReconnectAttempts))
+	c.Session.validate(&v, "session.")
+	return v.err()
+}
+
+// NewClient validates @c and returns the client of it. @opts are applied after the options built from @c.
+// The session parameters are applied to every new session before the NewSessionCallback of RunEventLoop.
+func (c *ClientConfig) NewClient(opts ...getty.ClientOption) (getty.Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	session := c.Session
+	clientOpts := []getty.ClientOption{
+		getty.WithServerAddress(c.Addr),
+		getty.WithConnectionNumber(c.ConnectionNumber),
+		getty.WithReconnectInterval(int(c.ReconnectInterval)),
+		getty.WithClientSessionInit(session.Apply),
+	}
+	if c.ReconnectAttempts > 0 {
+		clientOpts = append(clientOpts, getty.WithReconnectAttempts(c.ReconnectAttempts))
+	}
+	if c.Network == NetworkWSS {
+		clientOpts = append(clientOpts, getty.WithRootCertificateFile(c.Cert))
+	}
+	clientOpts = append(clientOpts, opts...)
+
+	switch c.Network {
+	case NetworkUDP:
+		return getty.NewUDPClient(clientOpts...), nil
+	case NetworkWS:
+		return getty.NewWSClient(clientOpts...), nil
+	case NetworkWSS:
+		return getty.NewWSSClient(clientOpts...), nil
+	}
+	return getty.NewTCPClient(clientOpts...), nil
+}
@@ -0,0 +1,237 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	getty "github.com/AlexStocks/getty/transport"
+)
+
+var configFiles = map[string]string{
+	"server.yml": `
+network: tcp
+addr: 127.0.0.1:0
+session:
+  tcp_no_delay: false
+  keep_alive_period: 3m
+  tcp_r_buf_size: 262144
+  tcp_read_timeout: 1s
+  max_msg_len: 4096
+  session_name: echo-server
+`,
+	"server.toml": `
+network = "tcp"
+addr = "127.0.0.1:0"
+[session]
+tcp_no_delay = false
+keep_alive_period = "3m"
+tcp_r_buf_size = 262144
+tcp_read_timeout = "1s"
+max_msg_len = 4096
+session_name = "echo-server"
+`,
+	"server.json": `{
+	"network": "tcp",
+	"addr": "127.0.0.1:0",
+	"session": {
+		"tcp_no_delay": false,
+		"keep_alive_period": "3m",
+		"tcp_r_buf_size": 262144,
+		"tcp_read_timeout": "1s",
+		"max_msg_len": 4096,
+		"session_name": "echo-server"
+	}
+}`,
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	for name, content := range configFiles {
+		conf, err := LoadServerConfig(writeFile(t, name, content), "")
+		assert.Nil(t, err, name)
+		assert.Equal(t, &ServerConfig{
+			Network: NetworkTCP,
+			Addr:    "127.0.0.1:0",
+			Session: SessionConfig{
+				TcpKeepAlive:    true,
+				KeepAlivePeriod: Duration(3 * time.Minute),
+				TcpRBufSize:     262144,
+				TcpReadTimeout:  Duration(time.Second),
+				MaxMsgLen:       4096,
+				SessionName:     "echo-server",
+			},
+		}, conf, name)
+	}
+
+	// the environment variables override the file
+	t.Setenv("GETTY_ADDR", "127.0.0.1:8080")
+	t.Setenv("GETTY_SESSION_TCP_NO_DELAY", "true")
+	t.Setenv("GETTY_SESSION_WAIT_TIMEOUT", "7s")
+	t.Setenv("GETTY_SESSION_MAX_MSG_LEN", "1024")
+	conf, err := LoadServerConfig(writeFile(t, "server.yml", configFiles["server.yml"]), "GETTY")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8080", conf.Addr)
+	assert.True(t, conf.Session.TcpNoDelay)
+	assert.Equal(t, Duration(7*time.Second), conf.Session.WaitTimeout)
+	assert.Equal(t, 1024, conf.Session.MaxMsgLen)
+	assert.Equal(t, "echo-server", conf.Session.SessionName)
+
+	t.Setenv("GETTY_SESSION_MAX_MSG_LEN", "1k")
+	_, err = LoadServerConfig("", "GETTY")
+	assert.NotNil(t, err)
+}
+
+func TestLoadClientConfig(t *testing.T) {
+	t.Setenv("CLIENT_ADDR", "127.0.0.1:8080")
+	t.Setenv("CLIENT_RECONNECT_INTERVAL", "100ms")
+	conf, err := LoadClientConfig("", "CLIENT")
+	assert.Nil(t, err)
+	assert.Equal(t, &ClientConfig{
+		Network:           NetworkTCP,
+		Addr:              "127.0.0.1:8080",
+		ConnectionNumber:  1,
+		ReconnectInterval: Duration(100 * time.Millisecond),
+		Session:           DefaultSessionConfig(),
+	}, conf)
+
+	_, err = LoadClientConfig(writeFile(t, "client.yml", "addr: 127.0.0.1:8080\nretries: 3\n"), "")
+	assert.NotNil(t, err)
+	_, err = LoadClientConfig(writeFile(t, "client.toml", "addr = \"127.0.0.1:8080\"\nretries = 3\n"), "")
+	assert.NotNil(t, err)
+	_, err = LoadClientConfig(writeFile(t, "client.ini", "addr=127.0.0.1:8080\n"), "")
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	server := DefaultServerConfig()
+	server.Network = NetworkWSS
+	server.Addr = "127.0.0.1"
+	server.Session.MaxMsgLen = -1
+	server.Session.CronPeriod = Duration(time.Microsecond)
+	var verr *ValidationError
+	assert.True(t, errors.As(server.Validate(), &verr))
+	assert.Equal(t, 5, len(verr.Problems), verr.Problems)
+	_, err := server.NewServer()
+	assert.True(t, errors.As(err, &verr))
+
+	client := DefaultClientConfig()
+	client.Network = NetworkWS
+	client.Addr = "127.0.0.1:8080"
+	client.ConnectionNumber = -1
+	assert.True(t, errors.As(client.Validate(), &verr))
+	assert.Equal(t, 2, len(verr.Problems), verr.Problems)
+	client.Network = "quic"
+	assert.NotNil(t, client.Validate())
+	// a client of no connection panics
+	client = DefaultClientConfig()
+	client.Addr = "127.0.0.1:8080"
+	client.ConnectionNumber = 0
+	_, err = client.NewClient()
+	assert.True(t, errors.As(err, &verr))
+	client.ConnectionNumber = 1
+	client.Network = NetworkWSS
+	client.Addr = "wss://127.0.0.1:8080/echo"
+	client.Cert = writeFile(t, "client.pem", "not a certificate")
+	assert.True(t, errors.As(client.Validate(), &verr))
+	assert.Contains(t, verr.Problems[0], "no certificate")
+
+	server = DefaultServerConfig()
+	server.Network = NetworkWS
+	server.Addr = "127.0.0.1:0"
+	assert.True(t, errors.As(server.Validate(), &verr))
+	assert.Contains(t, verr.Problems[0], "path")
+	server.Path = "/echo"
+	assert.Nil(t, server.Validate())
+	server.Network = NetworkWSS
+	server.Cert, server.PrivateKey = client.Cert, client.Cert
+	assert.True(t, errors.As(server.Validate(), &verr))
+	assert.Equal(t, 1, len(verr.Problems), verr.Problems)
+
+	_, err = LoadClientConfig(writeFile(t, "client.yml", "network: ws\naddr: ws://127.0.0.1:8080/echo\n"), "")
+	assert.Nil(t, err)
+	_, err = LoadClientConfig(writeFile(t, "client.yml", "network: wss\naddr: wss://127.0.0.1:8080/echo\n"), "")
+	assert.True(t, errors.As(err, &verr))
+}
+
+type echoHandler struct{}
+
+func (echoHandler) Read(_ getty.Session, data []byte) (any, int, error) {
+	return data, len(data), nil
+}
+
+func (echoHandler) Write(_ getty.Session, pkg any) ([]byte, error) {
+	return pkg.([]byte), nil
+}
+
+func (echoHandler) OnOpen(getty.Session) error   { return nil }
+func (echoHandler) OnClose(getty.Session)        {}
+func (echoHandler) OnError(getty.Session, error) {}
+func (echoHandler) OnCron(getty.Session)         {}
+func (echoHandler) OnMessage(getty.Session, any) {}
+
+func TestNewServerAndClient(t *testing.T) {
+	serverConf := DefaultServerConfig()
+	serverConf.Addr = "127.0.0.1:0"
+	serverConf.Session.SessionName = "config-server"
+	serverConf.Session.MaxMsgLen = 128
+	serverConf.Session.TcpRBufSize = 8192
+	server, err := serverConf.NewServer()
+	assert.Nil(t, err)
+	names := make(chan string, 1)
+	server.RunEventLoop(func(session getty.Session) error {
+		// the session config is applied before the callback
+		names <- session.Stat()
+		session.SetPkgHandler(echoHandler{})
+		session.SetEventListener(echoHandler{})
+		return nil
+	})
+	defer server.Close()
+
+	clientConf := DefaultClientConfig()
+	clientConf.Addr = server.(getty.StreamServer).Listener().Addr().String()
+	clientConf.Session.SessionName = "config-client"
+	client, err := clientConf.NewClient()
+	assert.Nil(t, err)
+	sessions := make(chan getty.Session, 1)
+	client.RunEventLoop(func(session getty.Session) error {
+		session.SetPkgHandler(echoHandler{})
+		session.SetEventListener(echoHandler{})
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	assert.Contains(t, <-names, "config-server")
+	assert.Contains(t, (<-sessions).Stat(), "config-client")
+}
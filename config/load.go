@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/BurntSushi/toml"
+
+	perrors "github.com/pkg/errors"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LoadServerConfig returns the server config of DefaultServerConfig overridden by the file @path and then by
+// the environment variables prefixed by @envPrefix. See Load.
+func LoadServerConfig(path, envPrefix string) (*ServerConfig, error) {
+	conf := DefaultServerConfig()
+	if err := Load(path, envPrefix, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadClientConfig returns the client config of DefaultClientConfig overridden by the file @path and then by
+// the environment variables prefixed by @envPrefix. See Load.
+func LoadClientConfig(path, envPrefix string) (*ClientConfig, error) {
+	conf := DefaultClientConfig()
+	if err := Load(path, envPrefix, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Load decodes the file @path into @conf, a *ServerConfig, a *ClientConfig or a *SessionConfig, and validates
+// it. The format of the file is chosen by its extension: .yml/.yaml, .toml or .json. An unknown key is an error.
+// No file is read if @path is empty.
+//
+// The environment variables named by @envPrefix and the yaml keys of the fields override the file, e.g.
+// GETTY_ADDR and GETTY_SESSION_TCP_NO_DELAY when @envPrefix is GETTY. No variable is read if @envPrefix is empty.
+func Load(path, envPrefix string, conf interface{ Validate() error }) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return perrors.WithStack(err)
+		}
+		if err = decode(filepath.Ext(path), data, conf); err != nil {
+			return perrors.WithMessagef(err, "failed to decode %s", path)
+		}
+	}
+	if envPrefix != "" {
+		v := reflect.ValueOf(conf)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return perrors.Errorf("illegal config %T", conf)
+		}
+		if err := loadEnv(envPrefix, v.Elem()); err != nil {
+			return err
+		}
+	}
+	return conf.Validate()
+}
+
+func decode(ext string, data []byte, conf any) error {
+	switch strings.ToLower(ext) {
+	case ".yml", ".yaml":
+		return perrors.WithStack(yaml.UnmarshalStrict(data, conf))
+	case ".toml":
+		md, err := toml.Decode(string(data), conf)
+		if err != nil {
+			return perrors.WithStack(err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return perrors.Errorf("unknown keys %v", undecoded)
+		}
+		return nil
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return perrors.WithStack(dec.Decode(conf))
+	}
+	return perrors.Errorf("unsupported config file extension %q", ext)
+}
+
+// loadEnv sets the fields of @v by the environment variables named by @prefix and their yaml keys.
+func loadEnv(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+		if _, ok := field.Addr().Interface().(encoding.TextUnmarshaler); !ok && field.Kind() == reflect.Struct {
+			if err := loadEnv(name, field); err != nil {
+				return err
+			}
+			continue
+		}
+		text, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, text); err != nil {
+			return perrors.WithMessagef(err, "illegal environment variable %s=%q", name, text)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, text string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	default:
+		return perrors.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -18,12 +18,11 @@
 package main
 
 import (
-	"fmt"
-	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -42,6 +41,7 @@ import (
 
 const (
 	pprofPath = "/debug/pprof/"
+	adminPath = "/debug/getty/"
 )
 
 var (
@@ -52,6 +52,7 @@ var (
 var (
 	serverList []getty.Server
 	taskPool   gxsync.GenericTaskPool
+	admin      = getty.NewAdminHandler()
 )
 
 func main() {
@@ -78,43 +79,18 @@ func initProfiling() {
 	// addr = *host + ":" + "10000"
 	addr := gxnet.HostAddress(conf.Host, conf.ProfilePort)
 	log.Info("App Profiling startup on address{%v}", addr+pprofPath)
+	http.Handle(adminPath, http.StripPrefix(strings.TrimSuffix(adminPath, "/"), admin))
+	log.Info("App admin startup on address{%v}", addr+adminPath)
 	go func() {
 		log.Info(http.ListenAndServe(addr, nil))
 	}()
 }
 
 func newSession(session getty.Session) error {
-	var (
-		ok      bool
-		tcpConn *net.TCPConn
-	)
-
 	if conf.GettySessionParam.CompressEncoding {
 		session.SetCompressType(getty.CompressZip)
 	}
 
-	if tcpConn, ok = session.Conn().(*net.TCPConn); !ok {
-		panic(fmt.Sprintf("%s, session.conn{%#v} is not tcp connection\n", session.Stat(), session.Conn()))
-	}
-
-	if err := tcpConn.SetNoDelay(conf.GettySessionParam.TcpNoDelay); err != nil {
-		log.Warnf("SetNoDelay error: %+v", err)
-	}
-	if err := tcpConn.SetKeepAlive(conf.GettySessionParam.TcpKeepAlive); err != nil {
-		log.Warnf("SetKeepAlive error: %+v", err)
-	}
-	if conf.GettySessionParam.TcpKeepAlive {
-		if err := tcpConn.SetKeepAlivePeriod(conf.GettySessionParam.keepAlivePeriod); err != nil {
-			log.Warnf("SetKeepAlivePeriod error: %+v", err)
-		}
-	}
-	if err := tcpConn.SetReadBuffer(conf.GettySessionParam.TcpRBufSize); err != nil {
-		log.Warnf("SetReadBuffer error: %+v", err)
-	}
-	if err := tcpConn.SetWriteBuffer(conf.GettySessionParam.TcpWBufSize); err != nil {
-		log.Warnf("SetWriteBuffer error: %+v", err)
-	}
-
 	session.SetName(conf.GettySessionParam.SessionName)
 	session.SetMaxMsgLen(conf.GettySessionParam.MaxMsgLen)
 	session.SetPkgHandler(echoPkgHandler)
@@ -129,6 +105,20 @@ func newSession(session getty.Session) error {
 	return nil
 }
 
+// socketOptions returns the tcp socket options of the sessions
+func socketOptions() getty.SocketOptions {
+	opts := getty.SocketOptions{
+		DisableNoDelay: !conf.GettySessionParam.TcpNoDelay,
+		KeepAlive:      conf.GettySessionParam.keepAlivePeriod,
+		ReadBuffer:     conf.GettySessionParam.TcpRBufSize,
+		WriteBuffer:    conf.GettySessionParam.TcpWBufSize,
+	}
+	if !conf.GettySessionParam.TcpKeepAlive {
+		opts.KeepAlive = -1
+	}
+	return opts
+}
+
 func initServer() {
 	var (
 		addr     string
@@ -153,11 +143,13 @@ func initServer() {
 		addr = gxnet.HostAddress2(conf.Host, port)
 		serverOpts := []getty.ServerOption{getty.WithLocalAddress(addr)}
 		serverOpts = append(serverOpts, getty.WithServerTaskPool(taskPool))
+		serverOpts = append(serverOpts, getty.WithServerSocketOptions(socketOptions()))
 		server = getty.NewTCPServer(serverOpts...)
 		// run server
 		server.RunEventLoop(newSession)
 		log.Debug("server bind addr{%s} ok!", addr)
 		serverList = append(serverList, server)
+		admin.Register(server)
 	}
 }
 
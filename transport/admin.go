@@ -0,0 +1,356 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
+)
+
+// AdminSessionInfo is the runtime state of a session
+type AdminSessionInfo struct {
+	ID         uint32    `json:"id"`
+	Name       string    `json:"name"`
+	LocalAddr  string    `json:"local_addr"`
+	RemoteAddr string    `json:"remote_addr"`
+	Created    time.Time `json:"created"`
+	Age        string    `json:"age"`
+	LastActive time.Time `json:"last_active"`
+	ReadBytes  uint32    `json:"read_bytes"`
+	WriteBytes uint32    `json:"write_bytes"`
+	ReadPkgs   uint32    `json:"read_pkgs"`
+	WritePkgs  uint32    `json:"write_pkgs"`
+	Goroutines int32     `json:"goroutines"`
+}
+
+// AdminEndPointInfo is the runtime state of an endpoint and its alive sessions
+type AdminEndPointInfo struct {
+	ID       EndPointID         `json:"id"`
+	Type     string             `json:"type"`
+	Addr     string             `json:"addr"`
+	Closed   bool               `json:"closed"`
+	Sessions []AdminSessionInfo `json:"sessions"`
+	// the traffic totals of all of the sessions, the closed ones included
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadPkgs   uint64 `json:"read_pkgs"`
+	WritePkgs  uint64 `json:"write_pkgs"`
+}
+
+// AdminHandler is an optional http.Handler showing what the registered endpoints are doing:
+//
+//	GET  /endpoints                 the endpoints and their sessions in JSON
+//	POST /sessions/close?id=<id>    closes the session of @id
+//	GET  /loglevel                  the logger level
+//	POST /loglevel?level=<level>    sets the logger level, e.g. debug, info, warn or error. It only affects
+//	                                the default logger of getty, see log.SetLoggerLevel
+//	GET  /metrics                   the session stats in the Prometheus text format
+//
+// Mount it under a prefix with http.StripPrefix, and do not expose it to the untrusted network.
+type AdminHandler struct {
+	lock      sync.RWMutex
+	endPoints []EndPoint
+	mux       *http.ServeMux
+}
+
+// NewAdminHandler returns an admin handler of @endPoints. More endpoints can be added by Register.
+func NewAdminHandler(endPoints ...EndPoint) *AdminHandler {
+	h := &AdminHandler{mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /endpoints", h.serveEndPoints)
+	h.mux.HandleFunc("POST /sessions/close", h.serveCloseSession)
+	h.mux.HandleFunc("GET /loglevel", h.serveLogLevel)
+	h.mux.HandleFunc("POST /loglevel", h.serveSetLogLevel)
+	h.mux.HandleFunc("GET /metrics", h.serveMetrics)
+	h.Register(endPoints...)
+	return h
+}
+
+// Register adds @endPoints to the handler. An endpoint is only registered once.
+func (h *AdminHandler) Register(endPoints ...EndPoint) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, endPoint := range endPoints {
+		if h.index(endPoint) < 0 {
+			h.endPoints = append(h.endPoints, endPoint)
+		}
+	}
+}
+
+// Unregister removes @endPoint from the handler.
+func (h *AdminHandler) Unregister(endPoint EndPoint) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if i := h.index(endPoint); i >= 0 {
+		h.endPoints = append(h.endPoints[:i], h.endPoints[i+1:]...)
+	}
+}
+
+func (h *AdminHandler) index(endPoint EndPoint) int {
+	for i, ep := range h.endPoints {
+		if ep == endPoint {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// EndPoints returns the runtime state of the registered endpoints.
+func (h *AdminHandler) EndPoints() []AdminEndPointInfo {
+	h.lock.RLock()
+	endPoints := make([]EndPoint, len(h.endPoints))
+	copy(endPoints, h.endPoints)
+	h.lock.RUnlock()
+
+	now := time.Now()
+	infos := make([]AdminEndPointInfo, 0, len(endPoints))
+	for _, endPoint := range endPoints {
+		info := AdminEndPointInfo{
+			ID:       endPoint.ID(),
+			Type:     endPoint.EndPointType().String(),
+			Addr:     endPointAddr(endPoint),
+			Closed:   endPoint.IsClosed(),
+			Sessions: []AdminSessionInfo{},
+		}
+		if stats := endPointStatsOf(endPoint); stats != nil {
+			info.ReadBytes = stats.readBytes.Load()
+			info.WriteBytes = stats.writeBytes.Load()
+			info.ReadPkgs = stats.readPkgNum.Load()
+			info.WritePkgs = stats.writePkgNum.Load()
+		}
+		for _, ss := range endPointSessions(endPoint) {
+			conn := ss.gettyConn()
+			if conn == nil {
+				continue
+			}
+			ss.lock.RLock()
+			name := ss.name
+			ss.lock.RUnlock()
+			info.Sessions = append(info.Sessions, AdminSessionInfo{
+				ID:         conn.id,
+				Name:       name,
+				LocalAddr:  conn.local,
+				RemoteAddr: conn.peer,
+				Created:    ss.created,
+				Age:        now.Sub(ss.created).Round(time.Millisecond).String(),
+				LastActive: conn.GetActive(),
+				ReadBytes:  conn.readBytes.Load(),
+				WriteBytes: conn.writeBytes.Load(),
+				ReadPkgs:   conn.readPkgNum.Load(),
+				WritePkgs:  conn.writePkgNum.Load(),
+				Goroutines: ss.grNum.Load(),
+			})
+		}
+		sort.Slice(info.Sessions, func(i, j int) bool { return info.Sessions[i].ID < info.Sessions[j].ID })
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// CloseSession closes the session of @id and returns false if no registered endpoint has it.
+func (h *AdminHandler) CloseSession(id uint32) bool {
+	h.lock.RLock()
+	endPoints := make([]EndPoint, len(h.endPoints))
+	copy(endPoints, h.endPoints)
+	h.lock.RUnlock()
+
+	for _, endPoint := range endPoints {
+		for _, ss := range endPointSessions(endPoint) {
+			if ss.ID() == id {
+				ss.logger.Infow("session closed by admin")
+				ss.Close()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *AdminHandler) serveEndPoints(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, h.EndPoints())
+}
+
+func (h *AdminHandler) serveCloseSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("illegal session id: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !h.CloseSession(uint32(id)) {
+		http.Error(w, fmt.Sprintf("session %d not found", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) serveLogLevel(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, map[string]string{"level": log.GetLoggerLevel().String()})
+}
+
+func (h *AdminHandler) serveSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	text := r.URL.Query().Get("level")
+	if text == "" {
+		http.Error(w, "missing level", http.StatusBadRequest)
+		return
+	}
+	level, err := log.ParseLoggerLevel(text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = log.SetLoggerLevel(level); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("logger level set to %s by admin", level)
+	h.serveLogLevel(w, r)
+}
+
+// adminGauges are the gauges of the alive sessions of an endpoint
+var adminGauges = []struct {
+	name  string
+	help  string
+	value func(info *AdminSessionInfo) float64
+}{
+	{"getty_sessions", "Number of the alive sessions.", func(*AdminSessionInfo) float64 { return 1 }},
+	{"getty_session_goroutines", "Goroutines of the alive sessions.", func(info *AdminSessionInfo) float64 { return float64(info.Goroutines) }},
+}
+
+// adminCounters are the traffic totals of an endpoint
+var adminCounters = []struct {
+	name  string
+	help  string
+	value func(info *AdminEndPointInfo) uint64
+}{
+	{"getty_read_bytes_total", "Bytes read by the sessions.", func(info *AdminEndPointInfo) uint64 { return info.ReadBytes }},
+	{"getty_write_bytes_total", "Bytes written by the sessions.", func(info *AdminEndPointInfo) uint64 { return info.WriteBytes }},
+	{"getty_read_pkgs_total", "Packages read by the sessions.", func(info *AdminEndPointInfo) uint64 { return info.ReadPkgs }},
+	{"getty_write_pkgs_total", "Packages written by the sessions.", func(info *AdminEndPointInfo) uint64 { return info.WritePkgs }},
+}
+
+func (h *AdminHandler) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeAdminMetrics(w, h.EndPoints())
+}
+
+// writeAdminMetrics writes the metrics of @infos in the Prometheus text exposition format.
+func writeAdminMetrics(w io.Writer, infos []AdminEndPointInfo) {
+	fmt.Fprintf(w, "# HELP getty_endpoint_up Whether the endpoint is running.\n# TYPE getty_endpoint_up gauge\n")
+	for i := range infos {
+		up := 1
+		if infos[i].Closed {
+			up = 0
+		}
+		fmt.Fprintf(w, "getty_endpoint_up%s %d\n", adminLabels(&infos[i]), up)
+	}
+	for _, metric := range adminGauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for i := range infos {
+			var sum float64
+			for j := range infos[i].Sessions {
+				sum += metric.value(&infos[i].Sessions[j])
+			}
+			fmt.Fprintf(w, "%s%s %s\n", metric.name, adminLabels(&infos[i]),
+				strconv.FormatFloat(sum, 'g', -1, 64))
+		}
+	}
+	for _, metric := range adminCounters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for i := range infos {
+			fmt.Fprintf(w, "%s%s %d\n", metric.name, adminLabels(&infos[i]), metric.value(&infos[i]))
+		}
+	}
+}
+
+var adminLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func adminLabels(info *AdminEndPointInfo) string {
+	return fmt.Sprintf(`{endpoint="%s",endpoint_id="%d",addr="%s"}`,
+		info.Type, info.ID, adminLabelReplacer.Replace(info.Addr))
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Warnf("failed to encode the admin response: %v", err)
+	}
+}
+
+// endPointAddr returns the listening address of a server or the server address of a client.
+func endPointAddr(endPoint EndPoint) string {
+	switch ep := endPoint.(type) {
+	case *server:
+		return ep.addr
+	case *client:
+		return ep.addr
+	}
+	return ""
+}
+
+// endPointStatsOf returns the traffic totals of a server or a client.
+func endPointStatsOf(endPoint EndPoint) *endPointStats {
+	switch ep := endPoint.(type) {
+	case *server:
+		return &ep.stats
+	case *client:
+		return &ep.stats
+	}
+	return nil
+}
+
+// endPointSessions returns the alive sessions of @endPoint.
+func endPointSessions(endPoint EndPoint) []*session {
+	var sessions []*session
+	switch ep := endPoint.(type) {
+	case *server:
+		ep.lock.Lock()
+		for ss := range ep.sessions {
+			if s, ok := ss.(*session); ok && !s.IsClosed() {
+				sessions = append(sessions, s)
+			}
+		}
+		ep.lock.Unlock()
+	case *client:
+		ep.Lock()
+		for ss := range ep.ssMap {
+			if s, ok := ss.(*session); ok && !s.IsClosed() {
+				sessions = append(sessions, s)
+			}
+		}
+		ep.Unlock()
+	}
+	return sessions
+}
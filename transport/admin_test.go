@@ -0,0 +1,155 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
+)
+
+func TestAdminHandler(t *testing.T) {
+	listener := NewMemoryListener()
+	var serverHandler lineHandler
+	server := NewMemoryServer(listener)
+	server.RunEventLoop(func(session Session) error {
+		session.SetName("admin-server")
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		return nil
+	})
+	defer server.Close()
+
+	client := NewMemoryClient(listener)
+	clientSessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		clientSessions <- session
+		return nil
+	})
+	defer client.Close()
+	cs := <-clientSessions
+	_, _, err := cs.WritePkg("hello", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	admin := NewAdminHandler(server, client)
+	admin.Register(server)
+	ts := httptest.NewServer(http.StripPrefix("/debug/getty", admin))
+	defer ts.Close()
+
+	rsp, err := http.Get(ts.URL + "/debug/getty/endpoints")
+	assert.Nil(t, err)
+	var infos []AdminEndPointInfo
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&infos))
+	rsp.Body.Close()
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, TCP_SERVER.String(), infos[0].Type)
+	assert.Equal(t, TCP_CLIENT.String(), infos[1].Type)
+	assert.Equal(t, 1, len(infos[0].Sessions))
+	ss := infos[0].Sessions[0]
+	assert.Equal(t, "admin-server", ss.Name)
+	assert.Equal(t, uint32(len("hello\n")), ss.ReadBytes)
+	assert.Equal(t, uint32(1), ss.ReadPkgs)
+	assert.True(t, ss.Goroutines > 0)
+	assert.False(t, ss.Created.IsZero())
+
+	rsp, err = http.Get(ts.URL + "/debug/getty/metrics")
+	assert.Nil(t, err)
+	metrics := readAll(t, rsp)
+	assert.Contains(t, metrics, "# TYPE getty_sessions gauge\n")
+	assert.Contains(t, metrics,
+		fmt.Sprintf(`getty_read_bytes_total{endpoint="TCP_SERVER",endpoint_id="%d",addr="%s"} 6`, infos[0].ID, infos[0].Addr))
+	assert.Contains(t, metrics, "# TYPE getty_read_bytes_total counter\n")
+	assert.Contains(t, metrics,
+		fmt.Sprintf(`getty_endpoint_up{endpoint="TCP_CLIENT",endpoint_id="%d",addr="%s"} 1`, infos[1].ID, infos[1].Addr))
+
+	rsp, err = http.Post(fmt.Sprintf("%s/debug/getty/sessions/close?id=%d", ts.URL, ss.ID), "", nil)
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.Eventually(t, func() bool {
+		_, closed := serverHandler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	rsp, err = http.Post(fmt.Sprintf("%s/debug/getty/sessions/close?id=%d", ts.URL, ss.ID), "", nil)
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	// the totals keep the traffic of the closed session
+	infos = admin.EndPoints()
+	assert.Empty(t, infos[0].Sessions)
+	assert.Equal(t, uint64(len("hello\n")), infos[0].ReadBytes)
+	assert.Equal(t, uint64(1), infos[0].ReadPkgs)
+	rsp, err = http.Get(ts.URL + "/debug/getty/sessions/close?id=1")
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+
+	admin.Unregister(client)
+	assert.Equal(t, 1, len(admin.EndPoints()))
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	defer func() {
+		assert.Nil(t, log.SetLoggerLevel(log.LoggerLevelDebug))
+	}()
+	ts := httptest.NewServer(NewAdminHandler())
+	defer ts.Close()
+
+	rsp, err := http.Post(ts.URL+"/loglevel?level=warn", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.JSONEq(t, `{"level":"warn"}`, readAll(t, rsp))
+	assert.Equal(t, log.LoggerLevelWarn, log.GetLoggerLevel())
+
+	rsp, err = http.Get(ts.URL + "/loglevel")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"level":"warn"}`, readAll(t, rsp))
+
+	for _, query := range []string{"", "?level=verbose"} {
+		rsp, err = http.Post(ts.URL+"/loglevel"+query, "", nil)
+		assert.Nil(t, err)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	}
+	assert.Equal(t, log.LoggerLevelWarn, log.GetLoggerLevel())
+}
+
+func readAll(t *testing.T, rsp *http.Response) string {
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+var (
+	// ErrConnectionDenied is returned when the source address of a connection is denied
+	ErrConnectionDenied = perrors.New("connection denied")
+	// ErrTooManyConnections is returned when a connection exceeds the connection limits of a server
+	ErrTooManyConnections = perrors.New("too many connections")
+)
+
+// stringAddr is a net.Addr of an address string like http.Request.RemoteAddr
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+// admissionControl decides whether a server accepts a connection before a session is built for it.
+// A nil admissionControl admits every connection.
+type admissionControl struct {
+	// the limits are set by the server options before the server runs
+	maxConns       int
+	maxSourceConns int
+	sourcePrefixV4 int
+	sourcePrefixV6 int
+	allow          []*net.IPNet // nil if every source is allowed, empty if no source is allowed
+	deny           []*net.IPNet
+	acceptRate     *tokenBucket
+	lock           sync.Mutex
+	conns          int
+	sourceConns    map[string]int
+}
+
+func newAdmissionControl() *admissionControl {
+	return &admissionControl{
+		sourcePrefixV4: 8 * net.IPv4len,
+		sourcePrefixV6: 8 * net.IPv6len,
+		sourceConns:    make(map[string]int),
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func ipNetsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// source returns the network of @ip whose connections are counted together.
+func (a *admissionControl) source(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(a.sourcePrefixV4, 8*net.IPv4len)).String()
+	}
+	return ip.Mask(net.CIDRMask(a.sourcePrefixV6, 8*net.IPv6len)).String()
+}
+
+// admit checks the connection from @addr against the allow/deny lists, the accept rate and the connection
+// limits, and counts it if it is admitted. An admitted connection should be released after it is closed.
+func (a *admissionControl) admit(addr net.Addr) error {
+	if a == nil {
+		return nil
+	}
+
+	ip := addrIP(addr)
+	if a.allow != nil || len(a.deny) != 0 {
+		if ip == nil {
+			return perrors.Wrapf(ErrConnectionDenied, "unknown source address %s", addr)
+		}
+		if ipNetsContain(a.deny, ip) || (a.allow != nil && !ipNetsContain(a.allow, ip)) {
+			return perrors.Wrapf(ErrConnectionDenied, "source address %s", addr)
+		}
+	}
+	if !a.acceptRate.take(1, time.Now()) {
+		return perrors.Wrapf(ErrTooManyConnections, "accept rate exceeded, source address %s", addr)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// the token of a connection rejected by the connection limits is returned
+	if a.maxConns > 0 && a.conns >= a.maxConns {
+		a.acceptRate.refund(1)
+		return perrors.Wrapf(ErrTooManyConnections, "%d connections", a.conns)
+	}
+	if a.maxSourceConns > 0 && ip != nil {
+		source := a.source(ip)
+		if a.sourceConns[source] >= a.maxSourceConns {
+			a.acceptRate.refund(1)
+			return perrors.Wrapf(ErrTooManyConnections, "%d connections from %s", a.sourceConns[source], source)
+		}
+		a.sourceConns[source]++
+	}
+	a.conns++
+
+	return nil
+}
+
+// release uncounts the connection from @addr admitted by admit.
+func (a *admissionControl) release(addr net.Addr) {
+	if a == nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.conns--
+	if ip := addrIP(addr); a.maxSourceConns > 0 && ip != nil {
+		source := a.source(ip)
+		if a.sourceConns[source]--; a.sourceConns[source] <= 0 {
+			delete(a.sourceConns, source)
+		}
+	}
+}
+
+// releaseOnClose releases the connection of @ss after the session is closed.
+func (a *admissionControl) releaseOnClose(ss Session, addr net.Addr) {
+	if a == nil {
+		return
+	}
+
+	ss.AddCloseCallback(a, ss, func() {
+		a.release(addr)
+	})
+}
@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmissionControl(t *testing.T) {
+	var nilAdmission *admissionControl
+	assert.Nil(t, nilAdmission.admit(stringAddr("10.0.0.1:80")))
+	nilAdmission.release(stringAddr("10.0.0.1:80"))
+
+	var opts ServerOptions
+	WithServerAllowList("10.0.0.0/8", "::1")(&opts)
+	WithServerDenyList("10.1.0.0/16")(&opts)
+	WithServerMaxConnections(3)(&opts)
+	WithServerMaxConnectionsPerSource(2, 24, 0)(&opts)
+	a := opts.admission
+
+	assert.ErrorIs(t, a.admit(stringAddr("192.168.0.1:80")), ErrConnectionDenied)
+	assert.ErrorIs(t, a.admit(stringAddr("10.1.2.3:80")), ErrConnectionDenied)
+	assert.ErrorIs(t, a.admit(stringAddr("unknown")), ErrConnectionDenied)
+
+	assert.Nil(t, a.admit(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}))
+	assert.Nil(t, a.admit(stringAddr("10.0.0.2:80")))
+	// 10.0.0.1 and 10.0.0.2 are in the same /24 source
+	assert.ErrorIs(t, a.admit(stringAddr("10.0.0.3:80")), ErrTooManyConnections)
+	assert.Nil(t, a.admit(stringAddr("[::1]:80")))
+	assert.ErrorIs(t, a.admit(stringAddr("10.0.1.1:80")), ErrTooManyConnections)
+
+	a.release(stringAddr("10.0.0.2:80"))
+	assert.Nil(t, a.admit(stringAddr("10.0.0.3:80")))
+	a.release(stringAddr("10.0.0.3:80"))
+	a.release(stringAddr("10.0.0.1:80"))
+	a.release(stringAddr("[::1]:80"))
+	assert.Equal(t, 0, a.conns)
+	assert.Empty(t, a.sourceConns)
+
+	WithServerAcceptRate(2)(&opts)
+	assert.Nil(t, a.admit(stringAddr("10.0.0.1:80")))
+	assert.Nil(t, a.admit(stringAddr("10.0.1.1:80")))
+	assert.ErrorIs(t, a.admit(stringAddr("10.0.2.1:80")), ErrTooManyConnections)
+
+	// the accept rate token of a connection rejected by the connection limits is returned
+	var limited ServerOptions
+	WithServerMaxConnections(1)(&limited)
+	WithServerAcceptRate(2)(&limited)
+	a = limited.admission
+	assert.Nil(t, a.admit(stringAddr("10.0.0.1:80")))
+	assert.ErrorIs(t, a.admit(stringAddr("10.0.0.2:80")), ErrTooManyConnections)
+	a.release(stringAddr("10.0.0.1:80"))
+	assert.Nil(t, a.admit(stringAddr("10.0.0.2:80")))
+
+	assert.Panics(t, func() { WithServerMaxConnectionsPerSource(1, 33, 0)(&opts) })
+
+	// an illegal allow list accepts no connection, and an illegal deny list refuses all of them
+	var illegalAllow ServerOptions
+	WithServerAllowList("10.0.0.0/33")(&illegalAllow)
+	assert.Len(t, illegalAllow.optionErrs, 1)
+	assert.ErrorIs(t, illegalAllow.admission.admit(stringAddr("10.0.0.1:80")), ErrConnectionDenied)
+	var illegalDeny ServerOptions
+	WithServerDenyList("10.0.0.0/33")(&illegalDeny)
+	assert.Len(t, illegalDeny.optionErrs, 1)
+	assert.ErrorIs(t, illegalDeny.admission.admit(stringAddr("10.0.0.1:80")), ErrConnectionDenied)
+	assert.ErrorIs(t, illegalDeny.admission.admit(stringAddr("[::1]:80")), ErrConnectionDenied)
+	WithServerAllowList()(&illegalAllow)
+	assert.Nil(t, illegalAllow.admission.admit(stringAddr("10.0.0.1:80")))
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	var sessionNum int32
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerMaxConnections(1))
+	var handler lineHandler
+	server.RunEventLoop(func(session Session) error {
+		atomic.AddInt32(&sessionNum, 1)
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		return nil
+	})
+	defer server.Close()
+
+	first, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sessionNum) == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	// the second connection is closed before its session is created
+	second, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	_ = second.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	netErr, ok := err.(net.Error)
+	assert.False(t, ok && netErr.Timeout())
+	_ = second.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sessionNum))
+
+	// the connection is released after its session is closed
+	_ = first.Close()
+	assert.Eventually(t, func() bool {
+		_, closed := handler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	third, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	defer third.Close()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sessionNum) == 2 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestWSServerDenyList(t *testing.T) {
+	server := newServer(WS_SERVER, WithLocalAddress("127.0.0.1:0"), WithWebsocketServerPath("/admission"),
+		WithServerDenyList("127.0.0.0/8"))
+	server.RunEventLoop(func(session Session) error {
+		t.Error("a session is created for a denied connection")
+		return nil
+	})
+	defer server.Close()
+
+	rsp, err := http.Get("http://" + server.addr + "/admission")
+	assert.Nil(t, err)
+	_ = rsp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
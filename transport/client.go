@@ -18,6 +18,7 @@
 package getty
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -46,6 +47,8 @@ import (
 	log "github.com/AlexStocks/getty/util"
 )
 
+var errClientClosed = perrors.New("client closed")
+
 const (
 	defaultReconnectInterval    = 3e8 // 300ms
 	connectInterval             = 5e8 // 500ms
@@ -78,6 +81,8 @@ type client struct {
 
 	newSession NewSessionCallback
 	ssMap      map[Session]struct{}
+	// traffic totals of the sessions
+	stats endPointStats
 
 	sync.Once
 	done chan struct{}
@@ -88,6 +93,10 @@ func (c *client) init(opts ...ClientOption) {
 	for _, opt := range opts {
 		opt(&(c.ClientOptions))
 	}
+	c.logger = log.With(c.logger, "endpoint", c.endPointType.String(), "endpointID", c.endPointID)
+	for _, err := range c.optionErrs {
+		c.logger.Errorw("[client.init] illegal option", "error", err)
+	}
 }
 
 func newClient(t EndPointType, opts ...ClientOption) *client {
@@ -151,7 +160,7 @@ func (c *client) EndPointType() EndPointType {
 	return c.endPointType
 }
 
-func (c *client) dialTCP() Session {
+func (c *client) dialTCP(ctx context.Context) Session {
 	var (
 		err  error
 		conn net.Conn
@@ -163,25 +172,98 @@ func (c *client) dialTCP() Session {
 		}
 		if c.sslEnabled {
 			if sslConfig, buildTlsConfErr := c.tlsConfigBuilder.BuildTlsConfig(); buildTlsConfErr == nil && sslConfig != nil {
-				d := &net.Dialer{Timeout: connectTimeout}
-				conn, err = tls.DialWithDialer(d, "tcp", c.addr, sslConfig)
+				conn, err = c.dialTLS(ctx, sslConfig)
 			}
 		} else {
-			conn, err = net.DialTimeout("tcp", c.addr, connectTimeout)
+			conn, err = c.netDial("tcp", c.addr)
 		}
 		if err == nil && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 			_ = conn.Close()
 			err = errSelfConnect
 		}
+		if err == nil && c.pskKeyring != nil {
+			var secured net.Conn
+			if secured, err = requestPSKConn(conn, c.pskCipher, c.pskKeyring); err != nil {
+				_ = conn.Close()
+			} else {
+				conn = secured
+			}
+		}
+		compress := CompressNone
+		if err == nil && len(c.msgCompressTypes) != 0 {
+			if compress, err = requestMessageCompression(conn, c.msgCompressTypes); err != nil {
+				_ = conn.Close()
+			}
+		}
 		if err == nil {
-			return newTCPSession(conn, c)
+			ss := newTCPSession(conn, c)
+			if compress != CompressNone {
+				ss.(*session).Connection.(*gettyTCPConn).setMessageCompression(compress, c.msgCompressThreshold)
+			}
+			return ss
 		}
 
-		log.Infof("net.DialTimeout(addr:%s, timeout:%v) = error:%+v", c.addr, connectTimeout, perrors.WithStack(err))
+		c.logger.Infow("failed to connect", "addr", c.addr, "timeout", connectTimeout, "error", err)
 		<-gxtime.After(connectInterval)
 	}
 }
 
+// netDial connects to @addr by the dialer of the client, wraps the connection by the fault injector and sends
+// the PROXY header if they are enabled.
+func (c *client) netDial(network, addr string) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.dialer != nil {
+		conn, err = c.dialer(network, addr)
+	} else {
+		dialer := net.Dialer{Timeout: connectTimeout}
+		if c.socketOptions != nil {
+			dialer.Control = c.socketOptions.dialControl
+		}
+		conn, err = dialer.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.faultInjector != nil {
+		conn = c.faultInjector.WrapConn(conn)
+	}
+	if err = writeProxyHeader(conn, c.proxyProtocol); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dialTLS connects to the server and finishes the tls handshake within the tls handshake span. It sends the
+// PROXY header ahead of the tls handshake if it is enabled.
+func (c *client) dialTLS(ctx context.Context, config *tls.Config) (net.Conn, error) {
+	rawConn, err := c.netDial("tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(c.addr)
+		config = config.Clone()
+		config.ServerName = host
+	}
+	conn := tls.Client(rawConn, config)
+	_, span := startSpan(c.tracer, ctx, SpanTLSHandshake,
+		TraceAttribute{Key: TraceAttrEndPointType, Value: c.endPointType.String()},
+		TraceAttribute{Key: TraceAttrPeerAddr, Value: c.addr})
+	err = handshakeTLS(conn, connectTimeout)
+	endSpan(span, err)
+	if err != nil {
+		_ = rawConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func (c *client) dialUDP() Session {
 	var (
 		err       error
@@ -208,35 +290,46 @@ func (c *client) dialUDP() Session {
 			err = errSelfConnect
 		}
 		if err != nil {
-			log.Warnf("net.DialTimeout(addr:%s, timeout:%v) = error:%+v", c.addr, perrors.WithStack(err))
+			c.logger.Warnw("failed to connect", "addr", c.addr, "error", err)
 			<-gxtime.After(connectInterval)
 			continue
 		}
 
 		// check connection alive by write/read action
 		if err := conn.SetWriteDeadline(time.Now().Add(1e9)); err != nil {
-			log.Warnf("failed to set write deadline: %+v", err)
+			c.logger.Warnw("failed to set write deadline", "error", err)
 		}
 		if length, err = conn.Write(connectPingPackage[:]); err != nil {
 			_ = conn.Close()
-			log.Warnf("conn.Write(%s) = {length:%d, err:%+v}", string(connectPingPackage), length, perrors.WithStack(err))
+			c.logger.Warnw("failed to write the connect ping", "addr", c.addr, "length", length, "error", err)
 			<-gxtime.After(connectInterval)
 			continue
 		}
 		if err := conn.SetReadDeadline(time.Now().Add(1e9)); err != nil {
-			log.Warnf("failed to set read deadline: %+v", err)
+			c.logger.Warnw("failed to set read deadline", "error", err)
 		}
 		length, err = conn.Read(buf)
 		if netErr, ok := perrors.Cause(err).(net.Error); ok && netErr.Timeout() {
 			err = nil
 		}
 		if err != nil {
-			log.Infof("conn{%#v}.Read() = {length:%d, err:%+v}", conn, length, perrors.WithStack(err))
+			c.logger.Infow("failed to read the connect pong", "addr", c.addr, "length", length, "error", err)
 			_ = conn.Close()
 			<-gxtime.After(connectInterval)
 			continue
 		}
-		return newUDPSession(conn, c)
+		var sock udpConn = conn
+		if c.faultInjector != nil {
+			sock = c.faultInjector.wrapUDPConn(conn)
+		}
+		ss := newUDPSession(sock, c)
+		if c.datagramCompressThreshold > 0 {
+			ss.(*session).Connection.(*gettyUDPConn).compressThreshold = c.datagramCompressThreshold
+		}
+		if c.pskKeyring != nil {
+			ss.(*session).Connection.(*gettyUDPConn).psk = newUDPPSK(c.pskCipher, c.pskKeyring, true)
+		}
+		return ss
 	}
 }
 
@@ -249,26 +342,27 @@ func (c *client) dialWS() Session {
 	)
 
 	dialer.EnableCompression = true
+	if c.proxyProtocol != ProxyProtocolNone || c.dialer != nil || c.faultInjector != nil || c.socketOptions != nil {
+		dialer.NetDial = c.netDial
+	}
 	for {
 		if c.IsClosed() {
 			return nil
 		}
 		conn, _, err = dialer.Dial(c.addr, nil)
-		log.Infof("websocket.dialer.Dial(addr:%s) = error:%+v", c.addr, perrors.WithStack(err))
+		c.logger.Infow("websocket dial", "addr", c.addr, "error", err)
 		if err == nil && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 			_ = conn.Close()
 			err = errSelfConnect
 		}
 		if err == nil {
-			ss = newWSSession(conn, c)
-			if ss.(*session).maxMsgLen > 0 {
-				conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
-			}
-
+			ss, err = c.newWSSession(conn)
+		}
+		if err == nil {
 			return ss
 		}
 
-		log.Infof("websocket.dialer.Dial(addr:%s) = error:%+v", c.addr, perrors.WithStack(err))
+		c.logger.Infow("failed to dial websocket", "addr", c.addr, "error", err)
 		<-gxtime.After(connectInterval)
 	}
 }
@@ -327,6 +421,9 @@ func (c *client) dialWSS() Session {
 
 	// dialer.EnableCompression = true
 	dialer.TLSClientConfig = config
+	if c.proxyProtocol != ProxyProtocolNone || c.dialer != nil || c.faultInjector != nil || c.socketOptions != nil {
+		dialer.NetDial = c.netDial
+	}
 	for {
 		if c.IsClosed() {
 			return nil
@@ -337,24 +434,46 @@ func (c *client) dialWSS() Session {
 			err = errSelfConnect
 		}
 		if err == nil {
-			ss = newWSSession(conn, c)
-			if ss.(*session).maxMsgLen > 0 {
-				conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
-			}
+			ss, err = c.newWSSession(conn)
+		}
+		if err == nil {
 			ss.SetName(defaultWSSSessionName)
 
 			return ss
 		}
 
-		log.Infof("websocket.dialer.Dial(addr:%s) = error:%+v", c.addr, perrors.WithStack(err))
+		c.logger.Infow("failed to dial websocket", "addr", c.addr, "error", err)
 		<-gxtime.After(connectInterval)
 	}
 }
 
-func (c *client) dial() Session {
+// newWSSession finishes the pre-shared key handshake of @conn if necessary and creates its session.
+func (c *client) newWSSession(conn *websocket.Conn) (Session, error) {
+	var (
+		err              error
+		pskSend, pskRecv *pskStream
+	)
+
+	if c.pskKeyring != nil {
+		if pskSend, pskRecv, err = requestPSKWS(conn, c.pskCipher, c.pskKeyring); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	ss := newWSSession(conn, c)
+	wsConn := ss.(*session).Connection.(*gettyWSConn)
+	wsConn.setPSK(pskSend, pskRecv)
+	if ss.(*session).maxMsgLen > 0 {
+		wsConn.setReadLimit(ss.(*session).maxMsgLen)
+	}
+
+	return ss, nil
+}
+
+func (c *client) dial(ctx context.Context) Session {
 	switch c.endPointType {
 	case TCP_CLIENT:
-		return c.dialTCP()
+		return c.dialTCP(ctx)
 	case UDP_CLIENT:
 		return c.dialUDP()
 	case WS_CLIENT:
@@ -392,12 +511,20 @@ func (c *client) connect() {
 	)
 
 	for {
-		ss = c.dial()
+		ctx, span := startSpan(c.tracer, context.Background(), SpanConnect,
+			TraceAttribute{Key: TraceAttrEndPointType, Value: c.endPointType.String()},
+			TraceAttribute{Key: TraceAttrPeerAddr, Value: c.addr})
+		ss = c.dial(ctx)
 		if ss == nil {
 			// client has been closed
+			endSpan(span, errClientClosed)
 			break
 		}
 		err = c.newSession(ss)
+		if span != nil {
+			span.SetAttributes(TraceAttribute{Key: TraceAttrSessionID, Value: ss.ID()})
+			endSpan(span, err)
+		}
 		if err == nil {
 			ss.(*session).run()
 			c.Lock()
@@ -414,7 +541,7 @@ func (c *client) connect() {
 		// don't distinguish between tcp connection and websocket connection. Because
 		// gorilla/websocket/conn.go:(Conn)Close also invoke net.Conn.Close()
 		if cerr := ss.Conn().Close(); cerr != nil {
-			log.Warnf("failed to close conn: %+v", cerr)
+			c.logger.Warnw("failed to close conn", "error", cerr)
 		}
 	}
 }
@@ -428,7 +555,7 @@ func (c *client) connect() {
 // however, you can get a active tcp connection very quickly.
 func (c *client) RunEventLoop(newSession NewSessionCallback) {
 	c.Lock()
-	c.newSession = newSession
+	c.newSession = chainSessionInits(sessionInits(c.socketOptions, c.sessionInits), newSession)
 	c.Unlock()
 	c.reConnect()
 }
@@ -450,7 +577,7 @@ func (c *client) reConnect() {
 	connPoolSize := c.number
 	for {
 		if c.IsClosed() {
-			log.Warnf("client{peer:%s} goroutine exit now.", c.addr)
+			c.logger.Warnw("reconnect goroutine exit now", "addr", c.addr)
 			break
 		}
 
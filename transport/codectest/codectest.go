@@ -0,0 +1,332 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package codectest checks that a getty Reader/Writer pair follows the contract of Reader.Read when a tcp
+// stream delivers its frames split at arbitrary byte boundaries or coalesced in batches.
+package codectest
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	getty "github.com/AlexStocks/getty/transport"
+)
+
+var (
+	// ErrContract is the cause of the errors of a Reader breaking the contract of Reader.Read
+	ErrContract = perrors.New("reader contract violation")
+	// ErrMsgTooLong is matched by the error of Codec.Decode for a package longer than Codec.MaxMsgLen, like
+	// the DecodeError of a session
+	ErrMsgTooLong = getty.ErrMsgTooLong
+)
+
+// maxChunkSize is the max size of the fixed size chunks of the chunked stream check
+const maxChunkSize = 16
+
+// Codec is a Reader/Writer pair under test
+type Codec struct {
+	Reader getty.Reader
+	Writer getty.Writer
+	// Session is passed to Reader.Read and Writer.Write, it is nil by default.
+	Session getty.Session
+	// Equal compares a package of the corpus with a decoded one, reflect.DeepEqual by default.
+	Equal func(want, got any) bool
+	// MaxMsgLen is the max package length of the sessions of the codec like Session.SetMaxMsgLen, a
+	// package length longer than it is rejected by Decode as a session does. It is not checked if it is
+	// not positive.
+	MaxMsgLen int
+}
+
+func (c *Codec) equal(want, got any) bool {
+	if c.Equal != nil {
+		return c.Equal(want, got)
+	}
+
+	return reflect.DeepEqual(want, got)
+}
+
+func (c *Codec) equalAll(want, got []any) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if !c.equal(want[i], got[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// read calls Reader.Read and checks its return values.
+func (c *Codec) read(buf []byte) (any, int, error) {
+	pkg, pkgLen, err := c.Reader.Read(c.Session, buf)
+	switch {
+	case err != nil:
+		if pkg != nil || pkgLen != 0 {
+			return nil, 0, perrors.Wrapf(ErrContract, "Read(len:%d) = (%v, %d, %v), want (nil, 0, error) on an error",
+				len(buf), pkg, pkgLen, err)
+		}
+		return nil, 0, err
+	case pkg != nil:
+		if pkgLen <= 0 || pkgLen > len(buf) {
+			return nil, 0, perrors.Wrapf(ErrContract, "Read(len:%d) returns a package of length %d", len(buf), pkgLen)
+		}
+	default:
+		if pkgLen < 0 || pkgLen != 0 && pkgLen <= len(buf) {
+			return nil, 0, perrors.Wrapf(ErrContract,
+				"Read(len:%d) returns no package but the package length %d", len(buf), pkgLen)
+		}
+	}
+	// the session checks the package length of case 3 and case 4
+	if c.MaxMsgLen > 0 && pkgLen > c.MaxMsgLen {
+		return nil, 0, perrors.Wrapf(ErrMsgTooLong, "package length %d > max message length %d", pkgLen, c.MaxMsgLen)
+	}
+
+	return pkg, pkgLen, nil
+}
+
+// Decode decodes the stream delivered in @chunks like a tcp session and returns the decoded packages. It returns
+// io.ErrUnexpectedEOF if the stream ends with a partial package. The read buffer is overwritten after every
+// chunk is parsed like the pooled buffers of getty, so a package referencing it does not equal its origin any
+// more.
+func (c *Codec) Decode(chunks ...[]byte) ([]any, error) {
+	var (
+		buf  []byte
+		pkgs []any
+	)
+	for _, chunk := range chunks {
+		buf = append(append(make([]byte, 0, len(buf)+len(chunk)), buf...), chunk...)
+		consumed := 0
+		for consumed < len(buf) {
+			pkg, pkgLen, err := c.read(buf[consumed:])
+			if err != nil {
+				return pkgs, err
+			}
+			if pkg == nil {
+				break
+			}
+			pkgs = append(pkgs, pkg)
+			consumed += pkgLen
+		}
+		rest := append([]byte(nil), buf[consumed:]...)
+		for i := range buf {
+			buf[i] = 0xa5
+		}
+		buf = rest
+	}
+	if len(buf) != 0 {
+		return pkgs, io.ErrUnexpectedEOF
+	}
+
+	return pkgs, nil
+}
+
+// Encode encodes @pkgs into a stream and returns the stream and the frames of the packages.
+func (c *Codec) Encode(pkgs ...any) ([]byte, [][]byte, error) {
+	var (
+		stream []byte
+		frames [][]byte
+	)
+	for i, pkg := range pkgs {
+		frame, err := c.Writer.Write(c.Session, pkg)
+		if err != nil {
+			return nil, nil, perrors.Wrapf(err, "Write(pkg[%d]:%v)", i, pkg)
+		}
+		frame = append([]byte(nil), frame...)
+		frames = append(frames, frame)
+		stream = append(stream, frame...)
+	}
+
+	return stream, frames, nil
+}
+
+// checkDecode decodes @chunks and compares the packages with @want.
+func (c *Codec) checkDecode(want []any, name string, chunks ...[]byte) error {
+	got, err := c.Decode(chunks...)
+	if err != nil {
+		return perrors.Wrapf(err, "%s: decoded %d of %d packages", name, len(got), len(want))
+	}
+	if !c.equalAll(want, got) {
+		return perrors.Errorf("%s: decoded packages %v, want %v", name, got, want)
+	}
+
+	return nil
+}
+
+// CheckFrames checks every frame of @pkgs on its own: it must be decoded from the whole frame, and every
+// prefix of it must be a partial package(case 2 and 3 of Reader.Read).
+func (c *Codec) CheckFrames(pkgs ...any) error {
+	_, frames, err := c.Encode(pkgs...)
+	if err != nil {
+		return err
+	}
+	for i, frame := range frames {
+		if c.MaxMsgLen > 0 && len(frame) > c.MaxMsgLen {
+			return perrors.Errorf("the frame length %d of pkg[%d] > max message length %d", len(frame), i, c.MaxMsgLen)
+		}
+		if err = c.checkDecode(pkgs[i:i+1], fmt.Sprintf("pkg[%d]", i), frame); err != nil {
+			return err
+		}
+		for k := 0; k < len(frame); k++ {
+			pkg, _, err := c.read(frame[:k])
+			if err != nil {
+				return perrors.Wrapf(err, "pkg[%d] prefix(len:%d)", i, k)
+			}
+			if pkg != nil {
+				return perrors.Wrapf(ErrContract, "pkg[%d] is decoded from its prefix(len:%d)", i, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckStream checks the stream of @pkgs: the packages must be decoded from the coalesced stream(case 5 of
+// Reader.Read), from the stream split at every byte boundary, and from the stream delivered in fixed size
+// chunks.
+func (c *Codec) CheckStream(pkgs ...any) error {
+	stream, _, err := c.Encode(pkgs...)
+	if err != nil {
+		return err
+	}
+	if err = c.checkDecode(pkgs, "coalesced", stream); err != nil {
+		return err
+	}
+	for k := 1; k < len(stream); k++ {
+		if err = c.checkDecode(pkgs, fmt.Sprintf("split at %d", k), stream[:k], stream[k:]); err != nil {
+			return err
+		}
+	}
+	for size := 1; size <= maxChunkSize && size < len(stream); size++ {
+		var chunks [][]byte
+		for k := 0; k < len(stream); k += size {
+			chunks = append(chunks, stream[k:min(k+size, len(stream))])
+		}
+		if err = c.checkDecode(pkgs, fmt.Sprintf("chunks of %d bytes", size), chunks...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckMalformed checks that every stream of @streams is rejected by an error of the Reader(case 1 of
+// Reader.Read), whether it is delivered at once or byte by byte.
+func (c *Codec) CheckMalformed(streams ...[]byte) error {
+	for i, stream := range streams {
+		bytes := make([][]byte, len(stream))
+		for k := range stream {
+			bytes[k] = stream[k : k+1]
+		}
+		for name, chunks := range map[string][][]byte{"whole": {stream}, "byte by byte": bytes} {
+			_, err := c.Decode(chunks...)
+			if err == nil || perrors.Is(err, io.ErrUnexpectedEOF) {
+				return perrors.Errorf("malformed stream[%d] %s is not rejected, err:%v", i, name, err)
+			}
+			if perrors.Is(err, ErrContract) {
+				return perrors.WithMessagef(err, "malformed stream[%d] %s", i, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckOversized checks that no package of @pkgs, which are longer than the max message length, is decoded.
+// Every one must be rejected by the Reader or by MaxMsgLen, whether its frame is delivered at once or split
+// at any byte boundary. A package rejected by the Writer passes.
+func (c *Codec) CheckOversized(pkgs ...any) error {
+	for i, pkg := range pkgs {
+		_, frames, err := c.Encode(pkg)
+		if err != nil {
+			continue
+		}
+		frame := frames[0]
+		for k := 0; k < len(frame); k++ {
+			chunks := [][]byte{frame}
+			if k > 0 {
+				chunks = [][]byte{frame[:k], frame[k:]}
+			}
+			got, err := c.Decode(chunks...)
+			if perrors.Is(err, ErrContract) {
+				return perrors.WithMessagef(err, "oversized pkg[%d] split at %d", i, k)
+			}
+			if err == nil || perrors.Is(err, io.ErrUnexpectedEOF) || len(got) != 0 {
+				return perrors.Errorf("oversized pkg[%d] split at %d is not rejected, err:%v", i, k, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Test runs CheckFrames and CheckStream of the corpus @pkgs as the subtests of @t.
+func (c *Codec) Test(t *testing.T, pkgs ...any) {
+	t.Helper()
+	t.Run("frames", func(t *testing.T) {
+		if err := c.CheckFrames(pkgs...); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	})
+	t.Run("stream", func(t *testing.T) {
+		if err := c.CheckStream(pkgs...); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	})
+}
+
+// Fuzz fuzzes the Reader with the frames of @seeds. The fuzzed streams must be decoded without breaking the
+// contract of Reader.Read, and the same packages must be decoded when a stream is split at any byte boundary.
+// Call it in a fuzz test, e.g.
+//
+//	func FuzzCodec(f *testing.F) {
+//		codec.Fuzz(f, pkgs...)
+//	}
+func (c *Codec) Fuzz(f *testing.F, seeds ...any) {
+	f.Helper()
+	_, frames, err := c.Encode(seeds...)
+	if err != nil {
+		f.Fatalf("%+v", err)
+	}
+	for _, frame := range frames {
+		f.Add(frame, uint(len(frame)/2))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, split uint) {
+		whole, wholeErr := c.Decode(data)
+		if perrors.Is(wholeErr, ErrContract) {
+			t.Fatalf("%+v", wholeErr)
+		}
+		k := int(split % uint(len(data)+1))
+		parts, partsErr := c.Decode(data[:k], data[k:])
+		if perrors.Is(partsErr, ErrContract) {
+			t.Fatalf("split at %d: %+v", k, partsErr)
+		}
+		if (wholeErr == nil) != (partsErr == nil) || !c.equalAll(whole, parts) {
+			t.Fatalf("split at %d: decoded (%v, %v), want (%v, %v)", k, parts, partsErr, whole, wholeErr)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codectest
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	getty "github.com/AlexStocks/getty/transport"
+)
+
+const (
+	lengthHeaderLen = 4
+	lengthMaxBody   = 64
+)
+
+var errBodyTooLong = perrors.New("body too long")
+
+// lengthCodec frames a string by a 4 bytes big endian length header
+type lengthCodec struct {
+	// zeroCopy makes Read return the body referencing the read buffer
+	zeroCopy bool
+	// eager makes Read return the package length of a partial header
+	eager bool
+}
+
+func (c *lengthCodec) Read(_ getty.Session, data []byte) (any, int, error) {
+	if len(data) < lengthHeaderLen {
+		if c.eager {
+			return nil, len(data), nil
+		}
+		return nil, 0, nil
+	}
+	bodyLen := int(binary.BigEndian.Uint32(data))
+	if bodyLen > lengthMaxBody {
+		return nil, 0, errBodyTooLong
+	}
+	if len(data) < lengthHeaderLen+bodyLen {
+		return nil, lengthHeaderLen + bodyLen, nil
+	}
+	body := data[lengthHeaderLen : lengthHeaderLen+bodyLen]
+	if c.zeroCopy {
+		return body, lengthHeaderLen + bodyLen, nil
+	}
+	return string(body), lengthHeaderLen + bodyLen, nil
+}
+
+func (c *lengthCodec) Write(_ getty.Session, pkg any) ([]byte, error) {
+	body, ok := pkg.(string)
+	if !ok {
+		return nil, perrors.Errorf("illegal pkg %#v", pkg)
+	}
+	frame := make([]byte, lengthHeaderLen, lengthHeaderLen+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	return append(frame, body...), nil
+}
+
+// brokenErrorCodec returns a package length with an error
+type brokenErrorCodec struct {
+	lengthCodec
+}
+
+func (c *brokenErrorCodec) Read(ss getty.Session, data []byte) (any, int, error) {
+	pkg, pkgLen, err := c.lengthCodec.Read(ss, data)
+	if err != nil {
+		return nil, len(data), err
+	}
+	return pkg, pkgLen, nil
+}
+
+var corpus = []any{"", "a", "hello", "getty", strings.Repeat("x", lengthMaxBody)}
+
+func newLengthCodec(codec *lengthCodec) *Codec {
+	return &Codec{Reader: codec, Writer: codec, MaxMsgLen: lengthHeaderLen + lengthMaxBody}
+}
+
+func TestCodec(t *testing.T) {
+	newLengthCodec(&lengthCodec{}).Test(t, corpus...)
+
+	codec := newLengthCodec(&lengthCodec{})
+	assert.Nil(t, codec.CheckMalformed([]byte{0, 0, 1, 0}, []byte{0, 0, 0, 1, 'a', 0xff, 0xff, 0xff, 0xff}))
+	assert.Nil(t, codec.CheckOversized(strings.Repeat("x", lengthMaxBody+1), 1))
+}
+
+func TestCodecDecode(t *testing.T) {
+	codec := newLengthCodec(&lengthCodec{})
+	stream, frames, err := codec.Encode("a", "bc")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{0, 0, 0, 1, 'a'}, {0, 0, 0, 2, 'b', 'c'}}, frames)
+
+	pkgs, err := codec.Decode(stream[:3], stream[3:7], stream[7:])
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"a", "bc"}, pkgs)
+
+	pkgs, err = codec.Decode(stream[:7])
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, []any{"a"}, pkgs)
+
+	_, _, err = codec.Encode(1)
+	assert.NotNil(t, err)
+}
+
+func TestCodecMaxMsgLen(t *testing.T) {
+	codec := newLengthCodec(&lengthCodec{})
+	codec.MaxMsgLen = lengthHeaderLen + 2
+	stream, _, err := codec.Encode("ab", "abc")
+	assert.Nil(t, err)
+
+	// the package length of the partial package is checked as a session does
+	pkgs, err := codec.Decode(stream[:10])
+	assert.True(t, perrors.Is(err, ErrMsgTooLong))
+	assert.Equal(t, []any{"ab"}, pkgs)
+
+	assert.Nil(t, codec.CheckOversized("abc"))
+	assert.NotNil(t, codec.CheckFrames("abc"))
+	// the Reader accepts a body of 3 bytes, so it is not rejected without MaxMsgLen
+	codec.MaxMsgLen = 0
+	assert.NotNil(t, codec.CheckOversized("abc"))
+}
+
+func TestCodecContract(t *testing.T) {
+	// the body referencing the read buffer is overwritten after the chunk is consumed
+	codec := &Codec{
+		Reader: &lengthCodec{zeroCopy: true},
+		Writer: &lengthCodec{},
+		Equal: func(want, got any) bool {
+			body, ok := got.([]byte)
+			return ok && want == string(body)
+		},
+	}
+	assert.NotNil(t, codec.CheckFrames(corpus...))
+	assert.NotNil(t, codec.CheckStream(corpus...))
+
+	// the package length of a partial header is not larger than the buffer
+	eager := &lengthCodec{eager: true}
+	codec = &Codec{Reader: eager, Writer: eager}
+	err := codec.CheckFrames("hello")
+	assert.True(t, perrors.Is(err, ErrContract), "%v", err)
+
+	broken := &brokenErrorCodec{}
+	codec = &Codec{Reader: broken, Writer: broken}
+	assert.Nil(t, codec.CheckStream(corpus...))
+	err = codec.CheckMalformed([]byte{0xff, 0xff, 0xff, 0xff})
+	assert.True(t, perrors.Is(err, ErrContract), "%v", err)
+	err = codec.CheckOversized(strings.Repeat("x", lengthMaxBody+1))
+	assert.True(t, perrors.Is(err, ErrContract), "%v", err)
+}
+
+func FuzzLengthCodec(f *testing.F) {
+	newLengthCodec(&lengthCodec{}).Fuzz(f, corpus...)
+}
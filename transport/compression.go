@@ -0,0 +1,385 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/golang/snappy"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pierrec/lz4/v4"
+
+	perrors "github.com/pkg/errors"
+)
+
+const (
+	// DefaultMessageCompressionThreshold is the default size below which a message is sent uncompressed
+	DefaultMessageCompressionThreshold = 256
+	// maxCompressedMessageLen is the maximum decompressed size of a compressed message of a session without
+	// a max message length
+	maxCompressedMessageLen = 64 * 1024 * 1024
+
+	messageCompressionTimeout = time.Second * 3
+	messageCompressionMagic   = "GTMC"
+
+	messageFlagCompressed  byte = 0x01
+	messageHeaderLen            = 5
+	compressedHeaderLen         = messageHeaderLen + 4
+	udpCompressedHeaderLen      = 1 + 4
+)
+
+var (
+	// ErrCompressedMessageInvalid is returned when a compressed message or the compression negotiation is illegal
+	ErrCompressedMessageInvalid = perrors.New("invalid compressed message")
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+
+	flateWriterPools = make(map[CompressType]*sync.Pool)
+	flateReaderPool  = sync.Pool{
+		New: func() any {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+func init() {
+	for _, level := range []CompressType{CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman} {
+		level := level
+		flateWriterPools[level] = &sync.Pool{
+			New: func() any {
+				w, err := flate.NewWriter(nil, int(level))
+				if err != nil {
+					panic(fmt.Sprintf("flate.NewWriter(level:%d) = err(%s)", level, err))
+				}
+				return w
+			},
+		}
+	}
+}
+
+// initZstd creates the zstd encoder and decoder shared by all connections, both of them are safe for
+// concurrent EncodeAll/DecodeAll calls.
+func initZstd() {
+	zstdOnce.Do(func() {
+		var err error
+		if zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)); err != nil {
+			panic(perrors.WithStack(err))
+		}
+		if zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+			zstd.WithDecodeAllCapLimit(true)); err != nil {
+			panic(perrors.WithStack(err))
+		}
+	})
+}
+
+// messageCompressionSupported reports whether @c can compress single messages
+func messageCompressionSupported(c CompressType) bool {
+	switch c {
+	case CompressSnappy, CompressZstd, CompressLZ4:
+		return true
+	}
+	return false
+}
+
+// compressBlock compresses @src by @c. It returns false if @src is incompressible.
+func compressBlock(c CompressType, src []byte) ([]byte, bool) {
+	var dst []byte
+
+	switch c {
+	case CompressSnappy:
+		dst = snappy.Encode(nil, src)
+
+	case CompressZstd:
+		initZstd()
+		dst = zstdEncoder.EncodeAll(src, make([]byte, 0, len(src)))
+
+	case CompressLZ4:
+		dst = make([]byte, lz4.CompressBlockBound(len(src)))
+		n, err := lz4.CompressBlock(src, dst, nil)
+		if err != nil || n == 0 {
+			return nil, false
+		}
+		dst = dst[:n]
+
+	case CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman:
+		var buf bytes.Buffer
+		pool := flateWriterPools[c]
+		w := pool.Get().(*flate.Writer)
+		defer pool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, false
+		}
+		if err := w.Close(); err != nil {
+			return nil, false
+		}
+		dst = buf.Bytes()
+
+	default:
+		return nil, false
+	}
+
+	return dst, len(dst) < len(src)
+}
+
+// decompressBlock decompresses @src compressed by @c, whose decompressed length should be @rawLen.
+// @rawLen is checked against @maxLen before any memory is allocated for it.
+func decompressBlock(c CompressType, src []byte, rawLen, maxLen int) ([]byte, error) {
+	if rawLen < 0 || rawLen > maxLen {
+		return nil, perrors.Wrapf(ErrCompressedMessageInvalid, "decompressed length %d > max length %d", rawLen, maxLen)
+	}
+
+	var (
+		err error
+		n   int
+		dst []byte
+	)
+	switch c {
+	case CompressSnappy:
+		if n, err = snappy.DecodedLen(src); err == nil && n == rawLen {
+			dst, err = snappy.Decode(make([]byte, rawLen), src)
+		}
+
+	case CompressZstd:
+		initZstd()
+		dst, err = zstdDecoder.DecodeAll(src, make([]byte, 0, rawLen))
+
+	case CompressLZ4:
+		dst = make([]byte, rawLen)
+		if n, err = lz4.UncompressBlock(src, dst); err == nil {
+			dst = dst[:n]
+		}
+
+	case CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman:
+		r := flateReaderPool.Get().(io.ReadCloser)
+		defer flateReaderPool.Put(r)
+		if err = r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+			break
+		}
+		// never read more than @rawLen bytes and make sure that there is nothing left
+		dst = make([]byte, rawLen)
+		if _, err = io.ReadFull(r, dst); err == nil {
+			var extra [1]byte
+			if m, _ := r.Read(extra[:]); m != 0 {
+				err = perrors.Errorf("decompressed length > %d", rawLen)
+			}
+		}
+
+	default:
+		return nil, perrors.Wrapf(ErrCompressedMessageInvalid, "illegal compress type %d", c)
+	}
+	if err != nil {
+		return nil, perrors.Wrapf(ErrCompressedMessageInvalid, "decompress error: %v", err)
+	}
+	if len(dst) != rawLen {
+		return nil, perrors.Wrapf(ErrCompressedMessageInvalid, "decompressed length %d != %d", len(dst), rawLen)
+	}
+
+	return dst, nil
+}
+
+// messageCompressor compresses every message written to a tcp connection on its own. Every message is
+// sent in a frame as follows, and the messages below the threshold or incompressible are sent as they are.
+//
+//	flags(1 byte) | payload length(4 bytes) | [decompressed length(4 bytes) if compressed] | payload
+//
+// The reader hands the decompressed stream to the session Reader, so the codecs are unaware of it. A frame
+// longer than the max message length of the session is refused before reading or decompressing it.
+type messageCompressor struct {
+	conn      net.Conn
+	algorithm CompressType
+	threshold int
+	// returns the max length of a frame, maxCompressedMessageLen if it is nil
+	maxLen func() int
+
+	lock sync.Mutex // serialize frames written concurrently
+
+	header  [compressedHeaderLen]byte
+	pending []byte
+}
+
+func newMessageCompressor(conn net.Conn, algorithm CompressType, threshold int) *messageCompressor {
+	return &messageCompressor{
+		conn:      conn,
+		algorithm: algorithm,
+		threshold: threshold,
+	}
+}
+
+func (m *messageCompressor) Write(p []byte) (int, error) {
+	var (
+		header  [compressedHeaderLen]byte
+		payload = p
+		hdrLen  = messageHeaderLen
+	)
+
+	if len(p) >= m.threshold {
+		if dst, ok := compressBlock(m.algorithm, p); ok {
+			payload = dst
+			header[0] = messageFlagCompressed
+			binary.BigEndian.PutUint32(header[messageHeaderLen:], uint32(len(p)))
+			hdrLen = compressedHeaderLen
+		}
+	}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	buffers := net.Buffers{header[:hdrLen], payload}
+	if _, err := buffers.WriteTo(m.conn); err != nil {
+		return 0, perrors.WithStack(err)
+	}
+
+	return len(p), nil
+}
+
+func (m *messageCompressor) Read(p []byte) (int, error) {
+	for len(m.pending) == 0 {
+		if err := m.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+func (m *messageCompressor) readFrame() error {
+	if _, err := io.ReadFull(m.conn, m.header[:messageHeaderLen]); err != nil {
+		return err
+	}
+	flags := m.header[0]
+	maxLen := maxCompressedMessageLen
+	if m.maxLen != nil {
+		maxLen = m.maxLen()
+	}
+	payloadLen := int(binary.BigEndian.Uint32(m.header[1:messageHeaderLen]))
+	if payloadLen > maxLen {
+		return perrors.Wrapf(ErrCompressedMessageInvalid, "payload length %d", payloadLen)
+	}
+	rawLen := payloadLen
+	if flags&messageFlagCompressed != 0 {
+		if _, err := io.ReadFull(m.conn, m.header[messageHeaderLen:]); err != nil {
+			return err
+		}
+		rawLen = int(binary.BigEndian.Uint32(m.header[messageHeaderLen:]))
+	}
+
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(m.conn, payload); err != nil {
+		return err
+	}
+	if flags&messageFlagCompressed == 0 {
+		m.pending = payload
+		return nil
+	}
+
+	raw, err := decompressBlock(m.algorithm, payload, rawLen, maxLen)
+	if err != nil {
+		return err
+	}
+	m.pending = raw
+	return nil
+}
+
+// requestMessageCompression sends the compress types the client supports in order of preference,
+// and returns the one chosen by the server. CompressNone means no compression.
+//
+//	hello: magic(4 bytes) | number(1 byte) | compress types(1 byte each)
+//	reply: magic(4 bytes) | compress type(1 byte)
+func requestMessageCompression(conn net.Conn, algorithms []CompressType) (CompressType, error) {
+	if err := conn.SetDeadline(time.Now().Add(messageCompressionTimeout)); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	hello := make([]byte, 0, len(messageCompressionMagic)+1+len(algorithms))
+	hello = append(hello, messageCompressionMagic...)
+	hello = append(hello, byte(len(algorithms)))
+	for _, algorithm := range algorithms {
+		hello = append(hello, byte(algorithm))
+	}
+	if _, err := conn.Write(hello); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+
+	var reply [len(messageCompressionMagic) + 1]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+	algorithm := CompressType(reply[len(messageCompressionMagic)])
+	if !bytes.Equal(reply[:len(messageCompressionMagic)], []byte(messageCompressionMagic)) ||
+		(algorithm != CompressNone && !messageCompressionSupported(algorithm)) {
+		return CompressNone, perrors.Wrapf(ErrCompressedMessageInvalid, "illegal negotiation reply %v", reply)
+	}
+
+	return algorithm, nil
+}
+
+// acceptMessageCompression reads the hello of requestMessageCompression, chooses the first compress type
+// of the client which is in @algorithms, and replies it.
+func acceptMessageCompression(conn net.Conn, algorithms []CompressType) (CompressType, error) {
+	if err := conn.SetDeadline(time.Now().Add(messageCompressionTimeout)); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	var header [len(messageCompressionMagic) + 1]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+	if !bytes.Equal(header[:len(messageCompressionMagic)], []byte(messageCompressionMagic)) {
+		return CompressNone, perrors.Wrapf(ErrCompressedMessageInvalid, "illegal negotiation hello %v", header)
+	}
+	offered := make([]byte, header[len(messageCompressionMagic)])
+	if _, err := io.ReadFull(conn, offered); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+
+	chosen := CompressNone
+loop:
+	for _, o := range offered {
+		for _, algorithm := range algorithms {
+			if CompressType(o) == algorithm {
+				chosen = algorithm
+				break loop
+			}
+		}
+	}
+
+	reply := append([]byte(messageCompressionMagic), byte(chosen))
+	if _, err := conn.Write(reply); err != nil {
+		return CompressNone, perrors.WithStack(err)
+	}
+
+	return chosen, nil
+}
@@ -0,0 +1,306 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// tcpConnPair returns both ends of a loopback tcp connection
+func tcpConnPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() { _ = listener.Close() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+
+	return client, <-accepted
+}
+
+func TestStreamCompression(t *testing.T) {
+	payload := []byte(strings.Repeat("getty stream compression ", 100))
+	for _, c := range []CompressType{CompressSnappy, CompressZstd, CompressLZ4} {
+		client, server := tcpConnPair(t)
+		writer, reader := newGettyTCPConn(client), newGettyTCPConn(server)
+		writer.SetCompressType(c)
+		reader.SetCompressType(c)
+
+		_, err := writer.Send(payload)
+		assert.Nil(t, err)
+		if c == CompressSnappy {
+			assert.Nil(t, writer.writer.(interface{ Flush() error }).Flush())
+		}
+
+		buf := make([]byte, len(payload))
+		got := 0
+		for got < len(payload) {
+			n, rerr := reader.recv(buf[got:])
+			assert.Nil(t, rerr)
+			got += n
+		}
+		assert.Equal(t, payload, buf, "compress type %d", c)
+
+		writer.CloseConn(0)
+		reader.CloseConn(0)
+	}
+}
+
+func TestCompressBlock(t *testing.T) {
+	payload := []byte(strings.Repeat("getty block compression ", 100))
+	for _, c := range []CompressType{CompressSnappy, CompressZstd, CompressLZ4, CompressZip, CompressBestSpeed,
+		CompressBestCompression, CompressHuffman} {
+		dst, ok := compressBlock(c, payload)
+		assert.True(t, ok)
+		assert.True(t, len(dst) < len(payload))
+
+		raw, err := decompressBlock(c, dst, len(payload), len(payload))
+		assert.Nil(t, err)
+		assert.Equal(t, payload, raw)
+
+		// the claimed length exceeds the limit
+		_, err = decompressBlock(c, dst, len(payload), len(payload)-1)
+		assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+		// the claimed length is less than the real one
+		_, err = decompressBlock(c, dst, len(payload)/2, len(payload))
+		assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+	}
+
+	_, ok := compressBlock(CompressType(100), payload)
+	assert.False(t, ok)
+}
+
+func TestNegotiateMessageCompression(t *testing.T) {
+	cases := []struct {
+		client, server []CompressType
+		expected       CompressType
+	}{
+		{[]CompressType{CompressLZ4, CompressZstd}, []CompressType{CompressZstd, CompressLZ4}, CompressLZ4},
+		{[]CompressType{CompressSnappy, CompressZstd}, []CompressType{CompressZstd}, CompressZstd},
+		{[]CompressType{CompressSnappy}, []CompressType{CompressLZ4}, CompressNone},
+	}
+	for _, c := range cases {
+		client, server := tcpConnPair(t)
+		accepted := make(chan CompressType, 1)
+		go func() {
+			compress, err := acceptMessageCompression(server, c.server)
+			assert.Nil(t, err)
+			accepted <- compress
+		}()
+		compress, err := requestMessageCompression(client, c.client)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, compress)
+		assert.Equal(t, c.expected, <-accepted)
+		_ = client.Close()
+		_ = server.Close()
+	}
+
+	client, server := tcpConnPair(t)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	_, err := client.Write([]byte("HTTP/1.1"))
+	assert.Nil(t, err)
+	_, err = acceptMessageCompression(server, []CompressType{CompressZstd})
+	assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+}
+
+func TestMessageCompression(t *testing.T) {
+	// the types that can not compress single messages are logged and ignored
+	var logger recordLogger
+	illegal := newServer(TCP_SERVER, WithServerLogger(&logger), WithServerMessageCompression(0, CompressZstd, CompressZip))
+	assert.Equal(t, []CompressType{CompressZstd}, illegal.msgCompressTypes)
+	assert.Equal(t, 1, len(logger.matching("illegal option")))
+	illegalClient := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:1"), WithConnectionNumber(1),
+		WithClientMessageCompression(0, CompressZip))
+	assert.Equal(t, 0, len(illegalClient.msgCompressTypes))
+
+	var serverHandler, clientHandler lineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"),
+		WithServerMessageCompression(64, CompressZstd, CompressLZ4))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		session.SetMaxMsgLen(64 * 1024)
+		return nil
+	})
+	defer server.Close()
+
+	sessions := make(chan Session, 1)
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1),
+		WithClientMessageCompression(64, CompressLZ4, CompressSnappy))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&clientHandler)
+		session.SetEventListener(&clientHandler)
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	ss := <-sessions
+	conn := ss.(*session).Connection.(*gettyTCPConn)
+	assert.Equal(t, CompressType(CompressLZ4), conn.compress)
+
+	long := strings.Repeat("compressible ", 1000)
+	lines := []string{"short", long, "tiny", long + "!"}
+	for _, line := range lines {
+		_, _, err := ss.WritePkg(line, 0)
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == len(lines)
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ := serverHandler.snapshot()
+	assert.Equal(t, lines, got)
+}
+
+func TestMessageCompressorFrame(t *testing.T) {
+	client, server := tcpConnPair(t)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	compressor := newMessageCompressor(client, CompressZstd, 16)
+	long := []byte(strings.Repeat("compressible ", 100))
+	for _, msg := range [][]byte{[]byte("tiny"), long} {
+		n, err := compressor.Write(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, len(msg), n)
+	}
+
+	header := make([]byte, messageHeaderLen+len("tiny"))
+	_, err := io.ReadFull(server, header)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 4, 't', 'i', 'n', 'y'}, header)
+
+	header = make([]byte, compressedHeaderLen)
+	_, err = io.ReadFull(server, header)
+	assert.Nil(t, err)
+	assert.Equal(t, messageFlagCompressed, header[0])
+	assert.True(t, binary.BigEndian.Uint32(header[1:]) < uint32(len(long)))
+	assert.Equal(t, uint32(len(long)), binary.BigEndian.Uint32(header[messageHeaderLen:]))
+
+	// a frame claiming a huge decompressed length is refused before decompressing it
+	reader := newMessageCompressor(server, CompressZstd, 16)
+	bomb := []byte{messageFlagCompressed, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0}
+	_, err = client.Write(bomb)
+	assert.Nil(t, err)
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err = io.ReadFull(server, payload)
+	assert.Nil(t, err)
+	_, err = reader.Read(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+
+	// a frame decompressed beyond the max message length of the session is refused
+	reader = newMessageCompressor(server, CompressZstd, 16)
+	reader.maxLen = func() int { return len(long) - 1 }
+	_, err = compressor.Write(long)
+	assert.Nil(t, err)
+	_, err = reader.Read(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+}
+
+// udpLineHandler collects the lines received by an udp session
+type udpLineHandler struct {
+	lineHandler
+}
+
+func (h *udpLineHandler) OnMessage(ss Session, pkg any) {
+	h.lineHandler.OnMessage(ss, pkg.(UDPContext).Pkg)
+}
+
+func TestUDPCompression(t *testing.T) {
+	var handler udpLineHandler
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetMaxMsgLen(8 * 1024)
+		session.SetCompressType(CompressZstd)
+		return nil
+	})
+	defer server.Close()
+
+	peer, err := net.Dial("udp", server.pktListeners[0].LocalAddr().String())
+	assert.Nil(t, err)
+	defer func() { _ = peer.Close() }()
+
+	compressor := &gettyUDPConn{compressType: CompressZstd, compressThreshold: DefaultMessageCompressionThreshold}
+	long := strings.Repeat("compressible ", 500)
+	datagram := compressor.compressDatagram([]byte(long + "\n"))
+	assert.Equal(t, messageFlagCompressed, datagram[0])
+	assert.True(t, len(datagram) < len(long))
+	for _, d := range [][]byte{
+		compressor.compressDatagram([]byte("tiny\n")),
+		datagram,
+		// a decompression bomb exceeding the max message length is dropped
+		compressor.compressDatagram([]byte(strings.Repeat("x", 16*1024) + "\n")),
+		compressor.compressDatagram([]byte("last\n")),
+	} {
+		_, err = peer.Write(d)
+		assert.Nil(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ := handler.snapshot()
+	assert.Equal(t, []string{"tiny", long, "last"}, got)
+
+	raw, compressed, err := compressor.decompressDatagram(datagram, len(long)+1)
+	assert.Nil(t, err)
+	assert.True(t, compressed)
+	assert.Equal(t, long+"\n", string(raw))
+	_, _, err = compressor.decompressDatagram(datagram, len(long))
+	assert.ErrorIs(t, err, ErrCompressedMessageInvalid)
+}
+
+func TestDatagramCompressionThreshold(t *testing.T) {
+	short := []byte(strings.Repeat("ab", 64))
+	assert.Equal(t, byte(0), newGettyUDPConn(&net.UDPConn{}).compressDatagram(short)[0]&messageFlagCompressed)
+
+	var handler udpLineHandler
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"), WithServerDatagramCompressionThreshold(64))
+	sessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetCompressType(CompressZstd)
+		sessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	conn := (<-sessions).(*session).Connection.(*gettyUDPConn)
+	assert.Equal(t, 64, conn.compressThreshold)
+	assert.Equal(t, messageFlagCompressed, conn.compressDatagram(short)[0])
+}
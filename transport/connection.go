@@ -20,6 +20,7 @@ package getty
 import (
 	"compress/flate"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
@@ -32,6 +33,10 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pierrec/lz4/v4"
+
 	perrors "github.com/pkg/errors"
 
 	uatomic "go.uber.org/atomic"
@@ -95,6 +100,15 @@ type gettyConn struct {
 	local         string       // local address
 	peer          string       // peer address
 	ss            Session
+	stats         *endPointStats // the totals of the endpoint, nil if the session has no getty endpoint
+}
+
+// endPointStats are the traffic totals of all of the sessions of an endpoint, the closed ones included.
+type endPointStats struct {
+	readBytes   uatomic.Uint64
+	writeBytes  uatomic.Uint64
+	readPkgNum  uatomic.Uint64
+	writePkgNum uatomic.Uint64
 }
 
 func (c *gettyConn) ID() uint32 {
@@ -111,10 +125,34 @@ func (c *gettyConn) RemoteAddr() string {
 
 func (c *gettyConn) IncReadPkgNum() {
 	c.readPkgNum.Add(1)
+	if c.stats != nil {
+		c.stats.readPkgNum.Add(1)
+	}
 }
 
 func (c *gettyConn) IncWritePkgNum() {
 	c.writePkgNum.Add(1)
+	if c.stats != nil {
+		c.stats.writePkgNum.Add(1)
+	}
+}
+
+// addReadBytes counts @n bytes read by the connection
+func (c *gettyConn) addReadBytes(n int) {
+	c.readBytes.Add(uint32(n))
+	if c.stats != nil {
+		c.stats.readBytes.Add(uint64(n))
+	}
+}
+
+// addWrite counts @n bytes of @pkgNum packages written by the connection
+func (c *gettyConn) addWrite(n, pkgNum int) {
+	c.writeBytes.Add(uint32(n))
+	c.writePkgNum.Add(uint32(pkgNum))
+	if c.stats != nil {
+		c.stats.writeBytes.Add(uint64(n))
+		c.stats.writePkgNum.Add(uint64(pkgNum))
+	}
 }
 
 func (c *gettyConn) UpdateActive() {
@@ -127,7 +165,7 @@ func (c *gettyConn) GetActive() time.Time {
 
 // removed unused methods send/close
 
-func (c gettyConn) ReadTimeout() time.Duration {
+func (c *gettyConn) ReadTimeout() time.Duration {
 	return c.rTimeout.Load()
 }
 
@@ -150,7 +188,7 @@ func (c *gettyConn) SetReadTimeout(rTimeout time.Duration) {
 	}
 }
 
-func (c gettyConn) WriteTimeout() time.Duration {
+func (c *gettyConn) WriteTimeout() time.Duration {
 	return c.wTimeout.Load()
 }
 
@@ -209,9 +247,15 @@ func newGettyTCPConn(conn net.Conn) *gettyTCPConn {
 	}
 }
 
-// for zip compress
+// flusher is a compress writer which can flush its buffered data
+type flusher interface {
+	io.Writer
+	Flush() error
+}
+
+// for zip/zstd/lz4 compress
 type writeFlusher struct {
-	flusher *flate.Writer
+	flusher flusher
 	lock    sync.Mutex
 }
 
@@ -233,7 +277,7 @@ func (t *writeFlusher) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-// SetCompressType set compress type(tcp: zip/snappy, websocket:zip)
+// SetCompressType set compress type(tcp: zip/snappy/zstd/lz4, websocket:zip)
 func (t *gettyTCPConn) SetCompressType(c CompressType) {
 	switch c {
 	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman:
@@ -253,12 +297,40 @@ func (t *gettyTCPConn) SetCompressType(c CompressType) {
 		ioWriter := io.Writer(t.conn)
 		t.writer = snappy.NewBufferedWriter(ioWriter)
 
+	case CompressZstd:
+		decoder, err := zstd.NewReader(t.conn, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			panic(fmt.Sprintf("zstd.NewReader() = err(%s)", err))
+		}
+		t.reader = decoder
+		encoder, err := zstd.NewWriter(t.conn, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(fmt.Sprintf("zstd.NewWriter() = err(%s)", err))
+		}
+		t.writer = &writeFlusher{flusher: encoder}
+
+	case CompressLZ4:
+		t.reader = lz4.NewReader(t.conn)
+		t.writer = &writeFlusher{flusher: lz4.NewWriter(t.conn)}
+
 	default:
 		panic(fmt.Sprintf("illegal comparess type %d", c))
 	}
 	t.compress = c
 }
 
+// setMessageCompression compresses every message by @c instead of the whole stream. It is set after
+// the compress type is negotiated with the peer.
+func (t *gettyTCPConn) setMessageCompression(c CompressType, threshold int) {
+	compressor := newMessageCompressor(t.conn, c, threshold)
+	if ss, ok := t.ss.(*session); ok {
+		compressor.maxLen = ss.maxDecompressedLen
+	}
+	t.reader = compressor
+	t.writer = compressor
+	t.compress = c
+}
+
 // tcp connection read
 func (t *gettyTCPConn) recv(p []byte) (int, error) {
 	var (
@@ -280,7 +352,7 @@ func (t *gettyTCPConn) recv(p []byte) (int, error) {
 	}
 
 	length, err = t.reader.Read(p)
-	t.readBytes.Add(uint32(length))
+	t.addReadBytes(length)
 	return length, perrors.WithStack(err)
 }
 
@@ -292,36 +364,23 @@ func (t *gettyTCPConn) Send(pkg any) (int, error) {
 		ok          bool
 		p           []byte
 		length      int
-		lg          int64
 	)
 
-	if t.compress == CompressNone && t.wTimeout.Load() > 0 {
-		// Set Deadline every time, since golang has fixed the performance issue
-		// See https://github.com/golang/go/issues/15133#issuecomment-271571395 for details
-		currentTime = time.Now()
-		if err = t.conn.SetWriteDeadline(currentTime.Add(t.wTimeout.Load())); err != nil {
-			return 0, perrors.WithStack(err)
-		}
-		t.wLastDeadline.Store(currentTime)
+	if currentTime, err = t.updateWriteDeadline(); err != nil {
+		return 0, err
 	}
 
 	if buffers, ok := pkg.([][]byte); ok {
-		netBuf := net.Buffers(buffers)
-		lg, err = netBuf.WriteTo(t.conn)
-		if err == nil {
-			t.writeBytes.Add((uint32)(lg))
-			t.writePkgNum.Add((uint32)(len(buffers)))
-		}
+		length, err = t.sendBuffers(buffers, len(buffers))
 		log.Debugf("localAddr: %s, remoteAddr:%s, now:%s, length:%d, err:%s",
 			t.conn.LocalAddr(), t.conn.RemoteAddr(), currentTime, length, err)
-		return int(lg), perrors.WithStack(err)
+		return length, err
 	}
 
 	if p, ok = pkg.([]byte); ok {
 		length, err = t.writer.Write(p)
 		if err == nil {
-			t.writeBytes.Add((uint32)(len(p)))
-			t.writePkgNum.Add(1)
+			t.addWrite(len(p), 1)
 		}
 		log.Debugf("localAddr: %s, remoteAddr:%s, now:%s, length:%d, err:%v",
 			t.conn.LocalAddr(), t.conn.RemoteAddr(), currentTime, length, err)
@@ -331,6 +390,52 @@ func (t *gettyTCPConn) Send(pkg any) (int, error) {
 	return 0, perrors.Errorf("illegal @pkg{%#v} type", pkg)
 }
 
+// updateWriteDeadline sets the write deadline and returns the time it is based on.
+func (t *gettyTCPConn) updateWriteDeadline() (time.Time, error) {
+	var currentTime time.Time
+
+	if t.compress == CompressNone && t.wTimeout.Load() > 0 {
+		// Set Deadline every time, since golang has fixed the performance issue
+		// See https://github.com/golang/go/issues/15133#issuecomment-271571395 for details
+		currentTime = time.Now()
+		if err := t.conn.SetWriteDeadline(currentTime.Add(t.wTimeout.Load())); err != nil {
+			return currentTime, perrors.WithStack(err)
+		}
+		t.wLastDeadline.Store(currentTime)
+	}
+
+	return currentTime, nil
+}
+
+// sendBuffers writes @buffers of @pkgNum packages by one vectored I/O syscall. Pls attention that the
+// elements of @buffers are consumed.
+func (t *gettyTCPConn) sendBuffers(buffers [][]byte, pkgNum int) (int, error) {
+	var (
+		err error
+		lg  int64
+		n   int
+	)
+
+	if t.compress == CompressNone {
+		netBuf := net.Buffers(buffers)
+		lg, err = netBuf.WriteTo(t.conn)
+	} else {
+		// the compressed stream can not be written by vectored I/O
+		for _, buf := range buffers {
+			n, err = t.writer.Write(buf)
+			lg += int64(n)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		t.addWrite(int(lg), pkgNum)
+	}
+
+	return int(lg), perrors.WithStack(err)
+}
+
 // close tcp connection
 func (t *gettyTCPConn) CloseConn(waitSec int) {
 	// if tcpConn, ok := t.conn.(*net.TCPConn); ok {
@@ -343,11 +448,15 @@ func (t *gettyTCPConn) CloseConn(waitSec int) {
 				log.Errorf("snappy.Writer.Close() = error:%+v", err)
 			}
 		}
+		if decoder, ok := t.reader.(*zstd.Decoder); ok {
+			decoder.Close()
+		}
 		if conn, ok := t.conn.(*net.TCPConn); ok {
 			_ = conn.SetLinger(waitSec)
 			_ = conn.Close()
 		} else {
-			_ = t.conn.(*tls.Conn).Close()
+			// *tls.Conn or *secureConn
+			_ = t.conn.Close()
 		}
 		t.conn = nil
 	}
@@ -366,14 +475,23 @@ func (c UDPContext) String() string {
 	return fmt.Sprintf("{pkg:%#v, peer addr:%s}", c.Pkg, c.PeerAddr)
 }
 
+// udpConn is the socket of an udp session, a *net.UDPConn or a wrapper of it injecting faults
+type udpConn interface {
+	net.Conn
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+}
+
 type gettyUDPConn struct {
 	gettyConn
-	compressType CompressType
-	conn         *net.UDPConn // for server
+	compressType      CompressType
+	compressThreshold int // datagrams shorter than it are sent uncompressed
+	psk               *udpPSK
+	conn              udpConn // for server
 }
 
 // create gettyUDPConn
-func newGettyUDPConn(conn *net.UDPConn) *gettyUDPConn {
+func newGettyUDPConn(conn udpConn) *gettyUDPConn {
 	if conn == nil {
 		panic("newGettyUDPConn(conn):@conn is nil")
 	}
@@ -389,7 +507,8 @@ func newGettyUDPConn(conn *net.UDPConn) *gettyUDPConn {
 	}
 
 	return &gettyUDPConn{
-		conn: conn,
+		conn:              conn,
+		compressThreshold: DefaultMessageCompressionThreshold,
 		gettyConn: gettyConn{
 			id:       connID.Add(1),
 			rTimeout: *uatomic.NewDuration(netIOTimeout),
@@ -401,9 +520,14 @@ func newGettyUDPConn(conn *net.UDPConn) *gettyUDPConn {
 	}
 }
 
+// SetCompressType set compress type of every datagram. Datagrams shorter than the threshold set by
+// WithServerDatagramCompressionThreshold/WithClientDatagramCompressionThreshold, or
+// DefaultMessageCompressionThreshold by default, are sent uncompressed. Both peers should use the same
+// compress type.
 func (u *gettyUDPConn) SetCompressType(c CompressType) {
 	switch c {
-	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman, CompressSnappy:
+	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman, CompressSnappy,
+		CompressZstd, CompressLZ4:
 		u.compressType = c
 
 	default:
@@ -426,7 +550,7 @@ func (u *gettyUDPConn) recv(p []byte) (int, *net.UDPAddr, error) {
 	length, addr, err := u.conn.ReadFromUDP(p) // connected udp also can get return @addr
 	log.Debugf("ReadFromUDP(p:%d) = {length:%d, peerAddr:%s, error:%v}", len(p), length, addr, err)
 	if err == nil {
-		u.readBytes.Add(uint32(length))
+		u.addReadBytes(length)
 	}
 
 	return length, addr, perrors.WithStack(err)
@@ -467,15 +591,61 @@ func (u *gettyUDPConn) Send(udpCtx any) (int, error) {
 		u.wLastDeadline.Store(currentTime)
 	}
 
+	pkgLen := len(buf)
+	if u.compressType != CompressNone {
+		buf = u.compressDatagram(buf)
+	}
+	if u.psk != nil {
+		if buf, err = u.psk.seal(buf); err != nil {
+			return 0, err
+		}
+	}
 	if length, _, err = u.conn.WriteMsgUDP(buf, nil, peerAddr); err == nil {
-		u.writeBytes.Add((uint32)(len(buf)))
-		u.writePkgNum.Add(1)
+		u.addWrite(len(buf), 1)
+		length = pkgLen
 	}
 	log.Debugf("WriteMsgUDP(peerAddr:%s) = {length:%d, error:%v}", peerAddr, length, err)
 
 	return length, perrors.WithStack(err)
 }
 
+// compressDatagram prepends the compression header to @buf and compresses it if it is long enough.
+//
+//	flags(1 byte) | [decompressed length(4 bytes) if compressed] | payload
+func (u *gettyUDPConn) compressDatagram(buf []byte) []byte {
+	if len(buf) >= u.compressThreshold {
+		if payload, ok := compressBlock(u.compressType, buf); ok && len(payload)+4 < len(buf) {
+			datagram := make([]byte, udpCompressedHeaderLen+len(payload))
+			datagram[0] = messageFlagCompressed
+			binary.BigEndian.PutUint32(datagram[1:], uint32(len(buf)))
+			copy(datagram[udpCompressedHeaderLen:], payload)
+			return datagram
+		}
+	}
+
+	datagram := make([]byte, 1+len(buf))
+	copy(datagram[1:], buf)
+	return datagram
+}
+
+// decompressDatagram strips the compression header of datagram @p and decompresses its payload. The
+// decompressed length should not exceed @maxLen. The second return value reports whether @p is compressed.
+func (u *gettyUDPConn) decompressDatagram(p []byte, maxLen int) ([]byte, bool, error) {
+	if len(p) == 0 {
+		return nil, false, perrors.Wrap(ErrCompressedMessageInvalid, "empty datagram")
+	}
+	if p[0]&messageFlagCompressed == 0 {
+		return p[1:], false, nil
+	}
+	if len(p) < udpCompressedHeaderLen {
+		return nil, false, perrors.Wrapf(ErrCompressedMessageInvalid, "datagram length %d", len(p))
+	}
+
+	rawLen := int(binary.BigEndian.Uint32(p[1:udpCompressedHeaderLen]))
+	pkg, err := decompressBlock(u.compressType, p[udpCompressedHeaderLen:], rawLen, maxLen)
+	return pkg, true, err
+}
+
 // close udp connection
 func (u *gettyUDPConn) CloseConn(_ int) {
 	if u.conn != nil {
@@ -492,7 +662,10 @@ type gettyWSConn struct {
 	gettyConn
 	writeLock sync.Mutex
 	readLock  sync.Mutex
-	conn      *websocket.Conn
+	// pre-shared key encryption of data messages
+	pskSend *pskStream
+	pskRecv *pskStream
+	conn    *websocket.Conn
 }
 
 // create websocket connection
@@ -542,6 +715,21 @@ func (w *gettyWSConn) SetCompressType(c CompressType) {
 	w.compress = c
 }
 
+// setPSK encrypts the data messages by the streams of the pre-shared key handshake.
+func (w *gettyWSConn) setPSK(send, recv *pskStream) {
+	w.pskSend = send
+	w.pskRecv = recv
+}
+
+// setReadLimit limits the length of a message to @maxMsgLen, not including the encryption overhead.
+func (w *gettyWSConn) setReadLimit(maxMsgLen int32) {
+	limit := int64(maxMsgLen)
+	if w.pskRecv != nil {
+		limit += pskOverhead
+	}
+	w.conn.SetReadLimit(limit)
+}
+
 func (w *gettyWSConn) handlePing(message string) error {
 	err := w.writePong([]byte(message))
 	if err == websocket.ErrCloseSent {
@@ -571,7 +759,7 @@ func (w *gettyWSConn) recv() ([]byte, error) {
 	// gorilla/websocket/conn.go:NextReader will always fail when got a timeout error.
 	_, b, e := w.threadSafeReadMessage() // the first return value is message type.
 	if e == nil {
-		w.readBytes.Add((uint32)(len(b)))
+		w.addReadBytes(len(b))
 	} else {
 		if websocket.IsUnexpectedCloseError(e, websocket.CloseGoingAway) {
 			log.Warnf("websocket unexpected CloseConn error: %v", e)
@@ -581,6 +769,18 @@ func (w *gettyWSConn) recv() ([]byte, error) {
 	return b, perrors.WithStack(e)
 }
 
+// recvBuffer reads a websocket message into a pooled ReadBuffer, which should be released by the caller.
+func (w *gettyWSConn) recvBuffer() (*ReadBuffer, int, error) {
+	rb, n, e := w.threadSafeReadBuffer()
+	if e == nil {
+		w.addReadBytes(n)
+	} else if websocket.IsUnexpectedCloseError(e, websocket.CloseGoingAway) {
+		log.Warnf("websocket unexpected CloseConn error: %v", e)
+	}
+
+	return rb, n, perrors.WithStack(e)
+}
+
 func (w *gettyWSConn) updateWriteDeadline() error {
 	var (
 		err         error
@@ -616,8 +816,7 @@ func (w *gettyWSConn) Send(pkg any) (int, error) {
 		log.Warnf("failed to update write deadline: %+v", err)
 	}
 	if err = w.threadSafeWriteMessage(websocket.BinaryMessage, p); err == nil {
-		w.writeBytes.Add((uint32)(len(p)))
-		w.writePkgNum.Add(1)
+		w.addWrite(len(p), 1)
 	}
 	return len(p), perrors.WithStack(err)
 }
@@ -659,6 +858,14 @@ func (w *gettyWSConn) CloseConn(waitSec int) {
 func (w *gettyWSConn) threadSafeWriteMessage(messageType int, data []byte) error {
 	w.writeLock.Lock()
 	defer w.writeLock.Unlock()
+	if w.pskSend != nil && messageType == websocket.BinaryMessage {
+		// the sequence numbers of the records should follow the order of the messages
+		sealed, err := w.pskSend.seal(make([]byte, 0, len(data)+pskOverhead), data, nil)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
 	if err := w.conn.WriteMessage(messageType, data); err != nil {
 		return err
 	}
@@ -670,8 +877,50 @@ func (w *gettyWSConn) threadSafeReadMessage() (int, []byte, error) {
 	w.readLock.Lock()
 	defer w.readLock.Unlock()
 	messageType, readBytes, err := w.conn.ReadMessage()
+	if err == nil && w.pskRecv != nil {
+		readBytes, err = w.pskRecv.open(readBytes[:0], readBytes, nil)
+	}
 	if err != nil {
 		return messageType, nil, err
 	}
 	return messageType, readBytes, nil
 }
+
+// threadSafeReadBuffer is the ReadBuffer version of threadSafeReadMessage.
+func (w *gettyWSConn) threadSafeReadBuffer() (*ReadBuffer, int, error) {
+	w.readLock.Lock()
+	defer w.readLock.Unlock()
+	_, r, err := w.conn.NextReader()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		m  int
+		n  int
+		rb = newReadBuffer(maxReadBufLen)
+	)
+	for {
+		if n == len(rb.buf) {
+			grown := newReadBuffer(n << 1)
+			copy(grown.buf, rb.buf[:n])
+			rb.Release()
+			rb = grown
+		}
+		m, err = r.Read(rb.buf[n:])
+		n += m
+		if err == io.EOF && w.pskRecv != nil {
+			var plaintext []byte
+			if plaintext, err = w.pskRecv.open(rb.buf[:0], rb.buf[:n], nil); err == nil {
+				return rb, len(plaintext), nil
+			}
+		}
+		if err == io.EOF {
+			return rb, n, nil
+		}
+		if err != nil {
+			rb.Release()
+			return nil, 0, err
+		}
+	}
+}
@@ -78,4 +78,6 @@ const (
 	CompressBestCompression              = flate.BestCompression    // 9
 	CompressHuffman                      = flate.HuffmanOnly        // -2
 	CompressSnappy                       = 10
+	CompressZstd                         = 11
+	CompressLZ4                          = 12
 )
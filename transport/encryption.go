@@ -0,0 +1,815 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	gxbytes "github.com/dubbogo/gost/bytes"
+
+	"github.com/gorilla/websocket"
+
+	perrors "github.com/pkg/errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// PSKCipher is the AEAD algorithm of the pre-shared key encryption layer
+type PSKCipher byte
+
+const (
+	PSKCipherAES256GCM PSKCipher = iota + 1
+	PSKCipherChaCha20Poly1305
+)
+
+const (
+	minPSKLen        = 16
+	pskSessionKeyLen = 32
+	pskRandomLen     = 32
+	pskConfirmLen    = sha256.Size
+	pskNonceLen      = 12
+	// pskOverhead is the length of the AEAD tag appended to every record
+	pskOverhead = 16
+
+	pskHandshakeTimeout = time.Second * 3
+	pskHandshakeMagic   = "GTPS"
+	pskHelloLen         = len(pskHandshakeMagic) + 1 + 1 + pskRandomLen
+	pskReplyLen         = len(pskHandshakeMagic) + 1 + pskRandomLen + pskConfirmLen
+	pskStatusOK         = 0
+	pskStatusRejected   = 1
+
+	// tcp record: length(4 bytes) | sealed payload
+	pskRecordHeaderLen = 4
+	maxPSKRecordLen    = 16 * 1024
+
+	// udp datagram: key id(1 byte) | sender id(8 bytes) | send time in unix milliseconds(8 bytes) |
+	// sequence number(8 bytes) | sealed payload
+	udpPSKSenderIDLen = 8
+	udpPSKTimeOffset  = 1 + udpPSKSenderIDLen
+	udpPSKSeqOffset   = udpPSKTimeOffset + 8
+	udpPSKHeaderLen   = udpPSKSeqOffset + 8
+	maxUDPPSKPeers    = 4096
+	replayWindowBits  = 1024
+	// the key derivation infos of the datagrams sent by the udp clients and by the udp servers
+	udpPSKClientInfo = "getty psk udp c2s"
+	udpPSKServerInfo = "getty psk udp s2c"
+)
+
+var (
+	// ErrPSKHandshake is returned when the pre-shared key handshake of a connection fails
+	ErrPSKHandshake = perrors.New("pre-shared key handshake failed")
+	// ErrPSKRecordInvalid is returned when an encrypted record or datagram can not be authenticated
+	ErrPSKRecordInvalid = perrors.New("invalid encrypted record")
+	// ErrPSKKeyNotFound is returned when a key id is not in the PSKKeyring
+	ErrPSKKeyNotFound = perrors.New("pre-shared key not found")
+
+	// a tcp/ws session derives a new key from the current one after every pskRekeyRecords records,
+	// and an udp session picks a new sender id.
+	pskRekeyRecords uint64 = 1 << 32
+	// the datagrams sent more than udpPSKMaxAge before or after the clock of the receiver are dropped
+	udpPSKMaxAge = 30 * time.Second
+)
+
+func (c PSKCipher) valid() bool {
+	return c == PSKCipherAES256GCM || c == PSKCipherChaCha20Poly1305
+}
+
+func newAEAD(c PSKCipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case PSKCipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, perrors.WithStack(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		return aead, perrors.WithStack(err)
+
+	case PSKCipherChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		return aead, perrors.WithStack(err)
+	}
+
+	return nil, perrors.Errorf("illegal pre-shared key cipher %d", c)
+}
+
+func deriveKey(secret, salt []byte, info string) []byte {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, pskSessionKeyLen)
+	if err != nil {
+		// it only fails when the key length is too long
+		panic(fmt.Sprintf("hkdf.Key() = err(%s)", err))
+	}
+
+	return key
+}
+
+/////////////////////////////////////////
+// PSKKeyring
+/////////////////////////////////////////
+
+// PSKKeyring holds the pre-shared keys of the encryption layer by their ids. New tcp/ws sessions and udp
+// datagrams use the current key and carry its id, so keys can be rotated without downtime: add the new
+// key to both peers, make it current, and remove the old key after the sessions using it are gone.
+type PSKKeyring struct {
+	lock    sync.RWMutex
+	keys    map[uint8][]byte
+	current uint8
+}
+
+// NewPSKKeyring creates a keyring whose current key is @key of @id. A key should be at least 16 bytes
+// of random data.
+func NewPSKKeyring(id uint8, key []byte) (*PSKKeyring, error) {
+	k := &PSKKeyring{keys: make(map[uint8][]byte)}
+	if err := k.AddKey(id, key); err != nil {
+		return nil, err
+	}
+	k.current = id
+
+	return k, nil
+}
+
+// AddKey adds or replaces the key of @id.
+func (k *PSKKeyring) AddKey(id uint8, key []byte) error {
+	if len(key) < minPSKLen {
+		return perrors.Errorf("pre-shared key length %d is less than %d", len(key), minPSKLen)
+	}
+
+	k.lock.Lock()
+	k.keys[id] = append([]byte(nil), key...)
+	k.lock.Unlock()
+
+	return nil
+}
+
+// SetCurrentKey makes the key of @id the one used by new sessions and outgoing udp datagrams.
+func (k *PSKKeyring) SetCurrentKey(id uint8) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return perrors.Wrapf(ErrPSKKeyNotFound, "key id %d", id)
+	}
+	k.current = id
+
+	return nil
+}
+
+// RemoveKey removes the key of @id, the current key can not be removed.
+func (k *PSKKeyring) RemoveKey(id uint8) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if id == k.current {
+		return perrors.Errorf("can not remove the current pre-shared key %d", id)
+	}
+	delete(k.keys, id)
+
+	return nil
+}
+
+func (k *PSKKeyring) currentKey() (uint8, []byte) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.current, k.keys[k.current]
+}
+
+func (k *PSKKeyring) key(id uint8) ([]byte, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+/////////////////////////////////////////
+// tcp/ws handshake
+/////////////////////////////////////////
+
+// pskStream seals or opens the records of one direction of a tcp/ws session. The nonce of a record is its
+// sequence number, so records can not be replayed, reordered or dropped without being detected.
+type pskStream struct {
+	suite PSKCipher
+	key   []byte
+	aead  cipher.AEAD
+	seq   uint64
+	nonce [pskNonceLen]byte
+}
+
+func newPSKStream(suite PSKCipher, key []byte) (*pskStream, error) {
+	aead, err := newAEAD(suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pskStream{suite: suite, key: key, aead: aead}, nil
+}
+
+func (s *pskStream) next() error {
+	s.seq++
+	if s.seq < pskRekeyRecords {
+		return nil
+	}
+
+	key := deriveKey(s.key, nil, "getty psk rekey")
+	aead, err := newAEAD(s.suite, key)
+	if err != nil {
+		return err
+	}
+	s.key = key
+	s.aead = aead
+	s.seq = 0
+
+	return nil
+}
+
+// seal appends the sealed @plaintext to @dst.
+func (s *pskStream) seal(dst, plaintext, additionalData []byte) ([]byte, error) {
+	binary.BigEndian.PutUint64(s.nonce[pskNonceLen-8:], s.seq)
+	dst = s.aead.Seal(dst, s.nonce[:], plaintext, additionalData)
+
+	return dst, s.next()
+}
+
+// open appends the opened @ciphertext to @dst, @ciphertext[:0] can be used as @dst to open it in place.
+func (s *pskStream) open(dst, ciphertext, additionalData []byte) ([]byte, error) {
+	binary.BigEndian.PutUint64(s.nonce[pskNonceLen-8:], s.seq)
+	plaintext, err := s.aead.Open(dst, s.nonce[:], ciphertext, additionalData)
+	if err != nil {
+		return nil, perrors.Wrap(ErrPSKRecordInvalid, err.Error())
+	}
+
+	return plaintext, s.next()
+}
+
+// pskConfirm proves that a peer owns the pre-shared key of the handshake, @info tells the proof of the
+// server from the one of the client.
+func pskConfirm(psk, salt, transcript []byte, info string) []byte {
+	mac := hmac.New(sha256.New, deriveKey(psk, salt, info))
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// pskSessionStreams derives the session keys of both directions from the pre-shared key and the randoms of
+// the handshake.
+func pskSessionStreams(suite PSKCipher, psk, salt []byte, client bool) (send, recv *pskStream, err error) {
+	c2s, err := newPSKStream(suite, deriveKey(psk, salt, "getty psk c2s"))
+	if err != nil {
+		return nil, nil, err
+	}
+	s2c, err := newPSKStream(suite, deriveKey(psk, salt, "getty psk s2c"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if client {
+		return c2s, s2c, nil
+	}
+
+	return s2c, c2s, nil
+}
+
+// pskClientHandshake sends the hello of the client, checks the reply of the server and sends the finish of the
+// client. The confirm of the reply and the one of the finish prove that the server and the client own the
+// pre-shared key, so both of them are authenticated.
+//
+//	hello:  magic(4 bytes) | cipher(1 byte) | key id(1 byte) | client random(32 bytes)
+//	reply:  magic(4 bytes) | status(1 byte) | server random(32 bytes) | confirm(32 bytes)
+//	finish: confirm(32 bytes)
+func pskClientHandshake(suite PSKCipher, keyring *PSKKeyring,
+	write func([]byte) error, read func([]byte) error,
+) (send, recv *pskStream, err error) {
+	id, psk := keyring.currentKey()
+	hello := make([]byte, 0, pskHelloLen)
+	hello = append(hello, pskHandshakeMagic...)
+	hello = append(hello, byte(suite), id)
+	hello = hello[:pskHelloLen]
+	if _, err = rand.Read(hello[pskHelloLen-pskRandomLen:]); err != nil {
+		return nil, nil, perrors.WithStack(err)
+	}
+	if err = write(hello); err != nil {
+		return nil, nil, err
+	}
+
+	reply := make([]byte, pskReplyLen)
+	if err = read(reply); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(reply[:len(pskHandshakeMagic)], []byte(pskHandshakeMagic)) {
+		return nil, nil, perrors.Wrapf(ErrPSKHandshake, "illegal handshake reply %v", reply)
+	}
+	if reply[len(pskHandshakeMagic)] != pskStatusOK {
+		return nil, nil, perrors.Wrapf(ErrPSKHandshake, "rejected by the server, cipher:%d, key id:%d", suite, id)
+	}
+
+	salt := make([]byte, 0, pskRandomLen<<1)
+	salt = append(salt, hello[pskHelloLen-pskRandomLen:]...)
+	salt = append(salt, reply[len(pskHandshakeMagic)+1:pskReplyLen-pskConfirmLen]...)
+	transcript := append(append([]byte(nil), hello...), reply[:pskReplyLen-pskConfirmLen]...)
+	if !hmac.Equal(pskConfirm(psk, salt, transcript, "getty psk confirm"), reply[pskReplyLen-pskConfirmLen:]) {
+		return nil, nil, perrors.Wrap(ErrPSKHandshake, "the server does not own the pre-shared key")
+	}
+	if err = write(pskConfirm(psk, salt, append(transcript, reply[pskReplyLen-pskConfirmLen:]...),
+		"getty psk client confirm")); err != nil {
+		return nil, nil, err
+	}
+
+	return pskSessionStreams(suite, psk, salt, true)
+}
+
+// pskServerHandshake reads the hello of pskClientHandshake, replies it and checks the finish of the client.
+// The server rejects the client if the cipher of the client is not @suite or the key id of the client is not
+// in @keyring, and fails if the client does not own the key.
+func pskServerHandshake(suite PSKCipher, keyring *PSKKeyring,
+	write func([]byte) error, read func([]byte) error,
+) (send, recv *pskStream, err error) {
+	hello := make([]byte, pskHelloLen)
+	if err = read(hello); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hello[:len(pskHandshakeMagic)], []byte(pskHandshakeMagic)) {
+		return nil, nil, perrors.Wrapf(ErrPSKHandshake, "illegal handshake hello %v", hello)
+	}
+
+	reply := make([]byte, 0, pskReplyLen)
+	reply = append(reply, pskHandshakeMagic...)
+	clientSuite, id := PSKCipher(hello[len(pskHandshakeMagic)]), hello[len(pskHandshakeMagic)+1]
+	psk, ok := keyring.key(id)
+	if clientSuite != suite || !ok {
+		reply = append(reply, pskStatusRejected)
+		reply = reply[:pskReplyLen]
+		if err = write(reply); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, perrors.Wrapf(ErrPSKHandshake, "reject client cipher:%d, key id:%d", clientSuite, id)
+	}
+
+	reply = append(reply, pskStatusOK)
+	reply = reply[:pskReplyLen-pskConfirmLen]
+	if _, err = rand.Read(reply[len(pskHandshakeMagic)+1:]); err != nil {
+		return nil, nil, perrors.WithStack(err)
+	}
+	salt := make([]byte, 0, pskRandomLen<<1)
+	salt = append(salt, hello[pskHelloLen-pskRandomLen:]...)
+	salt = append(salt, reply[len(pskHandshakeMagic)+1:]...)
+	transcript := append(append([]byte(nil), hello...), reply...)
+	reply = append(reply, pskConfirm(psk, salt, transcript, "getty psk confirm")...)
+	if err = write(reply); err != nil {
+		return nil, nil, err
+	}
+
+	finish := make([]byte, pskConfirmLen)
+	if err = read(finish); err != nil {
+		return nil, nil, err
+	}
+	transcript = append(transcript, reply[pskReplyLen-pskConfirmLen:]...)
+	if !hmac.Equal(pskConfirm(psk, salt, transcript, "getty psk client confirm"), finish) {
+		return nil, nil, perrors.Wrapf(ErrPSKHandshake, "the client does not own the pre-shared key %d", id)
+	}
+
+	return pskSessionStreams(suite, psk, salt, false)
+}
+
+func connHandshakeIO(conn net.Conn) (write func([]byte) error, read func([]byte) error) {
+	write = func(p []byte) error {
+		_, err := conn.Write(p)
+		return perrors.WithStack(err)
+	}
+	read = func(p []byte) error {
+		_, err := io.ReadFull(conn, p)
+		return perrors.WithStack(err)
+	}
+
+	return write, read
+}
+
+// requestPSKConn runs the client side of the pre-shared key handshake on @conn and returns the encrypted conn.
+func requestPSKConn(conn net.Conn, suite PSKCipher, keyring *PSKKeyring) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(pskHandshakeTimeout)); err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	write, read := connHandshakeIO(conn)
+	send, recv, err := pskClientHandshake(suite, keyring, write, read)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecureConn(conn, send, recv), nil
+}
+
+// acceptPSKConn runs the server side of the pre-shared key handshake on @conn and returns the encrypted conn.
+func acceptPSKConn(conn net.Conn, suite PSKCipher, keyring *PSKKeyring) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(pskHandshakeTimeout)); err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	write, read := connHandshakeIO(conn)
+	send, recv, err := pskServerHandshake(suite, keyring, write, read)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecureConn(conn, send, recv), nil
+}
+
+func wsHandshakeIO(conn *websocket.Conn) (write func([]byte) error, read func([]byte) error) {
+	write = func(p []byte) error {
+		return perrors.WithStack(conn.WriteMessage(websocket.BinaryMessage, p))
+	}
+	read = func(p []byte) error {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return perrors.WithStack(err)
+		}
+		if len(message) != len(p) {
+			return perrors.Wrapf(ErrPSKHandshake, "illegal handshake message length %d", len(message))
+		}
+		copy(p, message)
+		return nil
+	}
+
+	return write, read
+}
+
+// requestPSKWS runs the client side of the pre-shared key handshake on websocket @conn, every handshake
+// message is a binary message.
+func requestPSKWS(conn *websocket.Conn, suite PSKCipher, keyring *PSKKeyring) (send, recv *pskStream, err error) {
+	deadline := time.Now().Add(pskHandshakeTimeout)
+	if err = conn.SetWriteDeadline(deadline); err == nil {
+		err = conn.SetReadDeadline(deadline)
+	}
+	if err != nil {
+		return nil, nil, perrors.WithStack(err)
+	}
+	defer func() {
+		_ = conn.SetWriteDeadline(time.Time{})
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	write, read := wsHandshakeIO(conn)
+	return pskClientHandshake(suite, keyring, write, read)
+}
+
+// acceptPSKWS runs the server side of the pre-shared key handshake on websocket @conn.
+func acceptPSKWS(conn *websocket.Conn, suite PSKCipher, keyring *PSKKeyring) (send, recv *pskStream, err error) {
+	deadline := time.Now().Add(pskHandshakeTimeout)
+	if err = conn.SetWriteDeadline(deadline); err == nil {
+		err = conn.SetReadDeadline(deadline)
+	}
+	if err != nil {
+		return nil, nil, perrors.WithStack(err)
+	}
+	defer func() {
+		_ = conn.SetWriteDeadline(time.Time{})
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	write, read := wsHandshakeIO(conn)
+	return pskServerHandshake(suite, keyring, write, read)
+}
+
+/////////////////////////////////////////
+// tcp records
+/////////////////////////////////////////
+
+// secureConn encrypts a tcp stream into records of at most maxPSKRecordLen bytes:
+//
+//	length of the sealed payload(4 bytes) | sealed payload
+//
+// The length is authenticated as the additional data. A read timeout keeps the partial record, so the
+// caller can go on reading after it.
+type secureConn struct {
+	net.Conn
+
+	writeLock sync.Mutex
+	send      *pskStream
+	werr      error
+
+	readLock sync.Mutex
+	recv     *pskStream
+	rerr     error
+	rbuf     []byte
+	rstart   int
+	rend     int
+	plain    []byte
+}
+
+func newSecureConn(conn net.Conn, send, recv *pskStream) *secureConn {
+	return &secureConn{
+		Conn: conn,
+		send: send,
+		recv: recv,
+		rbuf: make([]byte, pskRecordHeaderLen+maxPSKRecordLen+pskOverhead),
+	}
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.werr != nil {
+		return 0, c.werr
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	records := (len(p) + maxPSKRecordLen - 1) / maxPSKRecordLen
+	bufp := gxbytes.AcquireBytes(len(p) + records*(pskRecordHeaderLen+pskOverhead))
+	defer gxbytes.ReleaseBytes(bufp)
+	out := (*bufp)[:0]
+	for rest := p; len(rest) > 0; {
+		chunk := rest
+		if len(chunk) > maxPSKRecordLen {
+			chunk = chunk[:maxPSKRecordLen]
+		}
+		rest = rest[len(chunk):]
+
+		header := out[len(out) : len(out)+pskRecordHeaderLen]
+		binary.BigEndian.PutUint32(header, uint32(len(chunk)+pskOverhead))
+		out = out[:len(out)+pskRecordHeaderLen]
+		if out, c.werr = c.send.seal(out, chunk, header); c.werr != nil {
+			return 0, c.werr
+		}
+	}
+
+	if _, c.werr = c.Conn.Write(out); c.werr != nil {
+		// the stream is broken after a partial record
+		c.werr = perrors.WithStack(c.werr)
+		return 0, c.werr
+	}
+
+	return len(p), nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	for len(c.plain) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// readRecord opens the next record in place, it should be called after the former record is consumed.
+func (c *secureConn) readRecord() error {
+	if c.rerr != nil {
+		return c.rerr
+	}
+
+	for {
+		if buffered := c.rend - c.rstart; buffered >= pskRecordHeaderLen {
+			header := c.rbuf[c.rstart : c.rstart+pskRecordHeaderLen]
+			recordLen := int(binary.BigEndian.Uint32(header))
+			if recordLen < pskOverhead || recordLen > maxPSKRecordLen+pskOverhead {
+				c.rerr = perrors.Wrapf(ErrPSKRecordInvalid, "record length %d", recordLen)
+				return c.rerr
+			}
+			if buffered >= pskRecordHeaderLen+recordLen {
+				record := c.rbuf[c.rstart+pskRecordHeaderLen : c.rstart+pskRecordHeaderLen+recordLen]
+				if c.plain, c.rerr = c.recv.open(record[:0], record, header); c.rerr != nil {
+					return c.rerr
+				}
+				c.rstart += pskRecordHeaderLen + recordLen
+				return nil
+			}
+		}
+
+		if c.rstart > 0 {
+			c.rend = copy(c.rbuf, c.rbuf[c.rstart:c.rend])
+			c.rstart = 0
+		}
+		n, err := c.Conn.Read(c.rbuf[c.rend:])
+		c.rend += n
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/////////////////////////////////////////
+// udp datagrams
+/////////////////////////////////////////
+
+// replayWindow is a sliding window of the latest replayWindowBits sequence numbers of an udp sender.
+type replayWindow struct {
+	top  uint64
+	bits [replayWindowBits / 64]uint64
+}
+
+// check reports whether @seq has not been seen and is not too old.
+func (w *replayWindow) check(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.top {
+		return true
+	}
+	if w.top-seq >= replayWindowBits {
+		return false
+	}
+
+	idx := seq % replayWindowBits
+	return w.bits[idx/64]&(1<<(idx%64)) == 0
+}
+
+// update marks @seq which has passed check as seen.
+func (w *replayWindow) update(seq uint64) {
+	if seq > w.top {
+		if seq-w.top >= replayWindowBits {
+			w.bits = [replayWindowBits / 64]uint64{}
+		} else {
+			for s := w.top + 1; s < seq; s++ {
+				idx := s % replayWindowBits
+				w.bits[idx/64] &^= 1 << (idx % 64)
+			}
+		}
+		w.top = seq
+	}
+
+	idx := seq % replayWindowBits
+	w.bits[idx/64] |= 1 << (idx % 64)
+}
+
+type udpPSKPeer struct {
+	keyID  uint8
+	sender [udpPSKSenderIDLen]byte
+}
+
+type udpPSKPeerState struct {
+	peer   udpPSKPeer
+	aead   cipher.AEAD
+	window replayWindow
+}
+
+// udpPSK encrypts the datagrams of an udp session. Every sender picks a random sender id, and the key of its
+// datagrams is derived from the pre-shared key, the id and the direction, client to server or server to
+// client, so a datagram can not be reflected back to its sender. A receiver drops its own datagrams, the
+// datagrams whose sequence number has been seen or is older than the replay window of the sender, and the
+// datagrams whose send time is more than udpPSKMaxAge away from its clock, so the clocks of the peers should
+// be synchronized. The replay windows of at most maxUDPPSKPeers senders are kept, and the least recently
+// used one is evicted; the datagrams of an evicted sender are only checked by their send time, so they can
+// be replayed within udpPSKMaxAge after they are sent.
+type udpPSK struct {
+	suite    PSKCipher
+	keyring  *PSKKeyring
+	sealInfo string
+	openInfo string
+
+	sendLock sync.Mutex
+	sendKey  uint8
+	sender   [udpPSKSenderIDLen]byte
+	sendAEAD cipher.AEAD
+	sendSeq  uint64
+
+	// peers and lru, the *udpPSKPeerState list of them in order of use, are only accessed by the read
+	// goroutine of the session
+	peers map[udpPSKPeer]*list.Element
+	lru   *list.List
+}
+
+// newUDPPSK returns the udpPSK of an udp client if @client is true, or of an udp server.
+func newUDPPSK(suite PSKCipher, keyring *PSKKeyring, client bool) *udpPSK {
+	sealInfo, openInfo := udpPSKServerInfo, udpPSKClientInfo
+	if client {
+		sealInfo, openInfo = openInfo, sealInfo
+	}
+
+	return &udpPSK{
+		suite:    suite,
+		keyring:  keyring,
+		sealInfo: sealInfo,
+		openInfo: openInfo,
+		peers:    make(map[udpPSKPeer]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func udpPSKNonce(seq uint64) []byte {
+	var nonce [pskNonceLen]byte
+	binary.BigEndian.PutUint64(nonce[pskNonceLen-8:], seq)
+	return nonce[:]
+}
+
+// seal encrypts datagram @p into a new buffer.
+func (u *udpPSK) seal(p []byte) ([]byte, error) {
+	u.sendLock.Lock()
+	defer u.sendLock.Unlock()
+
+	id, psk := u.keyring.currentKey()
+	if u.sendAEAD == nil || id != u.sendKey || u.sendSeq+1 >= pskRekeyRecords {
+		if _, err := rand.Read(u.sender[:]); err != nil {
+			return nil, perrors.WithStack(err)
+		}
+		aead, err := newAEAD(u.suite, deriveKey(psk, u.sender[:], u.sealInfo))
+		if err != nil {
+			return nil, err
+		}
+		u.sendKey, u.sendAEAD, u.sendSeq = id, aead, 0
+	}
+	u.sendSeq++
+
+	var header [udpPSKHeaderLen]byte
+	header[0] = id
+	copy(header[1:], u.sender[:])
+	binary.BigEndian.PutUint64(header[udpPSKTimeOffset:], uint64(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint64(header[udpPSKSeqOffset:], u.sendSeq)
+	datagram := make([]byte, udpPSKHeaderLen, udpPSKHeaderLen+len(p)+pskOverhead)
+	copy(datagram, header[:])
+
+	return u.sendAEAD.Seal(datagram, udpPSKNonce(u.sendSeq), p, header[:]), nil
+}
+
+// open authenticates and decrypts datagram @p in place.
+func (u *udpPSK) open(p []byte) ([]byte, error) {
+	if len(p) < udpPSKHeaderLen+pskOverhead {
+		return nil, perrors.Wrapf(ErrPSKRecordInvalid, "datagram length %d", len(p))
+	}
+
+	var (
+		peer   udpPSKPeer
+		header [udpPSKHeaderLen]byte
+	)
+	copy(header[:], p)
+	peer.keyID = header[0]
+	copy(peer.sender[:], header[1:])
+	sent := time.UnixMilli(int64(binary.BigEndian.Uint64(header[udpPSKTimeOffset:])))
+	seq := binary.BigEndian.Uint64(header[udpPSKSeqOffset:])
+	if age := time.Since(sent); age > udpPSKMaxAge || age < -udpPSKMaxAge {
+		return nil, perrors.Wrapf(ErrPSKRecordInvalid, "stale datagram, sent at %s", sent)
+	}
+	u.sendLock.Lock()
+	reflected := peer.sender == u.sender
+	u.sendLock.Unlock()
+	if reflected {
+		return nil, perrors.Wrap(ErrPSKRecordInvalid, "reflected datagram")
+	}
+
+	var state *udpPSKPeerState
+	elem, ok := u.peers[peer]
+	if ok {
+		state = elem.Value.(*udpPSKPeerState)
+	} else {
+		psk, found := u.keyring.key(peer.keyID)
+		if !found {
+			return nil, perrors.Wrapf(ErrPSKKeyNotFound, "key id %d", peer.keyID)
+		}
+		aead, err := newAEAD(u.suite, deriveKey(psk, peer.sender[:], u.openInfo))
+		if err != nil {
+			return nil, err
+		}
+		state = &udpPSKPeerState{peer: peer, aead: aead}
+	}
+	if !state.window.check(seq) {
+		return nil, perrors.Wrapf(ErrPSKRecordInvalid, "replayed datagram, sequence number %d", seq)
+	}
+
+	ciphertext := p[udpPSKHeaderLen:]
+	plaintext, err := state.aead.Open(ciphertext[:0], udpPSKNonce(seq), ciphertext, header[:])
+	if err != nil {
+		return nil, perrors.Wrap(ErrPSKRecordInvalid, err.Error())
+	}
+	state.window.update(seq)
+	if ok {
+		u.lru.MoveToFront(elem)
+	} else {
+		// only authenticated senders are kept
+		if u.lru.Len() >= maxUDPPSKPeers {
+			delete(u.peers, u.lru.Remove(u.lru.Back()).(*udpPSKPeerState).peer)
+		}
+		u.peers[peer] = u.lru.PushFront(state)
+	}
+
+	return plaintext, nil
+}
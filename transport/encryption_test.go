@@ -0,0 +1,399 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyring(t *testing.T, id uint8, key string) *PSKKeyring {
+	keyring, err := NewPSKKeyring(id, []byte(key))
+	assert.Nil(t, err)
+	return keyring
+}
+
+// pskConnPair returns both ends of an encrypted loopback tcp connection
+func pskConnPair(t *testing.T, suite PSKCipher, client, server *PSKKeyring) (net.Conn, net.Conn, error, error) {
+	clientConn, serverConn := tcpConnPair(t)
+	accepted := make(chan error, 1)
+	var securedServer net.Conn
+	go func() {
+		var err error
+		securedServer, err = acceptPSKConn(serverConn, suite, server)
+		accepted <- err
+	}()
+	securedClient, clientErr := requestPSKConn(clientConn, suite, client)
+	if clientErr != nil {
+		// the server waits for the finish of the client
+		_ = clientConn.Close()
+	}
+	serverErr := <-accepted
+	if clientErr != nil || serverErr != nil {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}
+
+	return securedClient, securedServer, clientErr, serverErr
+}
+
+func TestPSKKeyring(t *testing.T) {
+	_, err := NewPSKKeyring(1, []byte("short"))
+	assert.NotNil(t, err)
+
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	assert.NotNil(t, keyring.AddKey(2, nil))
+	assert.ErrorIs(t, keyring.SetCurrentKey(2), ErrPSKKeyNotFound)
+	assert.Nil(t, keyring.AddKey(2, []byte("fedcba9876543210")))
+	assert.Nil(t, keyring.SetCurrentKey(2))
+	assert.NotNil(t, keyring.RemoveKey(2))
+	assert.Nil(t, keyring.RemoveKey(1))
+	_, ok := keyring.key(1)
+	assert.False(t, ok)
+	id, key := keyring.currentKey()
+	assert.Equal(t, uint8(2), id)
+	assert.Equal(t, []byte("fedcba9876543210"), key)
+
+	assert.Panics(t, func() { WithServerPSKEncryption(PSKCipher(0), keyring) })
+	assert.Panics(t, func() { WithClientPSKEncryption(PSKCipherAES256GCM, nil) })
+}
+
+func TestSecureConn(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+	for _, suite := range []PSKCipher{PSKCipherAES256GCM, PSKCipherChaCha20Poly1305} {
+		keyring := newTestKeyring(t, 7, "0123456789abcdef0123456789abcdef")
+		client, server, clientErr, serverErr := pskConnPair(t, suite, keyring, keyring)
+		assert.Nil(t, clientErr)
+		assert.Nil(t, serverErr)
+
+		go func() {
+			_, _ = client.Write(payload[:10])
+			_, _ = client.Write(payload)
+		}()
+		buf := make([]byte, 10+len(payload))
+		_, err := io.ReadFull(server, buf)
+		assert.Nil(t, err, "cipher %d", suite)
+		assert.Equal(t, payload[:10], buf[:10])
+		assert.Equal(t, payload, buf[10:])
+
+		// a timeout in the middle of a record does not break the stream
+		raw := client.(*secureConn).Conn
+		record := make([]byte, 0, pskRecordHeaderLen+3+pskOverhead)
+		record = append(record, 0, 0, 0, 3+pskOverhead)
+		record, err = client.(*secureConn).send.seal(record, []byte("abc"), record[:pskRecordHeaderLen])
+		assert.Nil(t, err)
+		_, err = raw.Write(record[:5])
+		assert.Nil(t, err)
+		assert.Nil(t, server.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err = server.Read(buf)
+		assert.True(t, err.(net.Error).Timeout())
+		_, err = raw.Write(record[5:])
+		assert.Nil(t, err)
+		assert.Nil(t, server.SetReadDeadline(time.Time{}))
+		n, err := server.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc", string(buf[:n]))
+
+		// a tampered record breaks the stream
+		record = record[:0]
+		record = append(record, 0, 0, 0, 3+pskOverhead)
+		record, err = client.(*secureConn).send.seal(record, []byte("abc"), record[:pskRecordHeaderLen])
+		assert.Nil(t, err)
+		record[len(record)-1] ^= 0xff
+		_, err = raw.Write(record)
+		assert.Nil(t, err)
+		_, err = server.Read(buf)
+		assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestPSKRekey(t *testing.T) {
+	rekeyRecords := pskRekeyRecords
+	pskRekeyRecords = 3
+	defer func() { pskRekeyRecords = rekeyRecords }()
+
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	client, server, clientErr, serverErr := pskConnPair(t, PSKCipherAES256GCM, keyring, keyring)
+	assert.Nil(t, clientErr)
+	assert.Nil(t, serverErr)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	key := client.(*secureConn).send.key
+	buf := make([]byte, 16)
+	for i := 0; i < 10; i++ {
+		_, err := client.Write([]byte("record"))
+		assert.Nil(t, err)
+		n, err := server.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, "record", string(buf[:n]))
+	}
+	assert.NotEqual(t, key, client.(*secureConn).send.key)
+	assert.Equal(t, client.(*secureConn).send.key, server.(*secureConn).recv.key)
+}
+
+func TestPSKHandshakeRejected(t *testing.T) {
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	other := newTestKeyring(t, 2, "0123456789abcdef")
+	_, _, clientErr, serverErr := pskConnPair(t, PSKCipherAES256GCM, other, keyring)
+	assert.ErrorIs(t, clientErr, ErrPSKHandshake)
+	assert.ErrorIs(t, serverErr, ErrPSKHandshake)
+
+	// the same key id of a different key
+	wrong := newTestKeyring(t, 1, "fedcba9876543210")
+	_, _, clientErr, serverErr = pskConnPair(t, PSKCipherAES256GCM, wrong, keyring)
+	assert.ErrorIs(t, clientErr, ErrPSKHandshake)
+	assert.NotNil(t, serverErr)
+
+	// a client without the key can not forge its finish
+	var messages [][]byte
+	hello := append([]byte(pskHandshakeMagic), byte(PSKCipherAES256GCM), 1)
+	hello = append(hello, make([]byte, pskRandomLen)...)
+	messages = append(messages, hello, make([]byte, pskConfirmLen))
+	write := func([]byte) error { return nil }
+	read := func(p []byte) error {
+		copy(p, messages[0])
+		messages = messages[1:]
+		return nil
+	}
+	_, _, err := pskServerHandshake(PSKCipherAES256GCM, keyring, write, read)
+	assert.ErrorIs(t, err, ErrPSKHandshake)
+
+	_, _, clientErr, serverErr = pskConnPair(t, PSKCipherChaCha20Poly1305, keyring, keyring)
+	assert.Nil(t, clientErr)
+	assert.Nil(t, serverErr)
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	assert.False(t, w.check(0))
+	for _, seq := range []uint64{1, 3, 2, 1000} {
+		assert.True(t, w.check(seq))
+		w.update(seq)
+		assert.False(t, w.check(seq))
+	}
+	assert.True(t, w.check(4))
+	w.update(replayWindowBits + 2)
+	assert.False(t, w.check(2))
+	assert.True(t, w.check(replayWindowBits+1))
+	assert.True(t, w.check(1000+replayWindowBits))
+	w.update(1000 + replayWindowBits)
+	assert.False(t, w.check(1000+replayWindowBits))
+	w.update(100 * replayWindowBits)
+	assert.True(t, w.check(100*replayWindowBits-1))
+	assert.False(t, w.check(100*replayWindowBits))
+}
+
+func TestUDPPSK(t *testing.T) {
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	sender := newUDPPSK(PSKCipherChaCha20Poly1305, keyring, true)
+	receiver := newUDPPSK(PSKCipherChaCha20Poly1305, keyring, false)
+
+	first, err := sender.seal([]byte("first"))
+	assert.Nil(t, err)
+	second, err := sender.seal([]byte("second"))
+	assert.Nil(t, err)
+	replayed := append([]byte(nil), first...)
+
+	plaintext, err := receiver.open(second)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(plaintext))
+	plaintext, err = receiver.open(first)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(plaintext))
+	_, err = receiver.open(replayed)
+	assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+
+	// rotate the key, the datagrams of the old key are still accepted until it is removed
+	assert.Nil(t, keyring.AddKey(2, []byte("fedcba9876543210")))
+	assert.Nil(t, keyring.SetCurrentKey(2))
+	rotated, err := sender.seal([]byte("rotated"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(2), rotated[0])
+	plaintext, err = receiver.open(rotated)
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated", string(plaintext))
+
+	stale := newUDPPSK(PSKCipherChaCha20Poly1305, newTestKeyring(t, 1, "0123456789abcdef"), true)
+	old, err := stale.seal([]byte("old"))
+	assert.Nil(t, err)
+	assert.Nil(t, keyring.RemoveKey(1))
+	_, err = receiver.open(old)
+	assert.ErrorIs(t, err, ErrPSKKeyNotFound)
+
+	tampered, err := sender.seal([]byte("tampered"))
+	assert.Nil(t, err)
+	tampered[udpPSKHeaderLen] ^= 0xff
+	_, err = receiver.open(tampered)
+	assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+
+	// the datagrams sent too long ago are dropped even if the sender is unknown
+	maxAge := udpPSKMaxAge
+	udpPSKMaxAge = 10 * time.Millisecond
+	defer func() { udpPSKMaxAge = maxAge }()
+	delayed, err := newUDPPSK(PSKCipherChaCha20Poly1305, keyring, true).seal([]byte("delayed"))
+	assert.Nil(t, err)
+	time.Sleep(30 * time.Millisecond)
+	_, err = receiver.open(delayed)
+	assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+}
+
+func TestUDPPSKReflection(t *testing.T) {
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	client := newUDPPSK(PSKCipherAES256GCM, keyring, true)
+	server := newUDPPSK(PSKCipherAES256GCM, keyring, false)
+
+	request, err := client.seal([]byte("request"))
+	assert.Nil(t, err)
+	reflected := append([]byte(nil), request...)
+	plaintext, err := server.open(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "request", string(plaintext))
+	// a datagram reflected back to its sender is dropped
+	_, err = client.open(reflected)
+	assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+
+	// a datagram of another client can not be opened by a client either
+	other, err := newUDPPSK(PSKCipherAES256GCM, keyring, true).seal([]byte("other"))
+	assert.Nil(t, err)
+	_, err = client.open(other)
+	assert.ErrorIs(t, err, ErrPSKRecordInvalid)
+
+	response, err := server.seal([]byte("response"))
+	assert.Nil(t, err)
+	plaintext, err = client.open(response)
+	assert.Nil(t, err)
+	assert.Equal(t, "response", string(plaintext))
+}
+
+func TestUDPPSKPeerEviction(t *testing.T) {
+	keyring := newTestKeyring(t, 1, "0123456789abcdef")
+	receiver := newUDPPSK(PSKCipherAES256GCM, keyring, false)
+	open := func(sender *udpPSK) {
+		datagram, err := sender.seal([]byte("datagram"))
+		assert.Nil(t, err)
+		_, err = receiver.open(datagram)
+		assert.Nil(t, err)
+	}
+
+	senders := make([]*udpPSK, maxUDPPSKPeers)
+	for i := range senders {
+		senders[i] = newUDPPSK(PSKCipherAES256GCM, keyring, true)
+		open(senders[i])
+	}
+	// the first sender is used again, so the second one is the least recently used
+	open(senders[0])
+	open(newUDPPSK(PSKCipherAES256GCM, keyring, true))
+	assert.Equal(t, maxUDPPSKPeers, len(receiver.peers))
+	assert.Equal(t, maxUDPPSKPeers, receiver.lru.Len())
+	_, ok := receiver.peers[udpPSKPeer{keyID: 1, sender: senders[0].sender}]
+	assert.True(t, ok)
+	_, ok = receiver.peers[udpPSKPeer{keyID: 1, sender: senders[1].sender}]
+	assert.False(t, ok)
+}
+
+func TestPSKEncryptionSessions(t *testing.T) {
+	keyring := newTestKeyring(t, 1, "0123456789abcdef0123456789abcdef")
+	long := strings.Repeat("encrypted ", 5000)
+	lines := []string{"short", long, "last"}
+
+	for _, typ := range []EndPointType{TCP_SERVER, WS_SERVER} {
+		var serverHandler, clientHandler lineHandler
+		server := newServer(typ, WithLocalAddress("127.0.0.1:0"), WithWebsocketServerPath("/psk"),
+			WithServerPSKEncryption(PSKCipherAES256GCM, keyring))
+		server.RunEventLoop(func(session Session) error {
+			session.SetPkgHandler(&serverHandler)
+			session.SetEventListener(&serverHandler)
+			session.SetMaxMsgLen(128 * 1024)
+			return nil
+		})
+
+		clientType, addr := TCP_CLIENT, server.addr
+		if typ == WS_SERVER {
+			clientType, addr = WS_CLIENT, "ws://"+server.addr+"/psk"
+		}
+		sessions := make(chan Session, 1)
+		client := newClient(clientType, WithServerAddress(addr), WithConnectionNumber(1),
+			WithClientPSKEncryption(PSKCipherAES256GCM, keyring))
+		client.RunEventLoop(func(session Session) error {
+			session.SetPkgHandler(&clientHandler)
+			session.SetEventListener(&clientHandler)
+			sessions <- session
+			return nil
+		})
+
+		ss := <-sessions
+		for _, line := range lines {
+			_, _, err := ss.WritePkg(line, 0)
+			assert.Nil(t, err)
+		}
+		assert.Eventually(t, func() bool {
+			got, _ := serverHandler.snapshot()
+			return len(got) == len(lines)
+		}, 3*time.Second, 10*time.Millisecond, "server type %s", typ)
+		got, _ := serverHandler.snapshot()
+		assert.Equal(t, lines, got)
+
+		client.Close()
+		server.Close()
+	}
+
+	var handler udpLineHandler
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"),
+		WithServerPSKEncryption(PSKCipherAES256GCM, keyring))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetMaxMsgLen(8 * 1024)
+		return nil
+	})
+	defer server.Close()
+
+	peer, err := net.Dial("udp", server.pktListeners[0].LocalAddr().String())
+	assert.Nil(t, err)
+	defer func() { _ = peer.Close() }()
+
+	sealer := newUDPPSK(PSKCipherAES256GCM, keyring, true)
+	first, err := sealer.seal([]byte("first\n"))
+	assert.Nil(t, err)
+	last, err := sealer.seal([]byte("last\n"))
+	assert.Nil(t, err)
+	// the plaintext and the replayed datagram are dropped
+	for _, d := range [][]byte{[]byte("plain\n"), first, first, last} {
+		_, err = peer.Write(d)
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	got, _ := handler.snapshot()
+	assert.Equal(t, []string{"first", "last"}, got)
+}
@@ -0,0 +1,121 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+var (
+	// ErrWriteTimeout is matched by the error of a write exceeding the write timeout of the session
+	ErrWriteTimeout = perrors.New("write timeout")
+	// ErrPeerReset is matched by the error of a read or write on a connection reset by the peer
+	ErrPeerReset = perrors.New("connection reset by peer")
+	// ErrMsgTooLong is matched by the DecodeError of a package longer than the max message length of the session
+	ErrMsgTooLong = perrors.New("message too long")
+	// ErrTLSHandshake is matched by the error of a failed tls handshake
+	ErrTLSHandshake = perrors.New("tls handshake failed")
+)
+
+// DecodeError is the error of a package that the Reader of a session fails to decode, or that is longer than
+// the max message length of the session. Err is the error returned by the Reader or matches ErrMsgTooLong.
+type DecodeError struct {
+	// PkgLen is the package length returned by the Reader, or the message length of a udp/websocket session
+	PkgLen int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode the package, pkgLen %d: %v", e.PkgLen, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newMsgTooLongError returns the DecodeError of a package of @pkgLen longer than @maxMsgLen.
+func newMsgTooLongError(pkgLen int, maxMsgLen int32) error {
+	return &DecodeError{PkgLen: pkgLen, Err: perrors.WithMessagef(ErrMsgTooLong, "session max message len %d", maxMsgLen)}
+}
+
+// classifiedError classifies @err as @kind. errors.Is matches both of them, and perrors.Cause returns the
+// cause of @err so that the checks of the original error keep working.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func (e *classifiedError) Cause() error {
+	return e.err
+}
+
+func (e *classifiedError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%s: %+v", e.kind, e.err)
+		return
+	}
+	_, _ = io.WriteString(s, e.Error())
+}
+
+// isPeerReset checks whether @err is caused by a connection reset by the peer.
+func isPeerReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, ErrFaultReset)
+}
+
+// classifyReadError classifies the read error @err of a connection as ErrPeerReset.
+func classifyReadError(err error) error {
+	if err == nil || errors.Is(err, ErrPeerReset) || !isPeerReset(err) {
+		return err
+	}
+	return &classifiedError{kind: ErrPeerReset, err: err}
+}
+
+// classifyWriteError classifies the write error @err of a connection as ErrSessionClosed, ErrWriteTimeout or
+// ErrPeerReset.
+func classifyWriteError(err error) error {
+	if err == nil || errors.Is(err, ErrSessionClosed) || errors.Is(err, ErrWriteTimeout) || errors.Is(err, ErrPeerReset) {
+		return err
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe):
+		return &classifiedError{kind: ErrSessionClosed, err: err}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &classifiedError{kind: ErrWriteTimeout, err: err}
+	case isPeerReset(err):
+		return &classifiedError{kind: ErrPeerReset, err: err}
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyErrors(t *testing.T) {
+	reset := perrors.WithStack(&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)})
+	err := classifyWriteError(reset)
+	assert.ErrorIs(t, err, ErrPeerReset)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.ErrorIs(t, classifyReadError(reset), ErrPeerReset)
+	var opErr *net.OpError
+	assert.True(t, errors.As(perrors.WithStack(err), &opErr))
+	// the checks of the original error keep working
+	_, ok := perrors.Cause(err).(*net.OpError)
+	assert.True(t, ok)
+	assert.Contains(t, fmt.Sprintf("%+v", err), "TestClassifyErrors")
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	assert.Nil(t, local.SetWriteDeadline(time.Now()))
+	_, err = local.Write([]byte("hello"))
+	err = classifyWriteError(err)
+	assert.ErrorIs(t, err, ErrWriteTimeout)
+	assert.NotErrorIs(t, err, ErrPeerReset)
+	local.Close()
+	_, err = local.Write([]byte("hello"))
+	assert.ErrorIs(t, classifyWriteError(err), ErrSessionClosed)
+
+	assert.Nil(t, classifyWriteError(nil))
+	assert.Equal(t, ErrSessionClosed, classifyWriteError(ErrSessionClosed))
+	assert.Equal(t, ErrRateLimited, classifyReadError(ErrRateLimited))
+
+	var decodeErr *DecodeError
+	err = perrors.WithStack(newMsgTooLongError(200, 128))
+	assert.ErrorIs(t, err, ErrMsgTooLong)
+	assert.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, 200, decodeErr.PkgLen)
+}
+
+func TestTLSHandshakeError(t *testing.T) {
+	local, remote := net.Pipe()
+	remote.Close()
+	err := handshakeTLS(tls.Client(local, &tls.Config{InsecureSkipVerify: true}), time.Second)
+	assert.ErrorIs(t, err, ErrTLSHandshake)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+// badLineHandler fails to decode the "bad" lines
+type badLineHandler struct {
+	lineHandler
+}
+
+var errBadLine = perrors.New("bad line")
+
+func (h *badLineHandler) Read(ss Session, data []byte) (any, int, error) {
+	pkg, pkgLen, err := h.lineHandler.Read(ss, data)
+	if pkg == "bad" {
+		return nil, pkgLen, errBadLine
+	}
+	return pkg, pkgLen, err
+}
+
+func TestSessionErrors(t *testing.T) {
+	var handler errLineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&badLineHandler{})
+		session.SetEventListener(&handler)
+		session.SetMaxMsgLen(8)
+		return nil
+	})
+	defer server.Close()
+
+	sessions := make(chan Session, 2)
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(2))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	var decodeErr *DecodeError
+	ss := <-sessions
+	_, _, err := ss.WritePkg("0123456789", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(handler.errors()) == 1 }, 3*time.Second, 10*time.Millisecond)
+	err = handler.errors()[0]
+	assert.ErrorIs(t, err, ErrMsgTooLong)
+	assert.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, 11, decodeErr.PkgLen)
+	assert.Eventually(t, ss.IsClosed, 3*time.Second, 10*time.Millisecond)
+	_, _, err = ss.WritePkg("hello", 0)
+	assert.ErrorIs(t, err, ErrSessionClosed)
+
+	ss = <-sessions
+	_, _, err = ss.WritePkg("bad", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(handler.errors()) == 2 }, 3*time.Second, 10*time.Millisecond)
+	err = handler.errors()[1]
+	assert.ErrorIs(t, err, errBadLine)
+	assert.NotErrorIs(t, err, ErrMsgTooLong)
+	assert.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, 4, decodeErr.PkgLen)
+}
+
+// udpErrLineHandler records the errors of an udp session besides its lines
+type udpErrLineHandler struct {
+	errLineHandler
+}
+
+func (h *udpErrLineHandler) OnMessage(ss Session, pkg any) {
+	h.errLineHandler.OnMessage(ss, pkg.(UDPContext).Pkg)
+}
+
+func TestUDPDecodeErrors(t *testing.T) {
+	var handler udpErrLineHandler
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&badLineHandler{})
+		session.SetEventListener(&handler)
+		session.SetMaxMsgLen(8)
+		return nil
+	})
+	defer server.Close()
+
+	peer, err := net.Dial("udp", server.pktListeners[0].LocalAddr().String())
+	assert.Nil(t, err)
+	defer func() { _ = peer.Close() }()
+
+	for _, d := range []string{"bad\n", "0123456789\n", "hello\n"} {
+		_, err = peer.Write([]byte(d))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the session keeps reading after passing the errors to the listener
+	var decodeErr *DecodeError
+	errs := handler.errors()
+	assert.Equal(t, 2, len(errs))
+	assert.ErrorIs(t, errs[0], errBadLine)
+	assert.ErrorIs(t, errs[1], ErrMsgTooLong)
+	assert.True(t, errors.As(errs[1], &decodeErr))
+	assert.Equal(t, 11, decodeErr.PkgLen)
+
+	// the errors are rate limited like the logs
+	for i := 0; i < 3*decodeErrLogBurst; i++ {
+		_, err = peer.Write([]byte("bad\n"))
+		assert.Nil(t, err)
+	}
+	_, err = peer.Write([]byte("world\n"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.True(t, len(handler.errors()) < 2+3*decodeErrLogBurst)
+}
@@ -0,0 +1,542 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	uatomic "go.uber.org/atomic"
+)
+
+// ErrFaultReset is returned by the io of a connection reset by a FaultInjector
+var ErrFaultReset = perrors.New("connection reset by fault injection")
+
+// faultReorderTimeout is how long a reordered datagram waits for the next datagram to overtake it
+const faultReorderTimeout = 10 * time.Millisecond
+
+// FaultDelay draws a delay from a distribution by @rnd
+type FaultDelay func(rnd *rand.Rand) time.Duration
+
+// FixedDelay delays by @d.
+func FixedDelay(d time.Duration) FaultDelay {
+	if d < 0 {
+		panic(fmt.Sprintf("illegal fault delay %s", d))
+	}
+
+	return func(_ *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformDelay delays by a duration distributed uniformly in [@min, @max].
+func UniformDelay(min, max time.Duration) FaultDelay {
+	if min < 0 || max < min {
+		panic(fmt.Sprintf("illegal uniform fault delay [%s, %s]", min, max))
+	}
+
+	return func(rnd *rand.Rand) time.Duration {
+		return min + time.Duration(rnd.Int63n(int64(max-min)+1))
+	}
+}
+
+// NormalDelay delays by a normally distributed duration, a negative one is truncated to 0.
+func NormalDelay(mean, stddev time.Duration) FaultDelay {
+	if mean < 0 || stddev < 0 {
+		panic(fmt.Sprintf("illegal normal fault delay mean %s stddev %s", mean, stddev))
+	}
+
+	return func(rnd *rand.Rand) time.Duration {
+		return time.Duration(math.Max(0, rnd.NormFloat64()*float64(stddev)+float64(mean)))
+	}
+}
+
+// ExponentialDelay delays by an exponentially distributed duration, which models the long tail latency.
+func ExponentialDelay(mean time.Duration) FaultDelay {
+	if mean < 0 {
+		panic(fmt.Sprintf("illegal exponential fault delay mean %s", mean))
+	}
+
+	return func(rnd *rand.Rand) time.Duration {
+		return time.Duration(rnd.ExpFloat64() * float64(mean))
+	}
+}
+
+// FaultConfig is the faults injected into the connections wrapped by a FaultInjector. The rates are
+// probabilities in [0, 1], a zero field injects no fault.
+type FaultConfig struct {
+	// Delay delays every write of a stream connection and every sent datagram. The datagrams are delayed
+	// independently, so a delay distribution reorders them.
+	Delay FaultDelay
+	// Bandwidth caps the bytes per second read and written by every connection in each direction
+	Bandwidth int
+	// PartialWriteRate is the probability that a write of a stream connection only writes a random part of
+	// its buffer and fails with io.ErrShortWrite
+	PartialWriteRate float64
+	// BitFlipRate is the probability that a random bit of a write of a stream connection or a sent datagram
+	// is flipped
+	BitFlipRate float64
+	// ResetAfterBytes resets every stream connection after it has read and written a random number of bytes
+	// in [1, ResetAfterBytes]
+	ResetAfterBytes int
+	// DropRate is the probability that a sent datagram is dropped
+	DropRate float64
+	// DuplicateRate is the probability that a sent datagram is sent twice
+	DuplicateRate float64
+	// ReorderRate is the probability that a sent datagram is held until the next one is sent
+	ReorderRate float64
+}
+
+func (c FaultConfig) check() {
+	for name, rate := range map[string]float64{
+		"partial write": c.PartialWriteRate, "bit flip": c.BitFlipRate,
+		"drop": c.DropRate, "duplicate": c.DuplicateRate, "reorder": c.ReorderRate,
+	} {
+		if rate < 0 || rate > 1 {
+			panic(fmt.Sprintf("illegal %s fault rate %v", name, rate))
+		}
+	}
+	if c.Bandwidth < 0 {
+		panic(fmt.Sprintf("illegal fault bandwidth %d", c.Bandwidth))
+	}
+	if c.ResetAfterBytes < 0 {
+		panic(fmt.Sprintf("illegal fault reset after bytes %d", c.ResetAfterBytes))
+	}
+}
+
+// FaultStats counts the faults injected by a FaultInjector
+type FaultStats struct {
+	Delays        uint64
+	PartialWrites uint64
+	BitFlips      uint64
+	Resets        uint64
+	Drops         uint64
+	Duplicates    uint64
+	Reorders      uint64
+}
+
+// FaultInjector injects faults into the connections accepted by a server with WithServerFaultInjector and
+// dialed by a client with WithClientFaultInjector. Its config can be changed at runtime by Set, which takes
+// effect on the next io of the live connections.
+type FaultInjector struct {
+	lock   sync.Mutex
+	config FaultConfig
+	rnd    *rand.Rand
+	conns  map[*faultConn]struct{}
+
+	delays        uatomic.Uint64
+	partialWrites uatomic.Uint64
+	bitFlips      uatomic.Uint64
+	resets        uatomic.Uint64
+	drops         uatomic.Uint64
+	duplicates    uatomic.Uint64
+	reorders      uatomic.Uint64
+}
+
+// NewFaultInjector returns a fault injector injecting @config. It panics if @config is illegal.
+func NewFaultInjector(config FaultConfig) *FaultInjector {
+	config.check()
+	return &FaultInjector{
+		config: config,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		conns:  make(map[*faultConn]struct{}),
+	}
+}
+
+// Seed seeds the random source of the injector to reproduce the faults.
+func (fi *FaultInjector) Seed(seed int64) {
+	fi.lock.Lock()
+	fi.rnd.Seed(seed)
+	fi.lock.Unlock()
+}
+
+// Set replaces the config of the injector. It panics if @config is illegal.
+func (fi *FaultInjector) Set(config FaultConfig) {
+	config.check()
+	fi.lock.Lock()
+	fi.config = config
+	fi.lock.Unlock()
+}
+
+// Config returns the config of the injector.
+func (fi *FaultInjector) Config() FaultConfig {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	return fi.config
+}
+
+// ResetAll resets all of the live stream connections wrapped by the injector at once.
+func (fi *FaultInjector) ResetAll() {
+	fi.lock.Lock()
+	conns := make([]*faultConn, 0, len(fi.conns))
+	for conn := range fi.conns {
+		conns = append(conns, conn)
+	}
+	fi.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.reset()
+	}
+}
+
+// Stats returns the numbers of the injected faults.
+func (fi *FaultInjector) Stats() FaultStats {
+	return FaultStats{
+		Delays:        fi.delays.Load(),
+		PartialWrites: fi.partialWrites.Load(),
+		BitFlips:      fi.bitFlips.Load(),
+		Resets:        fi.resets.Load(),
+		Drops:         fi.drops.Load(),
+		Duplicates:    fi.duplicates.Load(),
+		Reorders:      fi.reorders.Load(),
+	}
+}
+
+// draw calls @fn with the current config and the random source of the injector.
+func (fi *FaultInjector) draw(fn func(config *FaultConfig, rnd *rand.Rand)) {
+	fi.lock.Lock()
+	fn(&fi.config, fi.rnd)
+	fi.lock.Unlock()
+}
+
+// hit reports whether an event of probability @rate happens.
+func hit(rnd *rand.Rand, rate float64) bool {
+	return rate > 0 && rnd.Float64() < rate
+}
+
+// flipBit returns a copy of @p with a random bit flipped.
+func flipBit(rnd *rand.Rand, p []byte) []byte {
+	flipped := append([]byte(nil), p...)
+	bit := rnd.Intn(len(flipped) * 8)
+	flipped[bit/8] ^= 1 << (bit % 8)
+	return flipped
+}
+
+// WrapConn wraps the stream connection @conn to inject the faults.
+func (fi *FaultInjector) WrapConn(conn net.Conn) net.Conn {
+	fc := &faultConn{Conn: conn, fi: fi, budget: -1}
+	fi.lock.Lock()
+	fi.conns[fc] = struct{}{}
+	fi.lock.Unlock()
+
+	return fc
+}
+
+// WrapListener wraps the connections accepted by @listener to inject the faults.
+func (fi *FaultInjector) WrapListener(listener net.Listener) net.Listener {
+	return &faultListener{Listener: listener, fi: fi}
+}
+
+// wrapUDPConn wraps the udp connection @conn to inject the faults into the sent datagrams.
+func (fi *FaultInjector) wrapUDPConn(conn *net.UDPConn) udpConn {
+	return &faultUDPConn{UDPConn: conn, fi: fi}
+}
+
+type faultListener struct {
+	net.Listener
+	fi *FaultInjector
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.fi.WrapConn(conn), nil
+}
+
+// faultBandwidth caps the bytes per second of a direction of a connection
+type faultBandwidth struct {
+	lock      sync.Mutex
+	bandwidth int
+	bucket    *tokenBucket
+}
+
+// wait blocks until @n bytes are allowed by @bandwidth.
+func (b *faultBandwidth) wait(n, bandwidth int) {
+	if bandwidth <= 0 || n <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	if bandwidth != b.bandwidth {
+		b.bandwidth = bandwidth
+		b.bucket = newTokenBucket(bandwidth)
+	}
+	delay := b.bucket.reserve(n, time.Now())
+	b.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// faultConn is a stream connection injected with the faults of its FaultInjector
+type faultConn struct {
+	net.Conn
+	fi *FaultInjector
+
+	lock sync.Mutex
+	// the bytes left before the connection is reset, -1 means that it is not drawn yet
+	budget  int
+	closed  bool
+	isReset bool
+	rLimit  faultBandwidth
+	wLimit  faultBandwidth
+}
+
+// consume counts @n bytes of io and reports whether the connection should be reset.
+func (c *faultConn) consume(n int, resetAfter int, rnd *rand.Rand) bool {
+	if resetAfter <= 0 || n <= 0 {
+		return false
+	}
+	if c.budget < 0 {
+		c.budget = 1 + rnd.Intn(resetAfter)
+	}
+	c.budget -= n
+
+	return c.budget <= 0
+}
+
+// reset closes the connection abruptly, a tcp connection sends RST.
+func (c *faultConn) reset() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed, c.isReset = true, true
+	c.lock.Unlock()
+
+	c.fi.resets.Inc()
+	c.fi.lock.Lock()
+	delete(c.fi.conns, c)
+	c.fi.lock.Unlock()
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = c.Conn.Close()
+}
+
+func (c *faultConn) wasReset() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.isReset
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.wasReset() {
+		return 0, perrors.WithStack(ErrFaultReset)
+	}
+
+	var (
+		bandwidth int
+		reset     bool
+	)
+	c.lock.Lock()
+	c.fi.draw(func(config *FaultConfig, rnd *rand.Rand) {
+		bandwidth = config.Bandwidth
+		reset = c.consume(n, config.ResetAfterBytes, rnd)
+	})
+	c.lock.Unlock()
+	c.rLimit.wait(n, bandwidth)
+	if reset {
+		c.reset()
+	}
+
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.wasReset() {
+		return 0, perrors.WithStack(ErrFaultReset)
+	}
+
+	var (
+		delay     time.Duration
+		bandwidth int
+		partial   = -1
+		flipped   []byte
+		reset     bool
+	)
+	c.lock.Lock()
+	c.fi.draw(func(config *FaultConfig, rnd *rand.Rand) {
+		if config.Delay != nil {
+			delay = config.Delay(rnd)
+		}
+		bandwidth = config.Bandwidth
+		if len(p) != 0 && hit(rnd, config.PartialWriteRate) {
+			partial = rnd.Intn(len(p))
+		}
+		if len(p) != 0 && hit(rnd, config.BitFlipRate) {
+			flipped = flipBit(rnd, p)
+		}
+		reset = c.consume(len(p), config.ResetAfterBytes, rnd)
+	})
+	c.lock.Unlock()
+
+	if delay > 0 {
+		c.fi.delays.Inc()
+		time.Sleep(delay)
+	}
+	c.wLimit.wait(len(p), bandwidth)
+	buf := p
+	if flipped != nil {
+		c.fi.bitFlips.Inc()
+		buf = flipped
+	}
+	if reset {
+		c.reset()
+		return 0, perrors.WithStack(ErrFaultReset)
+	}
+	if partial >= 0 {
+		c.fi.partialWrites.Inc()
+		n, err := c.Conn.Write(buf[:partial])
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+
+	return c.Conn.Write(buf)
+}
+
+func (c *faultConn) Close() error {
+	c.lock.Lock()
+	closed := c.closed
+	c.closed = true
+	c.lock.Unlock()
+	if closed {
+		return nil
+	}
+
+	c.fi.lock.Lock()
+	delete(c.fi.conns, c)
+	c.fi.lock.Unlock()
+	return c.Conn.Close()
+}
+
+// faultUDPConn is an udp connection whose sent datagrams are injected with the faults of its FaultInjector
+type faultUDPConn struct {
+	*net.UDPConn
+	fi *FaultInjector
+
+	lock sync.Mutex
+	// the datagram held by the reorder fault
+	held  func()
+	timer *time.Timer
+}
+
+func (c *faultUDPConn) Write(p []byte) (int, error) {
+	n, _, err := c.WriteMsgUDP(p, nil, nil)
+	return n, err
+}
+
+func (c *faultUDPConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (int, int, error) {
+	var (
+		drop, duplicate, reorder bool
+		delay                    time.Duration
+		buf                      = b
+	)
+	c.fi.draw(func(config *FaultConfig, rnd *rand.Rand) {
+		drop = hit(rnd, config.DropRate)
+		duplicate = hit(rnd, config.DuplicateRate)
+		reorder = hit(rnd, config.ReorderRate)
+		if config.Delay != nil {
+			delay = config.Delay(rnd)
+		}
+		if len(b) != 0 && hit(rnd, config.BitFlipRate) {
+			c.fi.bitFlips.Inc()
+			buf = flipBit(rnd, b)
+		}
+	})
+	if drop {
+		c.fi.drops.Inc()
+		return len(b), len(oob), nil
+	}
+	if !duplicate && !reorder && delay <= 0 {
+		n, oobn, err := c.UDPConn.WriteMsgUDP(buf, oob, addr)
+		c.flushHeld()
+		return n, oobn, err
+	}
+
+	buf = append([]byte(nil), buf...)
+	oob = append([]byte(nil), oob...)
+	send := func() {
+		_, _, _ = c.UDPConn.WriteMsgUDP(buf, oob, addr)
+		if duplicate {
+			_, _, _ = c.UDPConn.WriteMsgUDP(buf, oob, addr)
+		}
+	}
+	if duplicate {
+		c.fi.duplicates.Inc()
+	}
+	switch {
+	case delay > 0:
+		c.fi.delays.Inc()
+		time.AfterFunc(delay, func() {
+			c.send(send, reorder)
+		})
+	default:
+		c.send(send, reorder)
+	}
+
+	return len(b), len(oob), nil
+}
+
+// send sends a datagram by @send, or holds it until the next datagram or faultReorderTimeout if @reorder.
+func (c *faultUDPConn) send(send func(), reorder bool) {
+	if reorder {
+		c.lock.Lock()
+		if c.held == nil {
+			c.fi.reorders.Inc()
+			c.held = send
+			c.timer = time.AfterFunc(faultReorderTimeout, c.flushHeld)
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Unlock()
+	}
+	send()
+	c.flushHeld()
+}
+
+// flushHeld sends the datagram held by the reorder fault.
+func (c *faultUDPConn) flushHeld() {
+	c.lock.Lock()
+	held := c.held
+	c.held = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.lock.Unlock()
+	if held != nil {
+		held()
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultDelay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	assert.Equal(t, time.Second, FixedDelay(time.Second)(rnd))
+	uniform := UniformDelay(time.Millisecond, 2*time.Millisecond)
+	normal := NormalDelay(time.Millisecond, 10*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		d := uniform(rnd)
+		assert.True(t, d >= time.Millisecond && d <= 2*time.Millisecond, d)
+		assert.True(t, normal(rnd) >= 0)
+		assert.True(t, ExponentialDelay(time.Millisecond)(rnd) >= 0)
+	}
+
+	assert.Panics(t, func() { FixedDelay(-1) })
+	assert.Panics(t, func() { UniformDelay(2, 1) })
+	assert.Panics(t, func() { NormalDelay(1, -1) })
+	assert.Panics(t, func() { ExponentialDelay(-1) })
+	assert.Panics(t, func() { NewFaultInjector(FaultConfig{DropRate: 1.5}) })
+	assert.Panics(t, func() { NewFaultInjector(FaultConfig{}).Set(FaultConfig{Bandwidth: -1}) })
+}
+
+func TestFaultConn(t *testing.T) {
+	fi := NewFaultInjector(FaultConfig{BitFlipRate: 1})
+	local, remote := net.Pipe()
+	conn := fi.WrapConn(local)
+	defer conn.Close()
+	defer remote.Close()
+
+	data := []byte("hello")
+	go func() {
+		_, _ = conn.Write(data)
+	}()
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(remote, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+	diff := 0
+	for i := range buf {
+		for x := buf[i] ^ data[i]; x != 0; x &= x - 1 {
+			diff++
+		}
+	}
+	assert.Equal(t, 1, diff)
+
+	fi.Set(FaultConfig{PartialWriteRate: 1, Delay: FixedDelay(20 * time.Millisecond)})
+	go func() {
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+	start := time.Now()
+	n, err := conn.Write(data)
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.True(t, n < len(data))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	fi.Set(FaultConfig{ResetAfterBytes: 1})
+	_, err = conn.Write(data)
+	assert.ErrorIs(t, err, ErrFaultReset)
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, ErrFaultReset)
+	assert.Equal(t, FaultStats{Delays: 1, PartialWrites: 1, BitFlips: 1, Resets: 1}, fi.Stats())
+}
+
+func TestFaultBandwidth(t *testing.T) {
+	fi := NewFaultInjector(FaultConfig{Bandwidth: 1000})
+	local, remote := net.Pipe()
+	conn := fi.WrapConn(local)
+	defer conn.Close()
+	defer remote.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+
+	// the first second of the bandwidth is a burst
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write(make([]byte, 500))
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+}
+
+func TestFaultResetReconnect(t *testing.T) {
+	var serverHandler lineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		return nil
+	})
+	defer server.Close()
+
+	fi := NewFaultInjector(FaultConfig{})
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1),
+		WithClientFaultInjector(fi))
+	sessions := make(chan Session, 2)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	first := <-sessions
+	fi.ResetAll()
+	assert.Eventually(t, first.IsClosed, 3*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, closed := serverHandler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), fi.Stats().Resets)
+
+	// the client reconnects through the injector
+	second := <-sessions
+	_, _, err := second.WritePkg("hello", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 1 && got[0] == "hello"
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestFaultUDPConn(t *testing.T) {
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer receiver.Close()
+	sender, err := net.DialUDP("udp", nil, receiver.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer sender.Close()
+
+	fi := NewFaultInjector(FaultConfig{DropRate: 1})
+	conn := fi.wrapUDPConn(sender)
+	receive := func() []string {
+		var got []string
+		buf := make([]byte, 64)
+		for {
+			_ = receiver.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := receiver.ReadFromUDP(buf)
+			if err != nil {
+				return got
+			}
+			got = append(got, string(buf[:n]))
+		}
+	}
+
+	_, err = conn.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Empty(t, receive())
+
+	fi.Set(FaultConfig{DuplicateRate: 1})
+	_, err = conn.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "a"}, receive())
+
+	// the held datagram is sent after the next one or after faultReorderTimeout
+	fi.Set(FaultConfig{ReorderRate: 1})
+	_, err = conn.Write([]byte("a"))
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "a"}, receive())
+	_, err = conn.Write([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, receive())
+
+	assert.Equal(t, FaultStats{Drops: 1, Duplicates: 1, Reorders: 2}, fi.Stats())
+}
@@ -52,12 +52,31 @@ type Reader interface {
 	Read(Session, []byte) (any, int, error)
 }
 
+// ZeroCopyReader is an optional interface of Reader. If the Reader of a session implements it, getty reads
+// the stream into pooled buffers and calls ReadZeroCopy instead of Read, so a pkg can reference @data
+// without copying it.
+//
+// @data is a part of @buf. The return values are the same as Reader.Read. getty holds a reference of @buf
+// for every returned pkg until EventListener.OnMessage of the pkg returns. If a pkg is used after OnMessage
+// returns, ReadZeroCopy should keep @buf in the pkg and call buf.Retain(), and the pkg owner should call
+// buf.Release() when the pkg is no longer used.
+type ZeroCopyReader interface {
+	ReadZeroCopy(ss Session, data []byte, buf *ReadBuffer) (any, int, error)
+}
+
 // Writer is used to marshal pkg and write to session
 type Writer interface {
 	// Write if @Session is udpGettySession, the second parameter is UDPContext.
 	Write(Session, any) ([]byte, error)
 }
 
+// BuffersWriter is an optional interface of Writer. If the Writer of a session implements it, WritePkg
+// calls WriteBuffers instead of Write, and a tcp session sends the returned buffers of a pkg, e.g. its
+// header and body, by vectored I/O rather than concatenating them.
+type BuffersWriter interface {
+	WriteBuffers(Session, any) ([][]byte, error)
+}
+
 // ReadWriter interface use for handle application packages
 type ReadWriter interface {
 	Reader
@@ -73,10 +92,15 @@ type EventListener interface {
 	// OnClose invoked when session closed.
 	OnClose(Session)
 
-	// OnError invoked when got error.
+	// OnError invoked when got error. The error can be checked by errors.Is and errors.As, e.g. a *DecodeError
+	// for a package that can not be decoded or matches ErrMsgTooLong, ErrPeerReset for a connection reset by
+	// the peer, ErrHeartbeatTimeout and ErrRateLimited. A tcp session is closed after a DecodeError, while an
+	// udp/websocket session drops the message and keeps reading, passing a limited number of them per second.
 	OnError(Session, error)
 
-	// OnCron invoked periodically, its period can be set by (Session)SetCronPeriod
+	// OnCron invoked periodically, its period can be set by (Session)SetCronPeriod. It runs in the task pool
+	// or in its own goroutine, which is skipped while the last one is running, unless the endpoint has a
+	// Scheduler.
 	OnCron(Session)
 
 	// OnMessage invoked when getty received a package. Pls attention that do not handle long time
@@ -0,0 +1,310 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	perrors "github.com/pkg/errors"
+)
+
+const (
+	// InheritedFdsEnv is the environment variable that tells a child process started by
+	// StartChildProcess how many listening sockets it has inherited.
+	InheritedFdsEnv = "GETTY_INHERITED_FDS"
+	// systemd socket activation, see sd_listen_fds(3)
+	systemdListenFdsEnv     = "LISTEN_FDS"
+	systemdListenPidEnv     = "LISTEN_PID"
+	systemdListenFdNamesEnv = "LISTEN_FDNAMES"
+	// the first inherited fd, 0/1/2 are stdin/stdout/stderr
+	listenFdsStart = 3
+
+	drainCheckInterval = 100e6 // 100ms
+)
+
+// fileListener converts an inherited @fd to a stream listener, @fd is closed.
+func fileListener(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "getty-listener-"+strconv.Itoa(int(fd)))
+	if f == nil {
+		return nil, perrors.Errorf("illegal listener fd %d", fd)
+	}
+	// net.FileListener dups @fd, so @f should be closed
+	defer func() { _ = f.Close() }()
+
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "net.FileListener(fd:%d)", fd)
+	}
+
+	return listener, nil
+}
+
+// filePacketConn converts an inherited @fd to an udp socket, @fd is closed.
+func filePacketConn(fd uintptr) (*net.UDPConn, error) {
+	f := os.NewFile(fd, "getty-packet-conn-"+strconv.Itoa(int(fd)))
+	if f == nil {
+		return nil, perrors.Errorf("illegal packet conn fd %d", fd)
+	}
+	defer func() { _ = f.Close() }()
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "net.FilePacketConn(fd:%d)", fd)
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, perrors.Errorf("fd %d is not an udp socket but %T", fd, conn)
+	}
+
+	return udpConn, nil
+}
+
+// listenTCPInherited serves on the listener or the inherited fds supplied by options.
+func (s *server) listenTCPInherited() error {
+	var listeners []net.Listener
+
+	if s.listener != nil {
+		listeners = append(listeners, s.listener)
+	}
+	for _, fd := range s.listenerFds {
+		listener, err := fileListener(fd)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return perrors.WithStack(err)
+		}
+		listeners = append(listeners, listener)
+	}
+	if err := s.buildTlsConfig(); err != nil {
+		return perrors.WithStack(err)
+	}
+
+	s.streamListener = listeners[0]
+	s.streamListeners = listeners
+	s.addr = s.streamListener.Addr().String()
+
+	return nil
+}
+
+// listenUDPInherited serves on the udp socket or the inherited fds supplied by options.
+func (s *server) listenUDPInherited() error {
+	var pktListeners []net.PacketConn
+
+	if s.pktConn != nil {
+		if _, ok := s.pktConn.(*net.UDPConn); !ok {
+			return perrors.Errorf("the packet conn should be *net.UDPConn but %T", s.pktConn)
+		}
+		pktListeners = append(pktListeners, s.pktConn)
+	}
+	for _, fd := range s.listenerFds {
+		conn, err := filePacketConn(fd)
+		if err != nil {
+			for _, l := range pktListeners {
+				_ = l.Close()
+			}
+			return perrors.WithStack(err)
+		}
+		pktListeners = append(pktListeners, conn)
+	}
+
+	s.pktListener = pktListeners[0]
+	s.pktListeners = pktListeners
+	s.addr = s.pktListener.LocalAddr().String()
+
+	return nil
+}
+
+// addSession tracks @ss until it is closed.
+func (s *server) addSession(ss Session) {
+	s.lock.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[Session]struct{})
+	}
+	s.sessions[ss] = struct{}{}
+	s.lock.Unlock()
+
+	ss.AddCloseCallback(s, ss, func() {
+		s.lock.Lock()
+		delete(s.sessions, ss)
+		s.lock.Unlock()
+	})
+}
+
+func (s *server) sessionNum() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.sessions)
+}
+
+// Drain stops accepting new connections and waits at most @timeout for the alive sessions to
+// be closed. The sessions that are still alive after @timeout are closed by force.
+func (s *server) Drain(timeout time.Duration) {
+	s.stop()
+
+	deadline := time.Now().Add(timeout)
+	for s.sessionNum() > 0 && time.Now().Before(deadline) {
+		<-gxtime.After(drainCheckInterval)
+	}
+
+	s.lock.Lock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	s.lock.Unlock()
+	if len(sessions) > 0 {
+		s.logger.Warnw("drain timeout, close sessions by force", "addr", s.addr, "timeout", timeout, "sessions", len(sessions))
+	}
+	for _, ss := range sessions {
+		ss.Close()
+	}
+
+	s.wg.Wait()
+}
+
+// listenerFiles dups the listening sockets of the server.
+func (s *server) listenerFiles() ([]*os.File, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	var sockets []any
+	for _, l := range s.streamListeners {
+		sockets = append(sockets, l)
+	}
+	for _, l := range s.pktListeners {
+		sockets = append(sockets, l)
+	}
+
+	files := make([]*os.File, 0, len(sockets))
+	for _, socket := range sockets {
+		f, ok := socket.(filer)
+		if !ok {
+			closeFiles(files)
+			return nil, perrors.Errorf("server{%s} socket %T can not be inherited", s.addr, socket)
+		}
+		file, err := f.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, perrors.WithStack(err)
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// StartChildProcess re-executes the running binary with the same arguments and hands the listening
+// sockets of @servers to it, which is the first step of a zero-downtime restart. The child process
+// gets the sockets as fds 3, 4, ... in the order of @servers(SO_REUSEPORT sockets of a server are
+// consecutive), gets them by InheritedFds and serves them by WithServerListenerFd. After the child
+// is ready, the parent should Drain its servers and exit.
+func StartChildProcess(servers ...Server) (*os.Process, error) {
+	var files []*os.File
+	defer func() { closeFiles(files) }()
+
+	for _, srv := range servers {
+		s, ok := srv.(*server)
+		if !ok {
+			return nil, perrors.Errorf("illegal server type %T", srv)
+		}
+		serverFiles, err := s.listenerFiles()
+		if err != nil {
+			return nil, perrors.WithStack(err)
+		}
+		files = append(files, serverFiles...)
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, perrors.WithStack(err)
+	}
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, InheritedFdsEnv+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env, fmt.Sprintf("%s=%d", InheritedFdsEnv, len(files)))
+
+	process, err := os.StartProcess(path, os.Args, &os.ProcAttr{
+		Dir:   wd,
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	})
+	if err != nil {
+		return nil, perrors.Wrapf(err, "os.StartProcess(%s)", path)
+	}
+
+	return process, nil
+}
+
+// InheritedFds returns the listening socket fds inherited from the parent process(see StartChildProcess)
+// or from systemd socket activation. It returns nil if there is no inherited fd. The caller owns the fds and
+// should hand them over to WithServerListenerFd or close them. Like sd_listen_fds(3), it unsets the
+// environment variables telling the inherited fds, so the processes started later do not take the fds of
+// this process as theirs, and it returns nil if it is called again.
+func InheritedFds() []uintptr {
+	defer func() {
+		for _, env := range []string{InheritedFdsEnv, systemdListenFdsEnv, systemdListenPidEnv, systemdListenFdNamesEnv} {
+			_ = os.Unsetenv(env)
+		}
+	}()
+
+	num, err := strconv.Atoi(os.Getenv(InheritedFdsEnv))
+	if err != nil || num <= 0 {
+		// systemd sets LISTEN_PID to the pid of the activated process
+		if pid, pidErr := strconv.Atoi(os.Getenv(systemdListenPidEnv)); pidErr != nil || pid != os.Getpid() {
+			return nil
+		}
+		if num, err = strconv.Atoi(os.Getenv(systemdListenFdsEnv)); err != nil || num <= 0 {
+			return nil
+		}
+	}
+
+	fds := make([]uintptr, 0, num)
+	for i := 0; i < num; i++ {
+		fds = append(fds, uintptr(listenFdsStart+i))
+	}
+
+	return fds
+}
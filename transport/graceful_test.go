@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerWithListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	var serverMsgHandler MessageHandler
+	server := newServer(TCP_SERVER, WithServerListener(listener))
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+	assert.Equal(t, listener, server.Listener())
+	assert.Equal(t, listener.Addr().String(), server.addr)
+
+	conn, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	assert.Eventually(t, func() bool { return server.sessionNum() == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	// the session is closed by the peer during draining
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = conn.Close()
+	}()
+	start := time.Now()
+	server.Drain(5 * time.Second)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 0, server.sessionNum())
+	assert.True(t, server.IsClosed())
+}
+
+func TestServerDrainTimeout(t *testing.T) {
+	var serverMsgHandler MessageHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+
+	conn, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	assert.Eventually(t, func() bool { return server.sessionNum() == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	server.Drain(200 * time.Millisecond)
+	assert.True(t, serverMsgHandler.array[0].IsClosed())
+}
+
+func TestInheritedFds(t *testing.T) {
+	t.Setenv(InheritedFdsEnv, "")
+	t.Setenv(systemdListenFdsEnv, "2")
+	t.Setenv(systemdListenPidEnv, "1")
+	assert.Nil(t, InheritedFds())
+
+	t.Setenv(systemdListenFdsEnv, "2")
+	t.Setenv(systemdListenPidEnv, strconv.Itoa(os.Getpid()))
+	t.Setenv(systemdListenFdNamesEnv, "http:https")
+	assert.Equal(t, []uintptr{3, 4}, InheritedFds())
+	// the environment variables are unset, so the fds are returned only once
+	for _, env := range []string{systemdListenFdsEnv, systemdListenPidEnv, systemdListenFdNamesEnv} {
+		_, ok := os.LookupEnv(env)
+		assert.False(t, ok, env)
+	}
+	assert.Nil(t, InheritedFds())
+
+	t.Setenv(InheritedFdsEnv, "1")
+	assert.Equal(t, []uintptr{3}, InheritedFds())
+	_, ok := os.LookupEnv(InheritedFdsEnv)
+	assert.False(t, ok)
+}
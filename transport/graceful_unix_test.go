@@ -0,0 +1,72 @@
+//go:build unix
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// handOverFd dups the fd of @f for a server to take, the test still owns @f and closes it.
+func handOverFd(t *testing.T, f *os.File) uintptr {
+	t.Cleanup(func() { _ = f.Close() })
+	fd, err := syscall.Dup(int(f.Fd()))
+	assert.Nil(t, err)
+	return uintptr(fd)
+}
+
+func TestServerWithListenerFd(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() { _ = listener.Close() }()
+	pktConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(t, err)
+	defer func() { _ = pktConn.Close() }()
+
+	tcpServer := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	tcpServer.streamListeners = []net.Listener{listener}
+	udpServer := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"))
+	udpServer.pktListeners = []net.PacketConn{pktConn}
+
+	tcpFiles, err := tcpServer.listenerFiles()
+	assert.Nil(t, err)
+	udpFiles, err := udpServer.listenerFiles()
+	assert.Nil(t, err)
+
+	var serverMsgHandler MessageHandler
+	newServerSession := func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	}
+	inheritedTCPServer := newServer(TCP_SERVER, WithServerListenerFd(handOverFd(t, tcpFiles[0])))
+	inheritedTCPServer.RunEventLoop(newServerSession)
+	defer inheritedTCPServer.Close()
+	assert.Equal(t, listener.Addr().String(), inheritedTCPServer.addr)
+
+	inheritedUDPServer := newServer(UDP_ENDPOINT, WithServerListenerFd(handOverFd(t, udpFiles[0])))
+	inheritedUDPServer.RunEventLoop(newServerSession)
+	defer inheritedUDPServer.Close()
+	assert.Equal(t, pktConn.LocalAddr().String(), inheritedUDPServer.addr)
+}
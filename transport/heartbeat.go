@@ -0,0 +1,274 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	perrors "github.com/pkg/errors"
+)
+
+// ErrHeartbeatTimeout is the error of a session closed because its peer does not answer the heartbeat pings
+var ErrHeartbeatTimeout = perrors.New("heartbeat timeout")
+
+// HeartbeatFactory makes and recognizes the ping/pong packages of the transport heartbeat. The packages are
+// encoded by the Writer of the session and decoded by its Reader like the other packages, but they are not
+// dispatched to EventListener.OnMessage.
+type HeartbeatFactory interface {
+	// NewPing returns a ping package of @session
+	NewPing(session Session) any
+	// NewPong returns the pong package answering @ping
+	NewPong(session Session, ping any) any
+	// IsPing reports whether @pkg is a ping package
+	IsPing(pkg any) bool
+	// IsPong reports whether @pkg is a pong package
+	IsPong(pkg any) bool
+}
+
+// heartbeatConfig is the heartbeat configuration of a session or an endpoint
+type heartbeatConfig struct {
+	factory   HeartbeatFactory
+	interval  time.Duration
+	maxMissed int
+}
+
+func newHeartbeatConfig(factory HeartbeatFactory, interval time.Duration, maxMissed int) *heartbeatConfig {
+	if factory == nil {
+		return nil
+	}
+	if maxMissed <= 0 {
+		panic(fmt.Sprintf("illegal heartbeat max missed pong number %d", maxMissed))
+	}
+
+	return &heartbeatConfig{factory: factory, interval: interval, maxMissed: maxMissed}
+}
+
+// heartbeatState is the heartbeat state of a session
+type heartbeatState struct {
+	*heartbeatConfig
+
+	lock     sync.Mutex
+	pingTime time.Time // the time of the ping waiting for its pong, zero if there is none
+	missed   int
+	rtt      time.Duration
+}
+
+// sendsPing reports whether the session pings its peer by @hb. A udp endpoint has no fixed peer, it only
+// answers the pings.
+func (s *session) sendsPing(hb *heartbeatState) bool {
+	return hb != nil && hb.interval > 0 && s.endPoint.EndPointType() != UDP_ENDPOINT
+}
+
+// startHeartbeat sets up the heartbeat of the session by its own configuration or the configuration of
+// its endpoint.
+func (s *session) startHeartbeat() {
+	s.lock.Lock()
+	if s.heartbeat == nil {
+		var config *heartbeatConfig
+		switch endPoint := s.endPoint.(type) {
+		case *server:
+			config = endPoint.heartbeat
+		case *client:
+			config = endPoint.heartbeat
+		}
+		if config != nil {
+			s.heartbeat = &heartbeatState{heartbeatConfig: config}
+		}
+	}
+	s.heartbeatStarted = true
+	s.lock.Unlock()
+
+	if err := s.schedulePing(); err != nil {
+		panic(fmt.Sprintf("failed to add the heartbeat of session %s to the timer wheel err:%v", s.Stat(), err))
+	}
+}
+
+// schedulePing replaces the ping timer of the session by a timer of its current heartbeat. No timer is
+// added if the session does not ping its peer or has stopped.
+func (s *session) schedulePing() error {
+	var err error
+	wheel := s.timerWheel()
+	s.lock.Lock()
+	stop := s.stopPing
+	s.stopPing = nil
+	if s.sendsPing(s.heartbeat) && !s.IsClosed() {
+		s.stopPing, err = wheel.addTimer(pingPeer, true, s.heartbeat.interval, s)
+	}
+	s.lock.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	return err
+}
+
+func pingPeer(_ gxtime.TimerID, _ time.Time, arg any) error {
+	ss, _ := arg.(*session)
+	if ss == nil || ss.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	ss.lock.RLock()
+	hb := ss.heartbeat
+	_, udp := ss.Connection.(*gettyUDPConn)
+	ss.lock.RUnlock()
+	// the heartbeat has been disabled by SetHeartbeat
+	if hb == nil {
+		return ErrSessionClosed
+	}
+	hb.lock.Lock()
+	if !hb.pingTime.IsZero() {
+		hb.missed++
+	}
+	missed := hb.missed
+	hb.pingTime = time.Now()
+	hb.lock.Unlock()
+
+	if missed >= hb.maxMissed {
+		err := perrors.Wrapf(ErrHeartbeatTimeout, "%d pongs missed", missed)
+		ss.logger.Warnw("the peer is dead", "error", err)
+		// closing a client session reconnects, which waits on the timer wheel
+		go func() {
+			ss.listener.OnError(ss, err)
+			ss.Close()
+		}()
+		return ErrSessionClosed
+	}
+
+	f := func() {
+		var ping any = hb.factory.NewPing(ss)
+		if udp {
+			ping = UDPContext{Pkg: ping}
+		}
+		if _, _, err := ss.WritePkg(ping, 0); err != nil {
+			ss.logger.Warnw("failed to write heartbeat ping", "error", err)
+		}
+	}
+
+	// if enable task pool, run @f asynchronously.
+	if taskPool := ss.EndPoint().GetTaskPool(); taskPool != nil {
+		taskPool.AddTaskAlways(f)
+		return nil
+	}
+	// the write may wait for the write rate limit or the write timeout, which should not block the timer
+	// wheel shared by all of its sessions, so @f runs in its own goroutine like the cron, and is skipped if
+	// the last ping is still being written.
+	if _, ok := ss.timerWheel().(*Scheduler); ok {
+		f()
+		return nil
+	}
+	if !ss.pingRunning.CAS(false, true) {
+		ss.logger.Warnw("[session.pingPeer] skip the ping, the last one is still being written")
+		return nil
+	}
+	go func() {
+		defer ss.pingRunning.Store(false)
+		f()
+	}()
+	return nil
+}
+
+// handleHeartbeat answers a ping package or takes a pong package. It returns false if @pkg is not a
+// heartbeat package and should be dispatched to the listener.
+func (s *session) handleHeartbeat(pkg any) bool {
+	s.lock.RLock()
+	hb := s.heartbeat
+	s.lock.RUnlock()
+	if hb == nil {
+		return false
+	}
+
+	var peer *UDPContext
+	if ctx, ok := pkg.(UDPContext); ok {
+		peer, pkg = &ctx, ctx.Pkg
+	}
+	switch {
+	case hb.factory.IsPing(pkg):
+		var pong any = hb.factory.NewPong(s, pkg)
+		if peer != nil {
+			pong = UDPContext{Pkg: pong, PeerAddr: peer.PeerAddr}
+		}
+		// the write may wait for the write rate limit, which should not block reading
+		f := func() {
+			if _, _, err := s.WritePkg(pong, 0); err != nil {
+				s.logger.Warnw("failed to write heartbeat pong", "error", err)
+			}
+		}
+		if taskPool := s.EndPoint().GetTaskPool(); taskPool != nil {
+			taskPool.AddTaskAlways(f)
+		} else {
+			go f()
+		}
+	case hb.factory.IsPong(pkg):
+		hb.lock.Lock()
+		if !hb.pingTime.IsZero() {
+			hb.rtt = time.Since(hb.pingTime)
+			hb.pingTime = time.Time{}
+		}
+		hb.missed = 0
+		hb.lock.Unlock()
+	default:
+		return false
+	}
+
+	return true
+}
+
+// SetHeartbeat enables the transport heartbeat of the session, which takes precedence over the heartbeat of
+// the endpoint. The session writes a ping made by @factory every @interval and is closed with
+// ErrHeartbeatTimeout after @maxMissed pings are not answered. A non-positive @interval means the session
+// only answers the pings of its peer, and a nil @factory disables the heartbeat. If it is called after the
+// session is opened, the ping timer of the session is replaced.
+func (s *session) SetHeartbeat(factory HeartbeatFactory, interval time.Duration, maxMissed int) {
+	config := newHeartbeatConfig(factory, interval, maxMissed)
+	s.lock.Lock()
+	if config == nil {
+		s.heartbeat = nil
+	} else {
+		s.heartbeat = &heartbeatState{heartbeatConfig: config}
+	}
+	started := s.heartbeatStarted
+	s.lock.Unlock()
+
+	if !started {
+		return
+	}
+	if err := s.schedulePing(); err != nil {
+		s.logger.Errorw("failed to add the heartbeat ping timer to the timer wheel", "error", err)
+	}
+}
+
+// HeartbeatRTT returns the round trip time of the last answered heartbeat ping, zero if there is none.
+func (s *session) HeartbeatRTT() time.Duration {
+	s.lock.RLock()
+	hb := s.heartbeat
+	s.lock.RUnlock()
+	if hb == nil {
+		return 0
+	}
+
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+	return hb.rtt
+}
@@ -0,0 +1,236 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	"github.com/stretchr/testify/assert"
+
+	uatomic "go.uber.org/atomic"
+)
+
+// lineHeartbeat is the HeartbeatFactory of the "ping"/"pong" lines
+type lineHeartbeat struct{}
+
+func (lineHeartbeat) NewPing(Session) any      { return "ping" }
+func (lineHeartbeat) NewPong(Session, any) any { return "pong" }
+func (lineHeartbeat) IsPing(pkg any) bool      { return pkg == "ping" }
+func (lineHeartbeat) IsPong(pkg any) bool      { return pkg == "pong" }
+
+// errLineHandler records the errors of a session besides its lines
+type errLineHandler struct {
+	lineHandler
+	errLock sync.Mutex
+	errs    []error
+}
+
+func (h *errLineHandler) OnError(_ Session, err error) {
+	h.errLock.Lock()
+	h.errs = append(h.errs, err)
+	h.errLock.Unlock()
+}
+
+func (h *errLineHandler) errors() []error {
+	h.errLock.Lock()
+	defer h.errLock.Unlock()
+	return append([]error(nil), h.errs...)
+}
+
+func heartbeatPair(t *testing.T, serverHandler, clientHandler EventListener, serverOpts []ServerOption,
+	clientOpts ...ClientOption) (Server, Client, Session) {
+	server := newServer(TCP_SERVER, append([]ServerOption{WithLocalAddress("127.0.0.1:0")}, serverOpts...)...)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(serverHandler)
+		return nil
+	})
+
+	sessions := make(chan Session, 1)
+	client := newClient(TCP_CLIENT, append([]ClientOption{WithServerAddress(server.addr),
+		WithConnectionNumber(1)}, clientOpts...)...)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(clientHandler)
+		sessions <- session
+		return nil
+	})
+
+	return server, client, <-sessions
+}
+
+func TestHeartbeat(t *testing.T) {
+	var serverHandler, clientHandler errLineHandler
+	server, client, ss := heartbeatPair(t, &serverHandler, &clientHandler,
+		[]ServerOption{WithServerHeartbeat(lineHeartbeat{}, 0, 1)},
+		WithClientHeartbeat(lineHeartbeat{}, 20*time.Millisecond, 3))
+	defer server.Close()
+	defer client.Close()
+
+	assert.Eventually(t, func() bool { return ss.HeartbeatRTT() > 0 }, 3*time.Second, 10*time.Millisecond)
+	_, _, err := ss.WritePkg("hello", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	// the heartbeat packages are not dispatched to the listeners
+	got, _ := serverHandler.snapshot()
+	assert.Equal(t, []string{"hello"}, got)
+	got, closed := clientHandler.snapshot()
+	assert.Empty(t, got)
+	assert.Equal(t, 0, closed)
+	assert.Empty(t, clientHandler.errors())
+	assert.False(t, ss.IsClosed())
+}
+
+func TestSetHeartbeatAfterOpen(t *testing.T) {
+	var serverHandler, clientHandler errLineHandler
+	server, client, ss := heartbeatPair(t, &serverHandler, &clientHandler,
+		[]ServerOption{WithServerHeartbeat(lineHeartbeat{}, 0, 1)})
+	defer server.Close()
+	defer client.Close()
+
+	// the ping timer is started by SetHeartbeat after the session is opened
+	ss.SetHeartbeat(lineHeartbeat{}, 20*time.Millisecond, 3)
+	assert.Eventually(t, func() bool { return ss.HeartbeatRTT() > 0 }, 3*time.Second, 10*time.Millisecond)
+
+	// the ping timer is stopped by disabling the heartbeat
+	ss.SetHeartbeat(nil, 0, 0)
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, ss.IsClosed())
+	assert.Empty(t, clientHandler.errors())
+	assert.Equal(t, time.Duration(0), ss.HeartbeatRTT())
+}
+
+func TestPingOffTimerWheel(t *testing.T) {
+	wheel := gxtime.NewTimerWheel()
+	defer wheel.Stop()
+
+	var serverHandler, clientHandler errLineHandler
+	server, client, ss := heartbeatPair(t, &serverHandler, &clientHandler,
+		[]ServerOption{WithServerHeartbeat(lineHeartbeat{}, 0, 1)}, WithClientTimerWheel(wheel))
+	defer server.Close()
+	defer client.Close()
+
+	// the pings wait for the write rate limit, which neither blocks the other timers of the wheel nor
+	// makes the pings overlap
+	ss.SetWriteRateLimit(Rate{Pkgs: 1}, OutboundRateDelay)
+	_, _, err := ss.WritePkg("hello", 0)
+	assert.Nil(t, err)
+	ss.SetHeartbeat(lineHeartbeat{}, 10*time.Millisecond, 1000)
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	var fired uatomic.Int32
+	ss.AfterFunc(10*time.Millisecond, func() { fired.Inc() })
+	assert.Eventually(t, func() bool { return fired.Load() == 1 }, 3*time.Second, 5*time.Millisecond)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	assert.Eventually(t, func() bool { return ss.HeartbeatRTT() > 0 }, 3*time.Second, 10*time.Millisecond)
+	got, _ := serverHandler.snapshot()
+	assert.Equal(t, []string{"hello"}, got)
+	assert.False(t, ss.IsClosed())
+}
+
+func TestHeartbeatDeadPeer(t *testing.T) {
+	var serverHandler, clientHandler errLineHandler
+	// the server does not answer the pings
+	server, client, ss := heartbeatPair(t, &serverHandler, &clientHandler, nil)
+	defer server.Close()
+	defer client.Close()
+	assert.Panics(t, func() { ss.SetHeartbeat(lineHeartbeat{}, time.Second, 0) })
+
+	var deadHandler errLineHandler
+	sessions := make(chan Session, 1)
+	dead := newClient(TCP_CLIENT, WithServerAddress(ss.RemoteAddr()), WithConnectionNumber(1))
+	dead.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&deadHandler)
+		session.SetHeartbeat(lineHeartbeat{}, 20*time.Millisecond, 2)
+		// the client reconnects after the session is closed
+		select {
+		case sessions <- session:
+		default:
+		}
+		return nil
+	})
+	defer dead.Close()
+	deadSession := <-sessions
+
+	assert.Eventually(t, func() bool { return deadSession.IsClosed() }, 3*time.Second, 10*time.Millisecond)
+	// the reconnected sessions may be closed as well
+	errs := deadHandler.errors()
+	assert.NotEmpty(t, errs)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, ErrHeartbeatTimeout)
+	}
+	assert.Equal(t, time.Duration(0), deadSession.HeartbeatRTT())
+	got, _ := serverHandler.snapshot()
+	assert.Contains(t, got, "ping")
+}
+
+// udpLineWriter writes the lines of the UDPContexts
+type udpLineWriter struct {
+	udpLineHandler
+}
+
+func (h *udpLineWriter) Write(ss Session, pkg any) ([]byte, error) {
+	return h.udpLineHandler.Write(ss, pkg.(UDPContext).Pkg)
+}
+
+func TestUDPHeartbeat(t *testing.T) {
+	var handler udpLineWriter
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"), WithServerHeartbeat(lineHeartbeat{}, time.Second, 1))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		return nil
+	})
+	defer server.Close()
+
+	peer, err := net.Dial("udp", server.pktListeners[0].LocalAddr().String())
+	assert.Nil(t, err)
+	defer func() { _ = peer.Close() }()
+
+	// an udp endpoint only answers the pings
+	_, err = peer.Write([]byte("ping\n"))
+	assert.Nil(t, err)
+	_ = peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 16)
+	n, err := peer.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong\n", string(buf[:n]))
+
+	_, err = peer.Write([]byte("hello\n"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ := handler.snapshot()
+	assert.Equal(t, []string{"hello"}, got)
+}
@@ -0,0 +1,174 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
+)
+
+// recordLogger is a plain Logger recording its messages
+type recordLogger struct {
+	lock sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) record(level, msg string) {
+	l.lock.Lock()
+	l.msgs = append(l.msgs, level+" "+msg)
+	l.lock.Unlock()
+}
+
+func (l *recordLogger) Info(args ...any) {
+	l.record("INFO", fmt.Sprint(args...))
+}
+
+func (l *recordLogger) Warn(args ...any) {
+	l.record("WARN", fmt.Sprint(args...))
+}
+
+func (l *recordLogger) Error(args ...any) {
+	l.record("ERROR", fmt.Sprint(args...))
+}
+
+func (l *recordLogger) Debug(args ...any) {
+	l.record("DEBUG", fmt.Sprint(args...))
+}
+
+func (l *recordLogger) Infof(template string, args ...any) {
+	l.record("INFO", fmt.Sprintf(template, args...))
+}
+
+func (l *recordLogger) Warnf(template string, args ...any) {
+	l.record("WARN", fmt.Sprintf(template, args...))
+}
+
+func (l *recordLogger) Errorf(template string, args ...any) {
+	l.record("ERROR", fmt.Sprintf(template, args...))
+}
+
+func (l *recordLogger) Debugf(template string, args ...any) {
+	l.record("DEBUG", fmt.Sprintf(template, args...))
+}
+
+// matching returns the messages containing @substr.
+func (l *recordLogger) matching(substr string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var msgs []string
+	for _, msg := range l.msgs {
+		if strings.Contains(msg, substr) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestSessionLogger(t *testing.T) {
+	var (
+		logger  recordLogger
+		handler lineHandler
+	)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerLogger(&logger))
+	sessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		sessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		return nil
+	})
+	defer client.Close()
+	ss := <-sessions
+
+	ss.Logger().Infow("hello", "key", 1)
+	ss.Logger().Warnf("hello %s", "world")
+	fields := fmt.Sprintf("endpoint=TCP_SERVER endpointID=%d sessionID=%d local=%s remote=%s",
+		server.ID(), ss.ID(), ss.LocalAddr(), ss.RemoteAddr())
+	assert.Equal(t, []string{"INFO hello " + fields + " key=1"}, logger.matching("hello "+fields+" key"))
+	assert.Equal(t, []string{"WARN hello world " + fields}, logger.matching("hello world"))
+}
+
+func TestUDPDecodeErrorLogRateLimit(t *testing.T) {
+	var (
+		logger  recordLogger
+		handler udpLineHandler
+	)
+	server := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"), WithServerLogger(&logger))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		return nil
+	})
+	defer server.Close()
+
+	peer, err := net.Dial("udp", server.pktListeners[0].LocalAddr().String())
+	assert.Nil(t, err)
+	defer func() { _ = peer.Close() }()
+
+	// the datagrams without a line can not be decoded
+	for i := 0; i < 3*decodeErrLogBurst; i++ {
+		_, err = peer.Write([]byte("broken"))
+		assert.Nil(t, err)
+	}
+	_, err = peer.Write([]byte("hello\n"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	msgs := logger.matching("no package in datagram")
+	assert.Equal(t, decodeErrLogBurst, len(msgs))
+	assert.Contains(t, msgs[0], "endpoint=UDP_ENDPOINT")
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	var logger recordLogger
+	limited := log.RateLimited(log.With(&logger, "k", "v"), 50*time.Millisecond, 2)
+	for i := 0; i < 5; i++ {
+		limited.Errorw("boom", "i", i)
+	}
+	assert.Equal(t, []string{"ERROR boom k=v i=0", "ERROR boom k=v i=1"}, logger.matching("boom"))
+
+	time.Sleep(60 * time.Millisecond)
+	limited.Errorf("boom %d", 5)
+	assert.Equal(t, "ERROR boom 5 k=v dropped=3", logger.matching("boom")[2])
+
+	assert.Panics(t, func() { log.RateLimited(&logger, 0, 1) })
+	assert.Panics(t, func() { log.RateLimited(&logger, time.Second, 0) })
+}
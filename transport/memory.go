@@ -0,0 +1,135 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	uatomic "go.uber.org/atomic"
+)
+
+const (
+	memoryNetwork = "memory"
+	// the number of the dialed connections which are not accepted yet
+	memoryListenerBacklog = 128
+)
+
+var memoryListenerID uatomic.Uint32
+
+// memoryAddr is the address of an in-memory connection
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return memoryNetwork }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryConn is an end of a net.Pipe with distinct local and remote addresses, so that it does not look
+// like a connection to itself.
+type memoryConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.remote }
+
+// MemoryListener is an in-memory net.Listener whose connections are the ends of net.Pipe. A tcp/ws/wss server
+// serves it by WithServerListener and a client connects to it by WithClientDialer(listener.Dial), so that the
+// tests of the codecs and the listeners run the real sessions and their read loops without any port. See
+// NewMemoryServer and NewMemoryClient.
+type MemoryListener struct {
+	id       uint32
+	addr     memoryAddr
+	conns    chan net.Conn
+	done     chan struct{}
+	once     sync.Once
+	clientID uatomic.Uint32
+}
+
+// NewMemoryListener returns an in-memory listener with a unique address.
+func NewMemoryListener() *MemoryListener {
+	id := memoryListenerID.Inc()
+	return &MemoryListener{
+		id:    id,
+		addr:  memoryAddr(fmt.Sprintf("memory-%d:0", id)),
+		conns: make(chan net.Conn, memoryListenerBacklog),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection dialed by Dial.
+func (l *MemoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, perrors.WithStack(net.ErrClosed)
+	}
+}
+
+// Close closes the listener, the accepted connections are not closed.
+func (l *MemoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *MemoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial connects to the listener, @network and @addr are ignored. It blocks if there are
+// memoryListenerBacklog connections not accepted yet.
+func (l *MemoryListener) Dial(network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	clientAddr := memoryAddr(fmt.Sprintf("memory-%d-client:%d", l.id, l.clientID.Inc()))
+
+	select {
+	case <-l.done:
+	default:
+		select {
+		case l.conns <- &memoryConn{Conn: server, local: l.addr, remote: clientAddr}:
+			return &memoryConn{Conn: client, local: clientAddr, remote: l.addr}, nil
+		case <-l.done:
+		}
+	}
+	_ = client.Close()
+	_ = server.Close()
+	return nil, perrors.WithStack(net.ErrClosed)
+}
+
+// NewMemoryServer builds a tcp server serving the in-memory @listener.
+func NewMemoryServer(listener *MemoryListener, opts ...ServerOption) Server {
+	return newServer(TCP_SERVER, append(opts, WithServerListener(listener))...)
+}
+
+// NewMemoryClient builds a tcp client connecting to the in-memory @listener, its connection number is 1 by
+// default.
+func NewMemoryClient(listener *MemoryListener, opts ...ClientOption) Client {
+	opts = append([]ClientOption{WithServerAddress(listener.Addr().String()), WithConnectionNumber(1)}, opts...)
+	return newClient(TCP_CLIENT, append(opts, WithClientDialer(listener.Dial))...)
+}
@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	listener := NewMemoryListener()
+	var serverHandler, clientHandler lineHandler
+	server := NewMemoryServer(listener)
+	serverSessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		serverSessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := NewMemoryClient(listener)
+	clientSessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&clientHandler)
+		session.SetEventListener(&clientHandler)
+		clientSessions <- session
+		return nil
+	})
+	ss, cs := <-serverSessions, <-clientSessions
+	assert.Equal(t, cs.LocalAddr(), ss.RemoteAddr())
+	assert.Equal(t, listener.Addr().String(), cs.RemoteAddr())
+
+	_, _, err := cs.WritePkg("ping", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	_, _, err = ss.WritePkg("pong", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := clientHandler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ := serverHandler.snapshot()
+	assert.Equal(t, []string{"ping"}, got)
+	got, _ = clientHandler.snapshot()
+	assert.Equal(t, []string{"pong"}, got)
+
+	client.Close()
+	assert.Eventually(t, func() bool {
+		_, closed := serverHandler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.True(t, ss.IsClosed())
+}
+
+func TestMemoryWebsocket(t *testing.T) {
+	listener := NewMemoryListener()
+	var serverHandler lineHandler
+	server := newServer(WS_SERVER, WithServerListener(listener), WithWebsocketServerPath("/memory"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(WS_CLIENT, WithServerAddress("ws://"+listener.Addr().String()+"/memory"),
+		WithConnectionNumber(1), WithClientDialer(listener.Dial))
+	sessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	_, _, err := (<-sessions).WritePkg("hello", 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 1 && got[0] == "hello"
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestMemoryListenerClose(t *testing.T) {
+	listener := NewMemoryListener()
+	conn, err := listener.Dial("tcp", "")
+	assert.Nil(t, err)
+	accepted, err := listener.Accept()
+	assert.Nil(t, err)
+	assert.Equal(t, conn.LocalAddr(), accepted.RemoteAddr())
+	assert.Equal(t, listener.Addr(), conn.RemoteAddr())
+	assert.Equal(t, "memory", conn.LocalAddr().Network())
+
+	assert.Nil(t, listener.Close())
+	assert.Nil(t, listener.Close())
+	_, err = listener.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = listener.Dial("tcp", "")
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -17,8 +17,21 @@
 
 package getty
 
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
 import (
 	gxsync "github.com/dubbogo/gost/sync"
+	gxtime "github.com/dubbogo/gost/time"
+
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
 )
 
 type ServerOption func(*ServerOptions)
@@ -26,8 +39,50 @@ type ServerOption func(*ServerOptions)
 type ServerOptions struct {
 	addr string
 	// tls
-	sslEnabled       bool
-	tlsConfigBuilder TlsConfigBuilder
+	sslEnabled          bool
+	tlsConfigBuilder    TlsConfigBuilder
+	tlsHandshakeTimeout time.Duration
+	// PROXY protocol
+	proxyProtocol       bool
+	proxyTrustedSources []*net.IPNet
+	proxyHeaderTimeout  time.Duration
+	// SO_REUSEPORT socket number
+	reusePortNum int
+	// epoll poller number of the reactor mode
+	reactorPollerNum int
+	// per-message compression
+	msgCompressTypes     []CompressType
+	msgCompressThreshold int
+	// compression threshold of the udp datagrams
+	datagramCompressThreshold int
+	// pre-shared key encryption
+	pskCipher  PSKCipher
+	pskKeyring *PSKKeyring
+	// rate limits shared by all of the sessions
+	readLimit  *readRateLimit
+	writeLimit *writeRateLimit
+	// connection admission control
+	admission *admissionControl
+	// transport heartbeat
+	heartbeat *heartbeatConfig
+	// the timer wheel of the sessions
+	timerWheel timerWheel
+	// the tracer of the sessions
+	tracer Tracer
+	// the logger of the endpoint and its sessions
+	logger log.StructuredLogger
+	// injects faults into the accepted connections
+	faultInjector *FaultInjector
+	// records the traffic of the sessions
+	recorder *Recorder
+	// called with every new session before the NewSessionCallback
+	sessionInits []NewSessionCallback
+	// the options of the tcp sockets
+	socketOptions *SocketOptions
+	// pre-opened sockets
+	listener    net.Listener
+	pktConn     net.PacketConn
+	listenerFds []uintptr
 	// websocket
 	path       string
 	cert       string
@@ -35,6 +90,8 @@ type ServerOptions struct {
 	caCert     string
 	// task queue
 	tPool gxsync.GenericTaskPool
+	// the errors of the illegal options, they are logged when the server is built
+	optionErrs []error
 }
 
 // WithLocalAddress @addr server listen address.
@@ -93,6 +150,361 @@ func WithServerTlsConfigBuilder(tlsConfigBuilder TlsConfigBuilder) ServerOption
 	}
 }
 
+// WithServerTlsHandshakeTimeout @timeout is the maximum duration of the tls handshake of an accepted connection.
+func WithServerTlsHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < timeout {
+			o.tlsHandshakeTimeout = timeout
+		}
+	}
+}
+
+// WithServerProxyProtocol enable parsing PROXY protocol v1/v2 header of tcp/ws/wss connections.
+// Session.RemoteAddr() reports the client address carried by the header.
+func WithServerProxyProtocol(enabled bool) ServerOption {
+	return func(o *ServerOptions) {
+		o.proxyProtocol = enabled
+	}
+}
+
+// WithServerProxyProtocolTrustedSources @cidrs are ip addresses or CIDR blocks of the load balancers. PROXY
+// headers are only parsed on connections from these sources. All sources are trusted if it is not set. An
+// illegal @cidrs is logged as an error when the server is built, and no source is trusted then.
+func WithServerProxyProtocolTrustedSources(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		if len(cidrs) == 0 {
+			o.proxyTrustedSources = nil
+			return
+		}
+		ipNets, err := parseCIDRs(cidrs)
+		if err != nil {
+			o.optionErrs = append(o.optionErrs, perrors.WithMessagef(err, "illegal PROXY protocol trusted sources %v", cidrs))
+			ipNets = []*net.IPNet{}
+		}
+		o.proxyTrustedSources = ipNets
+	}
+}
+
+// WithServerProxyProtocolHeaderTimeout @timeout is the maximum duration to read the PROXY header.
+func WithServerProxyProtocolHeaderTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < timeout {
+			o.proxyHeaderTimeout = timeout
+		}
+	}
+}
+
+// WithServerReusePort @num is the number of SO_REUSEPORT sockets bound to the server address. Every socket is
+// served by its own accept loop(tcp/ws/wss) or read loop(udp), and all of them share the same NewSessionCallback.
+// It only takes effect on linux.
+func WithServerReusePort(num int) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < num {
+			o.reusePortNum = num
+		}
+	}
+}
+
+// WithServerListener @listener is a pre-opened listener of a tcp/ws/wss server, for example
+// an in-memory listener in tests. The server does not listen on its address if it is set.
+func WithServerListener(listener net.Listener) ServerOption {
+	return func(o *ServerOptions) {
+		o.listener = listener
+	}
+}
+
+// WithServerPacketConn @conn is a pre-opened *net.UDPConn of an udp endpoint.
+func WithServerPacketConn(conn net.PacketConn) ServerOption {
+	return func(o *ServerOptions) {
+		o.pktConn = conn
+	}
+}
+
+// WithServerListenerFd @fds are inherited listening socket file descriptors, passed by systemd socket
+// activation or by the parent process(see StartChildProcess and InheritedFds). More than one fd is
+// served like SO_REUSEPORT sockets. The server takes the ownership of @fds: it closes them once it
+// listens, so the caller should neither close them nor keep an *os.File of them which may close them
+// when it is finalized.
+func WithServerListenerFd(fds ...uintptr) ServerOption {
+	return func(o *ServerOptions) {
+		o.listenerFds = fds
+	}
+}
+
+// messageCompressTypes returns the types of @types that can compress single messages, and the error of
+// the others.
+func messageCompressTypes(types []CompressType) ([]CompressType, error) {
+	supported := make([]CompressType, 0, len(types))
+	for _, t := range types {
+		if !messageCompressionSupported(t) {
+			return supported, perrors.Errorf("compress type %d does not support per-message compression", t)
+		}
+		supported = append(supported, t)
+	}
+
+	return supported, nil
+}
+
+// WithServerMessageCompression compress every message of tcp sessions on its own instead of the whole
+// stream. The compress type is negotiated with the client when a connection is set up: the first type
+// preferred by the client and contained in @types(CompressSnappy/CompressZstd/CompressLZ4) is chosen.
+// Messages shorter than @threshold are not compressed. The client should enable it too. A type that can not
+// compress single messages is logged as an error when the server is built, and it and the types after it
+// are ignored.
+func WithServerMessageCompression(threshold int, types ...CompressType) ServerOption {
+	return func(o *ServerOptions) {
+		if threshold <= 0 {
+			threshold = DefaultMessageCompressionThreshold
+		}
+		var err error
+		if o.msgCompressTypes, err = messageCompressTypes(types); err != nil {
+			o.optionErrs = append(o.optionErrs, err)
+		}
+		o.msgCompressThreshold = threshold
+	}
+}
+
+// WithServerDatagramCompressionThreshold the datagrams shorter than @threshold are sent uncompressed by the
+// udp sessions of the server whose compress type is set by SetCompressType. A non-positive @threshold means
+// DefaultMessageCompressionThreshold.
+func WithServerDatagramCompressionThreshold(threshold int) ServerOption {
+	return func(o *ServerOptions) {
+		if threshold <= 0 {
+			threshold = DefaultMessageCompressionThreshold
+		}
+		o.datagramCompressThreshold = threshold
+	}
+}
+
+// checkPSKEncryption panics if @suite or @keyring is illegal.
+func checkPSKEncryption(suite PSKCipher, keyring *PSKKeyring) {
+	if !suite.valid() {
+		panic(fmt.Sprintf("illegal pre-shared key cipher %d", suite))
+	}
+	if keyring == nil {
+		panic("the pre-shared keyring is nil")
+	}
+}
+
+// WithServerPSKEncryption encrypt tcp/ws/udp sessions by the AEAD @suite. The session keys of a tcp/ws
+// connection are derived from the pre-shared key chosen by the client and the randoms exchanged in a handshake
+// after the tls handshake and the PROXY header, in which both peers prove that they own the key. Every udp
+// datagram is encrypted on its own, and the replayed or stale datagrams are dropped, so the clocks of the
+// peers should be synchronized. The client should enable it with the same cipher and keys.
+func WithServerPSKEncryption(suite PSKCipher, keyring *PSKKeyring) ServerOption {
+	checkPSKEncryption(suite, keyring)
+	return func(o *ServerOptions) {
+		o.pskCipher = suite
+		o.pskKeyring = keyring
+	}
+}
+
+// WithServerReadRateLimit limits the packages read by all of the sessions of the server to @rate together,
+// and the excess packages are handled by @policy. Every session can have its own limit by
+// Session.SetReadRateLimit as well. The reactor mode does not serve the sessions of the InboundRatePause policy,
+// and a session served by the reactor refuses it.
+func WithServerReadRateLimit(rate Rate, policy InboundRatePolicy) ServerOption {
+	return func(o *ServerOptions) {
+		o.readLimit = newReadRateLimit(rate, policy)
+	}
+}
+
+// WithServerWriteRateLimit limits the packages written by all of the sessions of the server to @rate together,
+// and the excess packages are handled by @policy. Every session can have its own limit by
+// Session.SetWriteRateLimit as well.
+func WithServerWriteRateLimit(rate Rate, policy OutboundRatePolicy) ServerOption {
+	return func(o *ServerOptions) {
+		o.writeLimit = newWriteRateLimit(rate, policy)
+	}
+}
+
+// WithServerHeartbeat enables the transport heartbeat of all of the sessions of the server, see
+// Session.SetHeartbeat. A session can override it by Session.SetHeartbeat.
+func WithServerHeartbeat(factory HeartbeatFactory, interval time.Duration, maxMissed int) ServerOption {
+	return func(o *ServerOptions) {
+		o.heartbeat = newHeartbeatConfig(factory, interval, maxMissed)
+	}
+}
+
+// WithServerTimerWheel @wheel runs the cron, the heartbeat and the timers of the sessions of the server instead
+// of the global timer wheel. The timer functions without a task pool run in the goroutine of @wheel, except
+// the cron and the heartbeat ping which run in their own goroutines like with the global timer wheel.
+func WithServerTimerWheel(wheel *gxtime.TimerWheel) ServerOption {
+	return func(o *ServerOptions) {
+		if wheel != nil {
+			o.timerWheel = gxTimerWheel{wheel: wheel}
+		}
+	}
+}
+
+// WithServerScheduler @scheduler runs the cron, the heartbeat and the timers of the sessions of the server
+// instead of the global timer wheel. It can be shared by several endpoints, and it is not closed by the server.
+func WithServerScheduler(scheduler *Scheduler) ServerOption {
+	return func(o *ServerOptions) {
+		if scheduler != nil {
+			o.timerWheel = scheduler
+		}
+	}
+}
+
+// WithServerTracer @tracer traces the connect, the tls handshake and the messages of the sessions of the server.
+func WithServerTracer(tracer Tracer) ServerOption {
+	return func(o *ServerOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithServerLogger @logger logs the messages of the server and its sessions instead of the global logger. The
+// messages are logged with the endpoint fields, and the messages of a session with the session fields.
+func WithServerLogger(logger log.Logger) ServerOption {
+	return func(o *ServerOptions) {
+		if logger != nil {
+			o.logger = log.Structured(logger)
+		}
+	}
+}
+
+// admissionControl returns the admission control of the server options and creates it if it is not set.
+func (o *ServerOptions) admissionControl() *admissionControl {
+	if o.admission == nil {
+		o.admission = newAdmissionControl()
+	}
+
+	return o.admission
+}
+
+// WithServerFaultInjector @injector injects faults into the connections accepted by the server, or into the
+// datagrams sent by an udp endpoint. It is for the resilience tests, the reactor does not poll the wrapped
+// connections.
+func WithServerFaultInjector(injector *FaultInjector) ServerOption {
+	return func(o *ServerOptions) {
+		o.faultInjector = injector
+	}
+}
+
+// WithServerRecorder @recorder records the traffic of the sessions of the server, see Replay and ReplayTo.
+func WithServerRecorder(recorder *Recorder) ServerOption {
+	return func(o *ServerOptions) {
+		o.recorder = recorder
+	}
+}
+
+// WithServerSessionInit @init is called with every new session of the server before the NewSessionCallback
+// of RunEventLoop, in the order of the options. An error of @init is handled like an error of the
+// NewSessionCallback.
+func WithServerSessionInit(init NewSessionCallback) ServerOption {
+	if init == nil {
+		panic("@init is nil")
+	}
+	return func(o *ServerOptions) {
+		o.sessionInits = append(o.sessionInits, init)
+	}
+}
+
+// WithServerSocketOptions @opts are set to the tcp socket of every session of a tcp/ws/wss server before the
+// session is handed to the NewSessionCallback. See SocketOptions.
+func WithServerSocketOptions(opts SocketOptions) ServerOption {
+	opts.check()
+	return func(o *ServerOptions) {
+		o.socketOptions = &opts
+	}
+}
+
+// WithServerMaxConnections @num is the maximum number of connections of a tcp/ws/wss server. The excess
+// connections are closed before their sessions are created.
+func WithServerMaxConnections(num int) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < num {
+			o.admissionControl().maxConns = num
+		}
+	}
+}
+
+// WithServerMaxConnectionsPerSource @num is the maximum number of connections of a tcp/ws/wss server from
+// a source, which is the network of @ipv4PrefixLen bits of an ipv4 address or @ipv6PrefixLen bits of an ipv6
+// address. A non-positive prefix length means a single address.
+func WithServerMaxConnectionsPerSource(num, ipv4PrefixLen, ipv6PrefixLen int) ServerOption {
+	return func(o *ServerOptions) {
+		if num <= 0 {
+			return
+		}
+		if ipv4PrefixLen > 8*net.IPv4len || ipv6PrefixLen > 8*net.IPv6len {
+			panic(fmt.Sprintf("illegal source prefix length %d/%d", ipv4PrefixLen, ipv6PrefixLen))
+		}
+		a := o.admissionControl()
+		a.maxSourceConns = num
+		if 0 < ipv4PrefixLen {
+			a.sourcePrefixV4 = ipv4PrefixLen
+		}
+		if 0 < ipv6PrefixLen {
+			a.sourcePrefixV6 = ipv6PrefixLen
+		}
+	}
+}
+
+// WithServerAcceptRate @perSecond is the maximum number of connections a tcp/ws/wss server accepts per second,
+// with bursts of up to one second of the rate.
+func WithServerAcceptRate(perSecond int) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < perSecond {
+			o.admissionControl().acceptRate = newTokenBucket(perSecond)
+		}
+	}
+}
+
+// WithServerAllowList @cidrs are ip addresses or CIDR blocks. A tcp/ws/wss server only accepts the connections
+// from these sources if it is set. The source of a connection with a PROXY protocol header is the client
+// address in the header. An illegal @cidrs is logged as an error when the server is built, and no connection
+// is accepted then.
+func WithServerAllowList(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		if len(cidrs) == 0 {
+			o.admissionControl().allow = nil
+			return
+		}
+		ipNets, err := parseCIDRs(cidrs)
+		if err != nil {
+			o.optionErrs = append(o.optionErrs, perrors.WithMessagef(err, "illegal allow list %v", cidrs))
+			ipNets = []*net.IPNet{}
+		}
+		o.admissionControl().allow = ipNets
+	}
+}
+
+// WithServerDenyList @cidrs are ip addresses or CIDR blocks whose connections are refused by a tcp/ws/wss server.
+// The deny list takes precedence over the allow list. An illegal @cidrs is logged as an error when the server
+// is built, and all of the connections are refused then.
+func WithServerDenyList(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		ipNets, err := parseCIDRs(cidrs)
+		if err != nil {
+			o.optionErrs = append(o.optionErrs, perrors.WithMessagef(err, "illegal deny list %v", cidrs))
+			ipNets = []*net.IPNet{
+				{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8*net.IPv4len)},
+				{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)},
+			}
+		}
+		o.admissionControl().deny = ipNets
+	}
+}
+
+// WithServerReactor let a tcp server serve its sessions by @pollerNum epoll poller goroutines instead
+// of a read goroutine per session, which saves memory and goroutines when there are a very large number of
+// connections. It only takes effect on linux, and sessions over tls or encryption, with compression or with a
+// ZeroCopyReader are still served by their own read goroutine. The compress type should be set in
+// NewSessionCallback if necessary.
+// A session served by a poller has no read goroutine, so it is not counted by the goroutine number of
+// the session, and its read timeout does not take effect because the poller only reads readable sockets.
+// Pls use a task pool(WithServerTaskPool) in the reactor mode, otherwise EventListener.OnMessage runs in the
+// poller goroutine and blocks the other sessions of the poller.
+func WithServerReactor(pollerNum int) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < pollerNum {
+			o.reactorPollerNum = pollerNum
+		}
+	}
+}
+
 /////////////////////////////////////////
 // Client Options
 /////////////////////////////////////////
@@ -104,9 +516,40 @@ type ClientOptions struct {
 	number               int
 	reconnectInterval    int // reConnect Interval
 	maxReconnectAttempts int // max reconnect attempts
+	// connects to the server instead of net.DialTimeout
+	dialer func(network, addr string) (net.Conn, error)
 	// tls
 	sslEnabled       bool
 	tlsConfigBuilder TlsConfigBuilder
+	// PROXY protocol header sent after connecting
+	proxyProtocol ProxyProtocolVersion
+	// per-message compression
+	msgCompressTypes     []CompressType
+	msgCompressThreshold int
+	// compression threshold of the udp datagrams
+	datagramCompressThreshold int
+	// pre-shared key encryption
+	pskCipher  PSKCipher
+	pskKeyring *PSKKeyring
+	// rate limits shared by all of the sessions
+	readLimit  *readRateLimit
+	writeLimit *writeRateLimit
+	// transport heartbeat
+	heartbeat *heartbeatConfig
+	// the timer wheel of the sessions
+	timerWheel timerWheel
+	// the tracer of the sessions
+	tracer Tracer
+	// the logger of the endpoint and its sessions
+	logger log.StructuredLogger
+	// injects faults into the dialed connections
+	faultInjector *FaultInjector
+	// records the traffic of the sessions
+	recorder *Recorder
+	// called with every new session before the NewSessionCallback
+	sessionInits []NewSessionCallback
+	// the options of the tcp sockets
+	socketOptions *SocketOptions
 
 	// the cert file of wss server which may contain server domain, server ip, the starting effective date, effective
 	// duration, the hash alg, the len of the private key.
@@ -114,6 +557,8 @@ type ClientOptions struct {
 	cert string
 	// task queue
 	tPool gxsync.GenericTaskPool
+	// the errors of the illegal options, they are logged when the client is built
+	optionErrs []error
 }
 
 // WithServerAddress @addr is server address.
@@ -177,3 +622,161 @@ func WithReconnectAttempts(maxReconnectAttempts int) ClientOption {
 		}
 	}
 }
+
+// WithClientDialer @dial connects the tcp/ws/wss sessions to the server instead of net.DialTimeout, for
+// example MemoryListener.Dial in tests.
+func WithClientDialer(dial func(network, addr string) (net.Conn, error)) ClientOption {
+	return func(o *ClientOptions) {
+		o.dialer = dial
+	}
+}
+
+// WithClientProxyProtocol send a PROXY protocol header of @version on every new tcp/ws/wss connection.
+func WithClientProxyProtocol(version ProxyProtocolVersion) ClientOption {
+	return func(o *ClientOptions) {
+		o.proxyProtocol = version
+	}
+}
+
+// WithClientMessageCompression compress every message of tcp sessions on its own, @types are the
+// compress types in order of preference. See WithServerMessageCompression.
+func WithClientMessageCompression(threshold int, types ...CompressType) ClientOption {
+	return func(o *ClientOptions) {
+		if threshold <= 0 {
+			threshold = DefaultMessageCompressionThreshold
+		}
+		var err error
+		if o.msgCompressTypes, err = messageCompressTypes(types); err != nil {
+			o.optionErrs = append(o.optionErrs, err)
+		}
+		o.msgCompressThreshold = threshold
+	}
+}
+
+// WithClientDatagramCompressionThreshold the datagrams shorter than @threshold are sent uncompressed by the
+// udp sessions of the client. See WithServerDatagramCompressionThreshold.
+func WithClientDatagramCompressionThreshold(threshold int) ClientOption {
+	return func(o *ClientOptions) {
+		if threshold <= 0 {
+			threshold = DefaultMessageCompressionThreshold
+		}
+		o.datagramCompressThreshold = threshold
+	}
+}
+
+// WithClientPSKEncryption encrypt tcp/ws/udp sessions by the AEAD @suite and the current key of @keyring.
+// See WithServerPSKEncryption.
+func WithClientPSKEncryption(suite PSKCipher, keyring *PSKKeyring) ClientOption {
+	checkPSKEncryption(suite, keyring)
+	return func(o *ClientOptions) {
+		o.pskCipher = suite
+		o.pskKeyring = keyring
+	}
+}
+
+// WithClientReadRateLimit limits the packages read by all of the sessions of the client to @rate together.
+// See WithServerReadRateLimit.
+func WithClientReadRateLimit(rate Rate, policy InboundRatePolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.readLimit = newReadRateLimit(rate, policy)
+	}
+}
+
+// WithClientWriteRateLimit limits the packages written by all of the sessions of the client to @rate together.
+// See WithServerWriteRateLimit.
+func WithClientWriteRateLimit(rate Rate, policy OutboundRatePolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.writeLimit = newWriteRateLimit(rate, policy)
+	}
+}
+
+// WithClientTimerWheel @wheel runs the timers of the sessions of the client. See WithServerTimerWheel.
+func WithClientTimerWheel(wheel *gxtime.TimerWheel) ClientOption {
+	return func(o *ClientOptions) {
+		if wheel != nil {
+			o.timerWheel = gxTimerWheel{wheel: wheel}
+		}
+	}
+}
+
+// WithClientScheduler @scheduler runs the timers of the sessions of the client. See WithServerScheduler.
+func WithClientScheduler(scheduler *Scheduler) ClientOption {
+	return func(o *ClientOptions) {
+		if scheduler != nil {
+			o.timerWheel = scheduler
+		}
+	}
+}
+
+// WithClientTracer @tracer traces the sessions of the client. See WithServerTracer.
+func WithClientTracer(tracer Tracer) ClientOption {
+	return func(o *ClientOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithClientLogger @logger logs the messages of the client and its sessions. See WithServerLogger.
+func WithClientLogger(logger log.Logger) ClientOption {
+	return func(o *ClientOptions) {
+		if logger != nil {
+			o.logger = log.Structured(logger)
+		}
+	}
+}
+
+// WithClientFaultInjector @injector injects faults into the connections dialed by the client, or into the
+// datagrams sent by an udp client. It is for the resilience tests.
+func WithClientFaultInjector(injector *FaultInjector) ClientOption {
+	return func(o *ClientOptions) {
+		o.faultInjector = injector
+	}
+}
+
+// WithClientRecorder @recorder records the traffic of the sessions of the client, see Replay and ReplayTo.
+func WithClientRecorder(recorder *Recorder) ClientOption {
+	return func(o *ClientOptions) {
+		o.recorder = recorder
+	}
+}
+
+// WithClientHeartbeat enables the transport heartbeat of all of the sessions of the client. See WithServerHeartbeat.
+func WithClientHeartbeat(factory HeartbeatFactory, interval time.Duration, maxMissed int) ClientOption {
+	return func(o *ClientOptions) {
+		o.heartbeat = newHeartbeatConfig(factory, interval, maxMissed)
+	}
+}
+
+// WithClientSessionInit @init is called with every new session of the client before the NewSessionCallback
+// of RunEventLoop. See WithServerSessionInit.
+func WithClientSessionInit(init NewSessionCallback) ClientOption {
+	if init == nil {
+		panic("@init is nil")
+	}
+	return func(o *ClientOptions) {
+		o.sessionInits = append(o.sessionInits, init)
+	}
+}
+
+// WithClientSocketOptions @opts are set to the tcp socket of every session of a tcp/ws/wss client before the
+// session is handed to the NewSessionCallback. See SocketOptions.
+func WithClientSocketOptions(opts SocketOptions) ClientOption {
+	opts.check()
+	return func(o *ClientOptions) {
+		o.socketOptions = &opts
+	}
+}
+
+// chainSessionInits returns the callback calling @inits and then @newSession.
+func chainSessionInits(inits []NewSessionCallback, newSession NewSessionCallback) NewSessionCallback {
+	if len(inits) == 0 {
+		return newSession
+	}
+	return func(ss Session) error {
+		for _, init := range inits {
+			if err := init(ss); err != nil {
+				return err
+			}
+		}
+		return newSession(ss)
+	}
+}
@@ -18,7 +18,9 @@
 package getty
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 import (
@@ -67,3 +69,49 @@ func TestServerOptions(t *testing.T) {
 	assert.Equal(t, srv.privateKey, key)
 	assert.Equal(t, srv.caCert, cert)
 }
+
+func TestSessionInit(t *testing.T) {
+	var order []string
+	errInit := errors.New("init failed")
+	listener := NewMemoryListener()
+	server := NewMemoryServer(listener,
+		WithServerSessionInit(func(session Session) error {
+			order = append(order, "first")
+			session.SetName("init")
+			return nil
+		}),
+		WithServerSessionInit(func(Session) error {
+			order = append(order, "second")
+			if len(order) > 3 {
+				return errInit
+			}
+			return nil
+		}),
+	)
+	serverSessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		order = append(order, "callback")
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		serverSessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	conn, err := listener.Dial("tcp", "")
+	assert.Nil(t, err)
+	defer conn.Close()
+	ss := <-serverSessions
+	assert.Equal(t, []string{"first", "second", "callback"}, order)
+	assert.Equal(t, "init", ss.(*session).name)
+
+	// the connection is closed if an init fails
+	conn2, err := listener.Dial("tcp", "")
+	assert.Nil(t, err)
+	defer conn2.Close()
+	_ = conn2.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = conn2.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"first", "second", "callback", "first", "second"}, order)
+	assert.Panics(t, func() { WithClientSessionInit(nil) })
+}
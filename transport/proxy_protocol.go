@@ -0,0 +1,325 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
+)
+
+// ProxyProtocolVersion is the version of the PROXY protocol header emitted by a client.
+// See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt for details.
+type ProxyProtocolVersion int
+
+const (
+	ProxyProtocolNone ProxyProtocolVersion = 0
+	ProxyProtocolV1   ProxyProtocolVersion = 1
+	ProxyProtocolV2   ProxyProtocolVersion = 2
+)
+
+const (
+	defaultProxyHeaderTimeout = 3e9 // 3s
+	// "PROXY TCP6 ffff:...:ffff ffff:...:ffff 65535 65535\r\n"
+	proxyV1MaxHeaderLen = 107
+	proxyV2HeaderLen    = 16
+	proxyV2CmdLocal     = 0x0
+	proxyV2CmdProxy     = 0x1
+	proxyV2FamTCP4      = 0x11
+	proxyV2FamUDP4      = 0x12
+	proxyV2FamTCP6      = 0x21
+	proxyV2FamUDP6      = 0x22
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	ErrProxyHeaderInvalid = perrors.New("invalid PROXY protocol header")
+)
+
+// proxyProtocolTrusted check whether the PROXY header sent by @addr should be parsed.
+// A nil @trusted list means that every peer is trusted, and an empty one that no peer is.
+func proxyProtocolTrusted(addr net.Addr, trusted []*net.IPNet) bool {
+	if trusted == nil {
+		return true
+	}
+
+	ip := addrIP(addr)
+	return ip != nil && ipNetsContain(trusted, ip)
+}
+
+// parseCIDRs parse ip addresses and CIDR blocks. A single ip is treated as a /32 or /128 network.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, perrors.Errorf("illegal ip address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, perrors.WithStack(err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+
+	return ipNets, nil
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from @conn within @timeout. It never reads
+// beyond the end of the header, so the stream following the header is left untouched in @conn.
+// The returned address is nil if the header does not carry a source address(v1 UNKNOWN, v2 LOCAL).
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Addr, error) {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	// the shortest v1 header "PROXY UNKNOWN\r\n" is longer than the v2 signature.
+	buf := make([]byte, len(proxyV2Signature), proxyV1MaxHeaderLen)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, perrors.Wrap(err, "read PROXY header")
+	}
+
+	switch {
+	case bytes.Equal(buf, proxyV2Signature):
+		return readProxyHeaderV2(conn)
+	case bytes.HasPrefix(buf, proxyV1Prefix):
+		return readProxyHeaderV1(conn, buf)
+	}
+
+	return nil, ErrProxyHeaderInvalid
+}
+
+func readProxyHeaderV1(conn net.Conn, buf []byte) (net.Addr, error) {
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(buf, []byte("\r\n")) {
+		if len(buf) >= proxyV1MaxHeaderLen {
+			return nil, perrors.Wrap(ErrProxyHeaderInvalid, "v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, perrors.Wrap(err, "read PROXY v1 header")
+		}
+		buf = append(buf, b[0])
+	}
+
+	fields := strings.Fields(string(buf[:len(buf)-2]))
+	if len(fields) < 2 {
+		return nil, ErrProxyHeaderInvalid
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "unknown v1 protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "v1 header %q", string(buf))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "v1 source %s:%s", fields[2], fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(conn net.Conn) (net.Addr, error) {
+	hdr := make([]byte, proxyV2HeaderLen-len(proxyV2Signature))
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, perrors.Wrap(err, "read PROXY v2 header")
+	}
+	if hdr[0]>>4 != 0x2 {
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "v2 version %d", hdr[0]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[2:4]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, perrors.Wrap(err, "read PROXY v2 addresses")
+	}
+
+	switch hdr[0] & 0x0F {
+	case proxyV2CmdLocal:
+		return nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "v2 command %d", hdr[0]&0x0F)
+	}
+
+	var ipLen int
+	switch hdr[1] {
+	case proxyV2FamTCP4, proxyV2FamUDP4:
+		ipLen = net.IPv4len
+	case proxyV2FamTCP6, proxyV2FamUDP6:
+		ipLen = net.IPv6len
+	default:
+		// unix socket or unspecified address family, keep the real peer address
+		return nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, perrors.Wrapf(ErrProxyHeaderInvalid, "v2 address length %d", len(payload))
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+	if hdr[1] == proxyV2FamUDP4 || hdr[1] == proxyV2FamUDP6 {
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// writeProxyHeader sends a PROXY header announcing @conn's local address as the source address.
+func writeProxyHeader(conn net.Conn, version ProxyProtocolVersion) error {
+	src, srcOK := conn.LocalAddr().(*net.TCPAddr)
+	dst, dstOK := conn.RemoteAddr().(*net.TCPAddr)
+
+	var header []byte
+	switch version {
+	case ProxyProtocolNone:
+		return nil
+
+	case ProxyProtocolV1:
+		switch {
+		case !srcOK || !dstOK:
+			header = []byte("PROXY UNKNOWN\r\n")
+		case src.IP.To4() != nil && dst.IP.To4() != nil:
+			header = []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+		default:
+			header = []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", src.IP.To16(), dst.IP.To16(), src.Port, dst.Port))
+		}
+
+	case ProxyProtocolV2:
+		header = append(header, proxyV2Signature...)
+		switch {
+		case !srcOK || !dstOK:
+			header = append(header, 0x20|proxyV2CmdLocal, 0x00, 0x00, 0x00)
+		case src.IP.To4() != nil && dst.IP.To4() != nil:
+			header = append(header, 0x20|proxyV2CmdProxy, proxyV2FamTCP4, 0x00, 12)
+			header = append(header, src.IP.To4()...)
+			header = append(header, dst.IP.To4()...)
+			header = binary.BigEndian.AppendUint16(header, uint16(src.Port))
+			header = binary.BigEndian.AppendUint16(header, uint16(dst.Port))
+		default:
+			header = append(header, 0x20|proxyV2CmdProxy, proxyV2FamTCP6, 0x00, 36)
+			header = append(header, src.IP.To16()...)
+			header = append(header, dst.IP.To16()...)
+			header = binary.BigEndian.AppendUint16(header, uint16(src.Port))
+			header = binary.BigEndian.AppendUint16(header, uint16(dst.Port))
+		}
+
+	default:
+		return perrors.Errorf("illegal PROXY protocol version %d", version)
+	}
+
+	_, err := conn.Write(header)
+	return perrors.WithStack(err)
+}
+
+// proxyProtocolListener wraps the listener of a ws/wss server. The PROXY header of every accepted
+// connection is parsed lazily by the connection's own goroutine, so that a slow peer can not block
+// the http server's accept loop.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !proxyProtocolTrusted(conn.RemoteAddr(), l.trusted) {
+		return conn, nil
+	}
+
+	return &proxyProtocolConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	peer    net.Addr
+	err     error
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.peer, c.err = readProxyHeader(c.Conn, c.timeout)
+		if c.err != nil {
+			log.Warnf("readProxyHeader(peer:%s) = error:%+v", c.Conn.RemoteAddr(), c.err)
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address carried by the PROXY header.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.peer != nil {
+		return c.peer
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// NetConn returns the underlying connection.
+func (c *proxyProtocolConn) NetConn() net.Conn {
+	return c.Conn
+}
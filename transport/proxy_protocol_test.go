@@ -0,0 +1,162 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func testProxyHeaderRoundTrip(t *testing.T, version ProxyProtocolVersion) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = writeProxyHeader(conn, version)
+		_, _ = conn.Write([]byte("hello"))
+	}()
+
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	peer, err := readProxyHeader(conn, time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, peer)
+	assert.Equal(t, conn.RemoteAddr().String(), peer.String())
+
+	// the stream after the header must be intact
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestProxyHeader(t *testing.T) {
+	testProxyHeaderRoundTrip(t, ProxyProtocolV1)
+	testProxyHeaderRoundTrip(t, ProxyProtocolV2)
+
+	client, server := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte("PROXY UNKNOWN\r\n"))
+		_, _ = client.Write([]byte("GET / HTTP/1.1\r\n"))
+	}()
+	peer, err := readProxyHeader(server, time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, peer)
+	_ = client.Close()
+	_ = server.Close()
+
+	// the writer above may still be running, so the second pipe has its own variables
+	plainClient, plainServer := net.Pipe()
+	go func() { _, _ = plainClient.Write([]byte("GET / HTTP/1.1\r\n")) }()
+	_, err = readProxyHeader(plainServer, time.Second)
+	assert.ErrorIs(t, err, ErrProxyHeaderInvalid)
+	_ = plainClient.Close()
+	_ = plainServer.Close()
+}
+
+func TestProxyProtocolTrusted(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.Nil(t, err)
+	assert.True(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, trusted))
+	assert.True(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, trusted))
+	assert.False(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}, trusted))
+	assert.True(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}, nil))
+
+	_, err = parseCIDRs([]string{"not-an-ip"})
+	assert.NotNil(t, err)
+
+	// the illegal trusted sources are logged, and no source is trusted then
+	var logger recordLogger
+	server := newServer(TCP_SERVER, WithServerLogger(&logger), WithServerProxyProtocolTrustedSources("10.0.0.0/8", "not-an-ip"))
+	assert.Equal(t, 1, len(logger.matching("illegal option")))
+	assert.False(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, server.proxyTrustedSources))
+	server = newServer(TCP_SERVER, WithServerProxyProtocolTrustedSources())
+	assert.True(t, proxyProtocolTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, server.proxyTrustedSources))
+}
+
+func TestTCPServerProxyProtocol(t *testing.T) {
+	var serverMsgHandler MessageHandler
+	server := newServer(
+		TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerProxyProtocol(true),
+		WithServerProxyProtocolTrustedSources("127.0.0.1"),
+		WithServerProxyProtocolHeaderTimeout(time.Second),
+	)
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write([]byte("PROXY TCP4 10.1.2.3 127.0.0.1 4567 80\r\n"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return serverMsgHandler.SessionNumber() == 1 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "10.1.2.3:4567", serverMsgHandler.array[0].RemoteAddr())
+}
+
+func TestTCPServerProxyProtocolAdmission(t *testing.T) {
+	var serverMsgHandler MessageHandler
+	server := newServer(
+		TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerProxyProtocol(true),
+		WithServerProxyProtocolTrustedSources("127.0.0.1"),
+		WithServerDenyList("10.1.2.3/32"),
+	)
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+	defer server.Close()
+
+	// the connections are admitted by the addresses of the clients behind the proxy
+	denied, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = denied.Close() }()
+	_, err = denied.Write([]byte("PROXY TCP4 10.1.2.3 127.0.0.1 4567 80\r\n"))
+	assert.Nil(t, err)
+	_ = denied.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = denied.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	netErr, ok := err.(net.Error)
+	assert.False(t, ok && netErr.Timeout())
+
+	admitted, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	defer func() { _ = admitted.Close() }()
+	_, err = admitted.Write([]byte("PROXY TCP4 10.1.2.4 127.0.0.1 4567 80\r\n"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return serverMsgHandler.SessionNumber() == 1 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "10.1.2.4:4567", serverMsgHandler.array[0].RemoteAddr())
+}
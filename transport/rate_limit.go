@@ -0,0 +1,358 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+// ErrRateLimited is returned when a package exceeds the rate limit of a session or an endpoint
+var ErrRateLimited = perrors.New("rate limit exceeded")
+
+// Rate is a limit of bytes and packages per second, a non-positive field means unlimited. Bursts of
+// up to one second of the rate are allowed.
+type Rate struct {
+	Bytes int
+	Pkgs  int
+}
+
+// InboundRatePolicy decides what to do with the packages read beyond the rate limit
+type InboundRatePolicy int
+
+const (
+	// InboundRatePause stops reading until the tokens are refilled, so a tcp/ws peer is slowed down by
+	// the flow control of tcp
+	InboundRatePause InboundRatePolicy = iota
+	// InboundRateDrop drops the excess packages
+	InboundRateDrop
+	// InboundRateClose closes the session with ErrRateLimited
+	InboundRateClose
+)
+
+// OutboundRatePolicy decides what to do with the packages written beyond the rate limit
+type OutboundRatePolicy int
+
+const (
+	// OutboundRateDelay blocks the writer until the tokens are refilled
+	OutboundRateDelay OutboundRatePolicy = iota
+	// OutboundRateFail fails the write with ErrRateLimited
+	OutboundRateFail
+)
+
+// tokenBucket refills @rate tokens per second up to @rate tokens. A nil bucket is unlimited.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+}
+
+// take takes @n tokens if they are available. A request larger than the bucket is allowed when the
+// bucket is full, which leaves the bucket in debt.
+func (b *tokenBucket) take(n int, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(now)
+	if need := float64(n); b.tokens < need && b.tokens < b.rate {
+		return false
+	}
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// refund gives back the @n tokens taken by take.
+func (b *tokenBucket) refund(n int) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	b.tokens += float64(n)
+	b.lock.Unlock()
+}
+
+// reserve takes @n tokens in debt and returns how long it takes to pay the debt off.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits the bytes and the packages per second together.
+type rateLimiter struct {
+	bytes *tokenBucket
+	pkgs  *tokenBucket
+}
+
+func newRateLimiter(rate Rate) *rateLimiter {
+	if rate.Bytes <= 0 && rate.Pkgs <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{bytes: newTokenBucket(rate.Bytes), pkgs: newTokenBucket(rate.Pkgs)}
+}
+
+// refund returns the tokens of @bytes and @pkgs taken by allow or reserve.
+func (l *rateLimiter) refund(bytes, pkgs int) {
+	l.bytes.refund(bytes)
+	l.pkgs.refund(pkgs)
+}
+
+// allow takes the tokens of @bytes and @pkgs if both of them are available.
+func (l *rateLimiter) allow(bytes, pkgs int) bool {
+	now := time.Now()
+	if !l.bytes.take(bytes, now) {
+		return false
+	}
+	if !l.pkgs.take(pkgs, now) {
+		l.bytes.refund(bytes)
+		return false
+	}
+
+	return true
+}
+
+// reserve takes the tokens of @bytes and @pkgs in debt and returns how long the caller should wait.
+func (l *rateLimiter) reserve(bytes, pkgs int) time.Duration {
+	now := time.Now()
+	return max(l.bytes.reserve(bytes, now), l.pkgs.reserve(pkgs, now))
+}
+
+// readRateLimit is the read rate limit of a session or an endpoint
+type readRateLimit struct {
+	limiter *rateLimiter
+	policy  InboundRatePolicy
+}
+
+// writeRateLimit is the write rate limit of a session or an endpoint
+type writeRateLimit struct {
+	limiter *rateLimiter
+	policy  OutboundRatePolicy
+}
+
+func newReadRateLimit(rate Rate, policy InboundRatePolicy) *readRateLimit {
+	if policy < InboundRatePause || policy > InboundRateClose {
+		panic(fmt.Sprintf("illegal inbound rate policy %d", policy))
+	}
+	if limiter := newRateLimiter(rate); limiter != nil {
+		return &readRateLimit{limiter: limiter, policy: policy}
+	}
+
+	return nil
+}
+
+func newWriteRateLimit(rate Rate, policy OutboundRatePolicy) *writeRateLimit {
+	if policy < OutboundRateDelay || policy > OutboundRateFail {
+		panic(fmt.Sprintf("illegal outbound rate policy %d", policy))
+	}
+	if limiter := newRateLimiter(rate); limiter != nil {
+		return &writeRateLimit{limiter: limiter, policy: policy}
+	}
+
+	return nil
+}
+
+// waitTokens waits @wait or until the session is closed.
+func (s *session) waitTokens(wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	case <-timer.C:
+		return nil
+	}
+}
+
+// readRateLimits returns the read rate limits of the session and its endpoint.
+func (s *session) readRateLimits() [2]*readRateLimit {
+	var limits [2]*readRateLimit
+	s.lock.RLock()
+	limits[0] = s.readLimit
+	s.lock.RUnlock()
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		limits[1] = endPoint.readLimit
+	case *client:
+		limits[1] = endPoint.readLimit
+	}
+
+	return limits
+}
+
+// pausesReading reports whether the session waits for read tokens in its read loop.
+func (s *session) pausesReading() bool {
+	for _, limit := range s.readRateLimits() {
+		if limit != nil && limit.policy == InboundRatePause {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitRead applies the read rate limits to a package of @pkgLen bytes. It returns false if the package
+// should be dropped, and an error if the session should be closed. The tokens taken by the session limit
+// are returned if the package is rejected by the endpoint limit.
+func (s *session) limitRead(pkgLen int) (bool, error) {
+	limits := s.readRateLimits()
+	refund := func(i int) {
+		for _, limit := range limits[:i] {
+			if limit != nil {
+				limit.limiter.refund(pkgLen, 1)
+			}
+		}
+	}
+	for i, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		switch limit.policy {
+		case InboundRatePause:
+			if err := s.waitTokens(limit.limiter.reserve(pkgLen, 1)); err != nil {
+				return false, err
+			}
+		case InboundRateDrop:
+			if !limit.limiter.allow(pkgLen, 1) {
+				refund(i)
+				s.logger.Debugw("drop package beyond the read rate limit", "pkgLen", pkgLen)
+				return false, nil
+			}
+		case InboundRateClose:
+			if !limit.limiter.allow(pkgLen, 1) {
+				refund(i)
+				return false, perrors.Wrapf(ErrRateLimited, "read package(len:%d)", pkgLen)
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// limitWrite applies the write rate limits to @pkgNum packages of @length bytes.
+func (s *session) limitWrite(length, pkgNum int) error {
+	s.lock.RLock()
+	limits := [2]*writeRateLimit{s.writeLimit}
+	s.lock.RUnlock()
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		limits[1] = endPoint.writeLimit
+	case *client:
+		limits[1] = endPoint.writeLimit
+	}
+
+	for _, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		switch limit.policy {
+		case OutboundRateDelay:
+			if err := s.waitTokens(limit.limiter.reserve(length, pkgNum)); err != nil {
+				return err
+			}
+		case OutboundRateFail:
+			if !limit.limiter.allow(length, pkgNum) {
+				return perrors.Wrapf(ErrRateLimited, "write %d packages(len:%d)", pkgNum, length)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetReadRateLimit limits the packages read by the session to @rate, and the excess packages are handled by
+// @policy. The limit of the endpoint, if any, applies too. A zero @rate removes the limit of the session.
+// Waiting for the read tokens would block the other sessions of the poller, so a session served by the
+// reactor keeps its limit and logs an error if @policy is InboundRatePause.
+func (s *session) SetReadRateLimit(rate Rate, policy InboundRatePolicy) {
+	limit := newReadRateLimit(rate, policy)
+	s.lock.Lock()
+	refused := s.polled && limit != nil && limit.policy == InboundRatePause
+	if !refused {
+		s.readLimit = limit
+	}
+	s.lock.Unlock()
+	if refused {
+		s.logger.Errorw("[session.SetReadRateLimit] a session served by the reactor can not pause reading")
+	}
+}
+
+// setPolled marks whether the session is served by a poller of the reactor. It returns false if the session
+// can not be polled because it pauses reading by its read rate limit.
+func (s *session) setPolled(polled bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if polled && s.readLimit != nil && s.readLimit.policy == InboundRatePause {
+		return false
+	}
+	s.polled = polled
+
+	return true
+}
+
+// SetWriteRateLimit limits the packages written by the session to @rate, and the excess packages are handled
+// by @policy. The limit of the endpoint, if any, applies too. A zero @rate removes the limit of the session.
+func (s *session) SetWriteRateLimit(rate Rate, policy OutboundRatePolicy) {
+	limit := newWriteRateLimit(rate, policy)
+	s.lock.Lock()
+	s.writeLimit = limit
+	s.lock.Unlock()
+}
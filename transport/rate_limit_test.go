@@ -0,0 +1,201 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	assert.Nil(t, newTokenBucket(0))
+	assert.True(t, (*tokenBucket)(nil).take(100, time.Now()))
+	assert.Equal(t, time.Duration(0), (*tokenBucket)(nil).reserve(100, time.Now()))
+
+	now := time.Now()
+	b := newTokenBucket(10)
+	b.last = now
+	assert.True(t, b.take(6, now))
+	assert.False(t, b.take(6, now))
+	assert.True(t, b.take(6, now.Add(time.Second)))
+	// a request larger than the bucket passes when the bucket is full
+	assert.True(t, b.take(30, now.Add(3*time.Second)))
+	assert.InDelta(t, float64(-20), b.tokens, 0.001)
+	assert.Equal(t, 2*time.Second, b.reserve(0, now.Add(3*time.Second)))
+	assert.Equal(t, 2500*time.Millisecond, b.reserve(5, now.Add(3*time.Second)))
+
+	l := newRateLimiter(Rate{Bytes: 100, Pkgs: 2})
+	assert.True(t, l.allow(10, 1))
+	assert.True(t, l.allow(10, 1))
+	// the bytes taken are refunded if the packages are not available
+	assert.False(t, l.allow(10, 1))
+	assert.InDelta(t, float64(80), l.bytes.tokens, 1)
+	assert.Nil(t, newRateLimiter(Rate{}))
+	assert.Panics(t, func() { newReadRateLimit(Rate{Pkgs: 1}, InboundRatePolicy(100)) })
+}
+
+// rateLimitPair connects a client to a tcp server whose sessions are set up by @setup
+func rateLimitPair(t *testing.T, handler *lineHandler, setup func(Session), opts ...ServerOption) (Server, Client, Session) {
+	server := newServer(TCP_SERVER, append([]ServerOption{WithLocalAddress("127.0.0.1:0")}, opts...)...)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(handler)
+		session.SetEventListener(handler)
+		if setup != nil {
+			setup(session)
+		}
+		return nil
+	})
+
+	var clientHandler lineHandler
+	sessions := make(chan Session, 1)
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&clientHandler)
+		session.SetEventListener(&clientHandler)
+		sessions <- session
+		return nil
+	})
+
+	return server, client, <-sessions
+}
+
+func TestReadRateLimit(t *testing.T) {
+	lines := strings.Repeat("line\n", 10)
+
+	var dropHandler lineHandler
+	server, client, ss := rateLimitPair(t, &dropHandler, func(session Session) {
+		session.SetReadRateLimit(Rate{Pkgs: 3}, InboundRateDrop)
+	})
+	_, err := ss.WriteBytes([]byte(lines))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := dropHandler.snapshot()
+		return len(got) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	got, closed := dropHandler.snapshot()
+	assert.Equal(t, 3, len(got))
+	assert.Equal(t, 0, closed)
+	client.Close()
+	server.Close()
+
+	var closeHandler lineHandler
+	server, client, ss = rateLimitPair(t, &closeHandler, nil,
+		WithServerReadRateLimit(Rate{Bytes: 20}, InboundRateClose))
+	_, err = ss.WriteBytes([]byte(lines))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, closed := closeHandler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ = closeHandler.snapshot()
+	assert.Equal(t, 4, len(got))
+	client.Close()
+	server.Close()
+
+	var pauseHandler lineHandler
+	server, client, ss = rateLimitPair(t, &pauseHandler, func(session Session) {
+		session.SetReadRateLimit(Rate{Pkgs: 20}, InboundRatePause)
+	})
+	defer server.Close()
+	defer client.Close()
+	start := time.Now()
+	_, err = ss.WriteBytes([]byte(strings.Repeat("line\n", 25)))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := pauseHandler.snapshot()
+		return len(got) == 25
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	_, closed = pauseHandler.snapshot()
+	assert.Equal(t, 0, closed)
+}
+
+func TestReadRateLimitRefund(t *testing.T) {
+	var handler lineHandler
+	sessions := make(chan Session, 1)
+	server, client, ss := rateLimitPair(t, &handler, func(session Session) {
+		session.SetReadRateLimit(Rate{Pkgs: 5}, InboundRateDrop)
+		sessions <- session
+	}, WithServerReadRateLimit(Rate{Pkgs: 2}, InboundRateDrop))
+	defer server.Close()
+	defer client.Close()
+	serverSession := (<-sessions).(*session)
+
+	_, err := ss.WriteBytes([]byte(strings.Repeat("line\n", 10)))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	got, _ := handler.snapshot()
+	assert.Equal(t, 2, len(got))
+
+	// the session limit is only charged for the packages passing the limit of the server
+	bucket := serverSession.readRateLimits()[0].limiter.pkgs
+	bucket.lock.Lock()
+	tokens := bucket.tokens
+	bucket.lock.Unlock()
+	assert.InDelta(t, float64(3), tokens, 1)
+}
+
+func TestWriteRateLimit(t *testing.T) {
+	var handler lineHandler
+	server, client, ss := rateLimitPair(t, &handler, nil)
+	defer server.Close()
+	defer client.Close()
+
+	ss.SetWriteRateLimit(Rate{Pkgs: 2}, OutboundRateFail)
+	for i := 0; i < 2; i++ {
+		_, _, err := ss.WritePkg("line", 0)
+		assert.Nil(t, err)
+	}
+	_, _, err := ss.WritePkg("line", 0)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = ss.WriteBytesArray([]byte("a\n"), []byte("b\n"))
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	ss.SetWriteRateLimit(Rate{Pkgs: 10}, OutboundRateDelay)
+	start := time.Now()
+	for i := 0; i < 13; i++ {
+		_, _, err = ss.WritePkg("line", 0)
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) >= 250*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 15
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// a closed session stops waiting
+	ss.SetWriteRateLimit(Rate{Pkgs: 1}, OutboundRateDelay)
+	_, err = ss.WriteBytes([]byte("line\n"))
+	assert.Nil(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ss.Close()
+	}()
+	_, err = ss.WriteBytes([]byte("line\n"))
+	assert.ErrorIs(t, err, ErrSessionClosed)
+}
@@ -0,0 +1,341 @@
+//go:build linux
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+import (
+	gxbytes "github.com/dubbogo/gost/bytes"
+
+	perrors "github.com/pkg/errors"
+
+	"golang.org/x/sys/unix"
+)
+
+import (
+	log "github.com/AlexStocks/getty/util"
+)
+
+const (
+	reactorSupported = true
+
+	reactorEventNum = 256
+	// epoll_wait timeout in milliseconds, the poller checks whether it has been closed in this period
+	reactorWaitTimeout = 100
+	// maximum read times of a readable connection in one round, so that a busy connection
+	// can not starve the others served by the same poller
+	reactorMaxReadTimes = 16
+)
+
+// reactor dispatches readable tcp connections to a few poller goroutines instead of running
+// a read goroutine for every session.
+type reactor struct {
+	pollers []*poller
+	next    uint32
+}
+
+// reactorConn is a session registered in a poller. Its socket is only accessed by rawConn, so that
+// the fd can not be closed and reused by another connection during a read or an epoll_ctl.
+type reactorConn struct {
+	id      int32 // the key of the connection in the poller and the data of its epoll events
+	ss      *session
+	conn    *gettyTCPConn
+	rawConn syscall.RawConn
+	buf     *gxbytes.Buffer // unconsumed tcp stream, it is nil if the stream has been consumed completely
+}
+
+type poller struct {
+	epfd   int
+	lock   sync.Mutex
+	conns  map[int32]*reactorConn
+	nextID int32
+	buf    []byte // read buffer shared by all of the connections of the poller
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func newReactor(pollerNum int) (*reactor, error) {
+	r := &reactor{}
+	for i := 0; i < pollerNum; i++ {
+		epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+		if err != nil {
+			r.close()
+			return nil, perrors.Wrap(err, "unix.EpollCreate1")
+		}
+		p := &poller{
+			epfd:  epfd,
+			conns: make(map[int32]*reactorConn),
+			buf:   make([]byte, maxReadBufLen),
+			done:  make(chan struct{}),
+		}
+		p.wg.Add(1)
+		go p.loop()
+		r.pollers = append(r.pollers, p)
+	}
+
+	return r, nil
+}
+
+// register adds @ss to a poller. The session should be served by its own read goroutine if it
+// returns error, e.g. the session runs over tls or a compress type has been set.
+func (r *reactor) register(ss *session) error {
+	tcpConn, ok := ss.Connection.(*gettyTCPConn)
+	if !ok {
+		return perrors.Errorf("session %s is not a tcp session", ss.sessionToken())
+	}
+	if tcpConn.compress != CompressNone || ss.reader == nil {
+		return perrors.Errorf("session %s uses compression or has no reader", ss.sessionToken())
+	}
+	// the pollers share their read buffers which can not be referenced by the packages
+	if _, ok = ss.reader.(ZeroCopyReader); ok {
+		return perrors.Errorf("session %s uses a ZeroCopyReader", ss.sessionToken())
+	}
+	netConn, ok := tcpConn.conn.(*net.TCPConn)
+	if !ok {
+		return perrors.Errorf("session %s conn type %T is not *net.TCPConn", ss.sessionToken(), tcpConn.conn)
+	}
+	rawConn, err := netConn.SyscallConn()
+	if err != nil {
+		return perrors.WithStack(err)
+	}
+
+	// waiting for the read tokens would block the other sessions of the poller, and SetReadRateLimit
+	// refuses the InboundRatePause policy once the session is polled
+	if ss.pausesReading() || !ss.setPolled(true) {
+		return perrors.Errorf("session %s pauses reading by its read rate limit", ss.sessionToken())
+	}
+	p := r.pollers[atomic.AddUint32(&r.next, 1)%uint32(len(r.pollers))]
+	if err = p.add(&reactorConn{ss: ss, conn: tcpConn, rawConn: rawConn}); err != nil {
+		ss.setPolled(false)
+		return err
+	}
+
+	return nil
+}
+
+func (r *reactor) close() {
+	for _, p := range r.pollers {
+		p.close()
+	}
+}
+
+func (p *poller) add(rc *reactorConn) error {
+	p.lock.Lock()
+	for {
+		p.nextID++
+		if p.nextID < 0 {
+			p.nextID = 0
+		}
+		if _, ok := p.conns[p.nextID]; !ok {
+			break
+		}
+	}
+	rc.id = p.nextID
+	p.conns[rc.id] = rc
+	p.lock.Unlock()
+
+	// the session is detached when it is closed by the application. finishRead closes the connection, so
+	// the socket is always deregistered before it is closed.
+	rc.ss.AddCloseCallback(p, rc.id, func() {
+		if p.remove(rc) {
+			rc.ss.finishRead(nil)
+		}
+	})
+
+	var opErr error
+	err := rc.rawConn.Control(func(fd uintptr) {
+		event := &unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLRDHUP, Fd: rc.id}
+		opErr = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, int(fd), event)
+	})
+	if err == nil {
+		err = opErr
+	}
+	if err != nil {
+		p.remove(rc)
+		rc.ss.RemoveCloseCallback(p, rc.id)
+		return perrors.Wrap(err, "unix.EpollCtl(ADD)")
+	}
+
+	return nil
+}
+
+// remove detaches @rc from the poller. Only the caller that gets true should finish the session.
+func (p *poller) remove(rc *reactorConn) bool {
+	p.lock.Lock()
+	if p.conns[rc.id] != rc {
+		p.lock.Unlock()
+		return false
+	}
+	delete(p.conns, rc.id)
+	p.lock.Unlock()
+
+	// a closed socket has been removed from the epoll instance by the kernel
+	_ = rc.rawConn.Control(func(fd uintptr) {
+		_ = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, int(fd), nil)
+	})
+	return true
+}
+
+func (p *poller) close() {
+	p.once.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		_ = unix.Close(p.epfd)
+	})
+}
+
+func (p *poller) loop() {
+	defer p.wg.Done()
+
+	events := make([]unix.EpollEvent, reactorEventNum)
+	for {
+		select {
+		case <-p.done:
+			p.closeConns()
+			return
+		default:
+		}
+
+		n, err := unix.EpollWait(p.epfd, events, reactorWaitTimeout)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			log.Errorf("[poller.loop] unix.EpollWait(epfd:%d) = error:%+v", p.epfd, err)
+			p.closeConns()
+			return
+		}
+		for i := 0; i < n; i++ {
+			p.lock.Lock()
+			rc := p.conns[events[i].Fd]
+			p.lock.Unlock()
+			if rc == nil {
+				continue
+			}
+			if err = p.read(rc); err != nil || rc.ss.IsClosed() {
+				if err == io.EOF {
+					err = nil
+				}
+				if p.remove(rc) {
+					rc.ss.finishRead(err)
+				}
+			}
+		}
+	}
+}
+
+// closeConns closes the sessions that are still served by the poller when the server is closed.
+func (p *poller) closeConns() {
+	p.lock.Lock()
+	conns := make([]*reactorConn, 0, len(p.conns))
+	for _, rc := range p.conns {
+		conns = append(conns, rc)
+	}
+	p.lock.Unlock()
+
+	for _, rc := range conns {
+		if p.remove(rc) {
+			rc.ss.finishRead(nil)
+		}
+	}
+}
+
+// read reads the readable connection until EAGAIN and dispatches the unmarshalled packages.
+// It returns io.EOF when the peer closes the connection.
+func (p *poller) read(rc *reactorConn) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			rBuf := make([]byte, size)
+			rBuf = rBuf[:runtime.Stack(rBuf, false)]
+			rc.ss.logger.Errorf("[poller.read] panic err=%s\n%s", r, rBuf)
+			err = perrors.WithStack(fmt.Errorf("[poller.read] panic: %v", r))
+		}
+	}()
+
+	for i := 0; i < reactorMaxReadTimes; i++ {
+		var (
+			n    int
+			rerr error
+		)
+		// the fd is valid in the callback even if the session is closed concurrently
+		err = rc.rawConn.Read(func(fd uintptr) bool {
+			n, rerr = unix.Read(int(fd), p.buf)
+			return true
+		})
+		if err != nil {
+			return perrors.WithStack(err)
+		}
+		if rerr == unix.EINTR {
+			continue
+		}
+		if rerr == unix.EAGAIN {
+			return nil
+		}
+		if rerr != nil {
+			return classifyReadError(perrors.Wrap(rerr, "unix.Read"))
+		}
+		if n == 0 {
+			rc.ss.logger.Infow("session.conn read EOF, client send over, session exit")
+			return io.EOF
+		}
+		rc.conn.addReadBytes(n)
+		rc.ss.recorder.stream(p.buf[:n])
+
+		data := p.buf[:n]
+		if rc.buf != nil {
+			if _, werr := rc.buf.Write(data); werr != nil {
+				return perrors.WithStack(werr)
+			}
+			data = rc.buf.Bytes()
+		}
+		consumed, perr := rc.ss.parseTCPPackages(data, nil)
+		if perr != nil {
+			return perr
+		}
+		switch {
+		case rc.buf != nil:
+			rc.buf.Next(consumed)
+			if rc.buf.Len() == 0 {
+				rc.buf = nil
+			}
+		case consumed < len(data):
+			// keep the partial package, p.buf will be overwritten by the next read
+			rc.buf = gxbytes.NewBuffer(nil)
+			if _, werr := rc.buf.Write(data[consumed:]); werr != nil {
+				return perrors.WithStack(werr)
+			}
+		}
+		if n < len(p.buf) {
+			return nil
+		}
+	}
+
+	return nil
+}
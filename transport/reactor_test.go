@@ -0,0 +1,166 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// lineHandler unmarshals '\n' terminated lines
+type lineHandler struct {
+	lock   sync.Mutex
+	lines  []string
+	closed int
+}
+
+func (h *lineHandler) Read(_ Session, data []byte) (any, int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		return nil, 0, nil
+	}
+	return string(data[:idx]), idx + 1, nil
+}
+
+func (h *lineHandler) Write(_ Session, pkg any) ([]byte, error) {
+	return []byte(pkg.(string) + "\n"), nil
+}
+
+func (h *lineHandler) OnOpen(Session) error   { return nil }
+func (h *lineHandler) OnError(Session, error) {}
+func (h *lineHandler) OnCron(Session)         {}
+func (h *lineHandler) OnMessage(_ Session, pkg any) {
+	h.lock.Lock()
+	h.lines = append(h.lines, pkg.(string))
+	h.lock.Unlock()
+}
+
+func (h *lineHandler) OnClose(Session) {
+	h.lock.Lock()
+	h.closed++
+	h.lock.Unlock()
+}
+
+func (h *lineHandler) snapshot() ([]string, int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]string(nil), h.lines...), h.closed
+}
+
+func TestReactorServer(t *testing.T) {
+	if !reactorSupported {
+		t.Skip("reactor mode is not supported")
+	}
+
+	var (
+		handler  lineHandler
+		lock     sync.Mutex
+		sessions []Session
+	)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerReactor(2))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		lock.Lock()
+		sessions = append(sessions, session)
+		lock.Unlock()
+		return nil
+	})
+	defer server.Close()
+	assert.NotNil(t, server.reactor)
+
+	conn, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("hello\nwor"))
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.Write([]byte("ld\n"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		lines, _ := handler.snapshot()
+		return len(lines) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	lines, _ := handler.snapshot()
+	assert.Equal(t, []string{"hello", "world"}, lines)
+	lock.Lock()
+	assert.Equal(t, int32(0), sessions[0].(*session).grNum.Load())
+	lock.Unlock()
+
+	// closed by the peer
+	_ = conn.Close()
+	assert.Eventually(t, func() bool {
+		_, closed := handler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// closed by the application
+	conn, err = net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(sessions) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	sessions[1].Close()
+	lock.Unlock()
+	assert.Eventually(t, func() bool {
+		_, closed := handler.snapshot()
+		return closed == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestReactorRefusesReadPause(t *testing.T) {
+	if !reactorSupported {
+		t.Skip("reactor mode is not supported")
+	}
+
+	var handler lineHandler
+	srv, client, clientSession := rateLimitPair(t, &handler, nil, WithServerReactor(1))
+	defer srv.Close()
+	defer client.Close()
+	_, err := clientSession.WriteBytes([]byte("hello\n"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		got, _ := handler.snapshot()
+		return len(got) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	var polled *session
+	srv.(*server).lock.Lock()
+	for ss := range srv.(*server).sessions {
+		polled = ss.(*session)
+	}
+	srv.(*server).lock.Unlock()
+	assert.Equal(t, int32(0), polled.grNum.Load())
+
+	// pausing would block the poller, so the policy is refused while the others are taken
+	polled.SetReadRateLimit(Rate{Pkgs: 1}, InboundRatePause)
+	assert.Nil(t, polled.readRateLimits()[0])
+	polled.SetReadRateLimit(Rate{Pkgs: 1}, InboundRateDrop)
+	assert.NotNil(t, polled.readRateLimits()[0])
+}
@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	gxbytes "github.com/dubbogo/gost/bytes"
+
+	uatomic "go.uber.org/atomic"
+)
+
+const (
+	minReadBufLen = 512
+	// the largest slot of the default gxbytes pool
+	maxAdaptiveReadBufLen = 64 * 1024
+	// the read buffer shrinks after so many consecutive reads that use less than a quarter of it
+	readBufShrinkTimes = 16
+)
+
+// ReadBuffer is a reference counted buffer acquired from the getty buffer pool. It is returned to
+// the pool when its last reference is released, so it must not be used after calling Release.
+type ReadBuffer struct {
+	bufp *[]byte
+	buf  []byte
+	refs uatomic.Int32
+}
+
+func newReadBuffer(size int) *ReadBuffer {
+	bufp := gxbytes.AcquireBytes(size)
+	b := &ReadBuffer{bufp: bufp, buf: (*bufp)[:cap(*bufp)]}
+	b.refs.Store(1)
+
+	return b
+}
+
+// Bytes get the whole underlying buffer
+func (b *ReadBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Retain adds a reference to the buffer
+func (b *ReadBuffer) Retain() {
+	b.refs.Add(1)
+}
+
+// Release drops a reference of the buffer and returns it to the pool if it is the last one
+func (b *ReadBuffer) Release() {
+	refs := b.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("getty: ReadBuffer is released more times than it is retained")
+	}
+	gxbytes.ReleaseBytes(b.bufp)
+	b.bufp, b.buf = nil, nil
+}
+
+// readBufSizer adapts the read buffer size to the traffic of a connection: it doubles the size when a
+// read fills the buffer and halves it after a series of small reads.
+type readBufSizer struct {
+	size  int
+	small int
+}
+
+func newReadBufSizer() readBufSizer {
+	return readBufSizer{size: maxReadBufLen}
+}
+
+func (z *readBufSizer) update(n, capacity int) {
+	switch {
+	case n >= capacity && z.size < maxAdaptiveReadBufLen:
+		z.size <<= 1
+		z.small = 0
+	case n <= z.size>>2 && z.size > minReadBufLen:
+		z.small++
+		if z.small >= readBufShrinkTimes {
+			z.size >>= 1
+			z.small = 0
+		}
+	default:
+		z.small = 0
+	}
+}
+
+// streamBuffer holds the unconsumed tcp stream in pooled buffers. Consumed bytes are never overwritten
+// while a package decoded by a ZeroCopyReader still references them.
+type streamBuffer struct {
+	rb    *ReadBuffer
+	start int
+	end   int
+	sizer readBufSizer
+}
+
+func newStreamBuffer() *streamBuffer {
+	return &streamBuffer{sizer: newReadBufSizer()}
+}
+
+// writeNextBegin returns the free space to read the stream into.
+func (b *streamBuffer) writeNextBegin() []byte {
+	tail := b.end - b.start
+	switch {
+	case b.rb == nil:
+		b.rb = newReadBuffer(b.sizer.size)
+	case len(b.rb.buf)-b.end >= b.sizer.size:
+	case b.rb.refs.Load() == 1 && len(b.rb.buf)-tail >= b.sizer.size:
+		// nobody else references the buffer, move the unconsumed stream to its head
+		copy(b.rb.buf, b.rb.buf[b.start:b.end])
+		b.start, b.end = 0, tail
+	default:
+		rb := newReadBuffer(tail + b.sizer.size)
+		copy(rb.buf, b.rb.buf[b.start:b.end])
+		b.rb.Release()
+		b.rb = rb
+		b.start, b.end = 0, tail
+	}
+
+	return b.rb.buf[b.end : b.end+b.sizer.size]
+}
+
+func (b *streamBuffer) writeNextEnd(n int) {
+	b.sizer.update(n, b.sizer.size)
+	b.end += n
+}
+
+func (b *streamBuffer) bytes() []byte {
+	return b.rb.buf[b.start:b.end]
+}
+
+func (b *streamBuffer) next(n int) {
+	b.start += n
+}
+
+func (b *streamBuffer) release() {
+	if b.rb != nil {
+		b.rb.Release()
+		b.rb = nil
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// zeroCopyLine references a line in a ReadBuffer
+type zeroCopyLine struct {
+	data []byte
+	buf  *ReadBuffer
+}
+
+// zeroCopyLineHandler unmarshals '\n' terminated lines without copying them and keeps them after OnMessage
+type zeroCopyLineHandler struct {
+	lineHandler
+	lock sync.Mutex
+	pkgs []zeroCopyLine
+}
+
+func (h *zeroCopyLineHandler) Read(Session, []byte) (any, int, error) {
+	panic("Read should not be called")
+}
+
+func (h *zeroCopyLineHandler) ReadZeroCopy(_ Session, data []byte, buf *ReadBuffer) (any, int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		return nil, 0, nil
+	}
+	buf.Retain()
+	return zeroCopyLine{data: data[:idx], buf: buf}, idx + 1, nil
+}
+
+func (h *zeroCopyLineHandler) OnMessage(_ Session, pkg any) {
+	h.lock.Lock()
+	h.pkgs = append(h.pkgs, pkg.(zeroCopyLine))
+	h.lock.Unlock()
+}
+
+func (h *zeroCopyLineHandler) lines() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	lines := make([]string, 0, len(h.pkgs))
+	for _, pkg := range h.pkgs {
+		lines = append(lines, string(pkg.data))
+	}
+	return lines
+}
+
+func TestReadBuffer(t *testing.T) {
+	rb := newReadBuffer(100)
+	assert.Equal(t, 512, len(rb.Bytes()))
+	rb.Retain()
+	rb.Release()
+	assert.NotNil(t, rb.Bytes())
+	rb.Release()
+	assert.Nil(t, rb.Bytes())
+	assert.Panics(t, rb.Release)
+}
+
+func TestReadBufSizer(t *testing.T) {
+	z := newReadBufSizer()
+	for i := 0; i < 10; i++ {
+		z.update(z.size, z.size)
+	}
+	assert.Equal(t, maxAdaptiveReadBufLen, z.size)
+
+	for i := 0; i < readBufShrinkTimes-1; i++ {
+		z.update(1, z.size)
+	}
+	assert.Equal(t, maxAdaptiveReadBufLen, z.size)
+	z.update(1, z.size)
+	assert.Equal(t, maxAdaptiveReadBufLen>>1, z.size)
+
+	for i := 0; i < 100*readBufShrinkTimes; i++ {
+		z.update(1, z.size)
+	}
+	assert.Equal(t, minReadBufLen, z.size)
+}
+
+func TestStreamBuffer(t *testing.T) {
+	b := newStreamBuffer()
+	defer b.release()
+
+	buf := b.writeNextBegin()
+	n := copy(buf, "hello\nwor")
+	b.writeNextEnd(n)
+	assert.Equal(t, "hello\nwor", string(b.bytes()))
+	line := b.bytes()[:5]
+	b.rb.Retain()
+	held := b.rb
+	b.next(6)
+
+	// fill the buffer, the retained line must not be overwritten
+	for i := 0; i < 4; i++ {
+		buf = b.writeNextBegin()
+		for j := range buf {
+			buf[j] = 'x'
+		}
+		b.writeNextEnd(len(buf))
+	}
+	assert.Equal(t, "hello", string(line))
+	assert.True(t, strings.HasPrefix(string(b.bytes()), "worxxx"))
+	held.Release()
+
+	b.next(len(b.bytes()))
+	buf = b.writeNextBegin()
+	assert.Equal(t, 0, b.start)
+	assert.Equal(t, b.sizer.size, len(buf))
+}
+
+func TestZeroCopyTCPReader(t *testing.T) {
+	var handler zeroCopyLineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetMaxMsgLen(128 * 1024)
+		return nil
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.addr)
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+
+	var expected []string
+	for i := 0; i < 64; i++ {
+		line := strings.Repeat(string(rune('a'+i%26)), i*97)
+		expected = append(expected, line)
+		_, err = conn.Write([]byte(line + "\n"))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return len(handler.lines()) == len(expected)
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, handler.lines())
+
+	handler.lock.Lock()
+	for _, pkg := range handler.pkgs {
+		pkg.buf.Release()
+	}
+	handler.lock.Unlock()
+}
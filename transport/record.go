@@ -0,0 +1,624 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	uatomic "go.uber.org/atomic"
+)
+
+// The recording file starts with a header and is followed by the records:
+//
+//	header: magic "GETTYREC"(8 bytes) | version(1 byte) | start time in unix nanoseconds(8 bytes)
+//	record: kind(1 byte) | session id(uvarint) | nanoseconds since the previous record(varint) | body
+//
+// The body of RecordOpen is the endpoint type(uvarint) and the local and the remote address, the body of
+// RecordInbound and RecordOutbound is the data, every string or []byte is prefixed by its length(uvarint).
+// RecordClose has no body.
+const (
+	recordMagic   = "GETTYREC"
+	recordVersion = 1
+
+	// maxRecordBytesLen is the max length of a string or []byte of a record, as long as the max message
+	// length of a session
+	maxRecordBytesLen = math.MaxInt32
+)
+
+// ErrRecordInvalid is returned when a recording can not be parsed
+var ErrRecordInvalid = perrors.New("invalid recording")
+
+// RecordKind is the kind of a record
+type RecordKind byte
+
+const (
+	// RecordOpen is recorded when a session opens
+	RecordOpen RecordKind = iota + 1
+	// RecordInbound is the bytes read by a session
+	RecordInbound
+	// RecordOutbound is the bytes written by a session
+	RecordOutbound
+	// RecordClose is recorded when a session closes
+	RecordClose
+)
+
+func (k RecordKind) String() string {
+	switch k {
+	case RecordOpen:
+		return "open"
+	case RecordInbound:
+		return "inbound"
+	case RecordOutbound:
+		return "outbound"
+	case RecordClose:
+		return "close"
+	}
+
+	return fmt.Sprintf("RecordKind(%d)", k)
+}
+
+// RecordMode decides what is recorded as the inbound bytes of a tcp session. Every websocket message and
+// every udp datagram is a record in either mode.
+type RecordMode int
+
+const (
+	// RecordStream records the stream as it is read, one record per read
+	RecordStream RecordMode = iota
+	// RecordPackages records the frame of every package decoded by the Reader
+	RecordPackages
+)
+
+// Record is a record of a recording
+type Record struct {
+	Kind      RecordKind
+	Time      time.Time
+	SessionID uint32
+	// the session of RecordOpen
+	EndPointType EndPointType
+	LocalAddr    string
+	RemoteAddr   string
+	// the bytes of RecordInbound and RecordOutbound
+	Data []byte
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*Recorder)
+
+// WithRecordMode @mode decides whether the stream or the package frames of a tcp session are recorded,
+// RecordStream by default.
+func WithRecordMode(mode RecordMode) RecorderOption {
+	if mode != RecordStream && mode != RecordPackages {
+		panic(fmt.Sprintf("illegal record mode %d", mode))
+	}
+
+	return func(r *Recorder) {
+		r.mode = mode
+	}
+}
+
+// WithRecordMaxBytes the recorder stops recording when the recording reaches @maxBytes.
+func WithRecordMaxBytes(maxBytes int64) RecorderOption {
+	if maxBytes <= 0 {
+		panic(fmt.Sprintf("illegal record max bytes %d", maxBytes))
+	}
+
+	return func(r *Recorder) {
+		r.maxBytes = maxBytes
+	}
+}
+
+// WithRecordSessionMaxBytes the bytes of a session are not recorded any more when it has recorded
+// @maxBytes inbound and outbound bytes.
+func WithRecordSessionMaxBytes(maxBytes int) RecorderOption {
+	if maxBytes <= 0 {
+		panic(fmt.Sprintf("illegal record session max bytes %d", maxBytes))
+	}
+
+	return func(r *Recorder) {
+		r.sessionMaxBytes = maxBytes
+	}
+}
+
+// WithRecordSampleRate only a random @rate of the sessions are recorded, @rate should be in (0, 1].
+func WithRecordSampleRate(rate float64) RecorderOption {
+	if rate <= 0 || rate > 1 {
+		panic(fmt.Sprintf("illegal record sample rate %v", rate))
+	}
+
+	return func(r *Recorder) {
+		r.sampleRate = rate
+	}
+}
+
+// Recorder records the traffic of the sessions of the endpoints configured by WithServerRecorder or
+// WithClientRecorder, it is safe for concurrent use.
+type Recorder struct {
+	mode            RecordMode
+	maxBytes        int64
+	sessionMaxBytes int
+	sampleRate      float64
+
+	lock    sync.Mutex
+	w       *bufio.Writer
+	closer  io.Closer
+	rnd     *rand.Rand
+	last    time.Time
+	written int64
+	full    bool
+	err     error
+	buf     []byte
+	dropped uatomic.Uint64
+}
+
+// NewRecorder returns a recorder writing the recording to @w.
+func NewRecorder(w io.Writer, opts ...RecorderOption) (*Recorder, error) {
+	r := &Recorder{
+		sampleRate: 1,
+		w:          bufio.NewWriter(w),
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		last:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	header := make([]byte, 0, len(recordMagic)+9)
+	header = append(header, recordMagic...)
+	header = append(header, recordVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(r.last.UnixNano()))
+	if _, err := r.w.Write(header); err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	r.written = int64(len(header))
+
+	return r, nil
+}
+
+// NewFileRecorder returns a recorder writing the recording to the file @path, the file is closed by Close.
+func NewFileRecorder(path string, opts ...RecorderOption) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, perrors.WithStack(err)
+	}
+	r, err := NewRecorder(file, opts...)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	r.closer = file
+
+	return r, nil
+}
+
+// Dropped returns the number of the records dropped by the size limits.
+func (r *Recorder) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Flush writes the buffered records.
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err == nil {
+		r.err = perrors.WithStack(r.w.Flush())
+	}
+
+	return r.err
+}
+
+// Close flushes the recorder and closes the file of NewFileRecorder. The records after Close are dropped.
+func (r *Recorder) Close() error {
+	err := r.Flush()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.full = true
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = perrors.WithStack(cerr)
+		}
+		r.closer = nil
+	}
+
+	return err
+}
+
+// write encodes and writes a record, the body is appended by @body.
+func (r *Recorder) write(kind RecordKind, id uint32, body func([]byte) []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.full || r.err != nil {
+		r.dropped.Inc()
+		return
+	}
+
+	now := time.Now()
+	buf := append(r.buf[:0], byte(kind))
+	buf = binary.AppendUvarint(buf, uint64(id))
+	buf = binary.AppendVarint(buf, int64(now.Sub(r.last)))
+	buf = body(buf)
+	r.buf = buf
+	if r.maxBytes > 0 && r.written+int64(len(buf)) > r.maxBytes {
+		r.full = true
+		r.dropped.Inc()
+		return
+	}
+	if _, r.err = r.w.Write(buf); r.err != nil {
+		r.err = perrors.WithStack(r.err)
+		r.dropped.Inc()
+		return
+	}
+	r.last = now
+	r.written += int64(len(buf))
+}
+
+// open starts recording @ss, it returns nil if @ss is not sampled.
+func (r *Recorder) open(ss Session) *sessionRecorder {
+	r.lock.Lock()
+	sampled := r.sampleRate >= 1 || r.rnd.Float64() < r.sampleRate
+	r.lock.Unlock()
+	if !sampled {
+		return nil
+	}
+
+	sr := &sessionRecorder{r: r, id: ss.ID()}
+	r.write(RecordOpen, sr.id, func(buf []byte) []byte {
+		buf = binary.AppendUvarint(buf, uint64(ss.EndPoint().EndPointType()))
+		buf = appendRecordBytes(buf, []byte(ss.LocalAddr()))
+		return appendRecordBytes(buf, []byte(ss.RemoteAddr()))
+	})
+
+	return sr
+}
+
+func appendRecordBytes(buf []byte, data ...[]byte) []byte {
+	n := 0
+	for _, d := range data {
+		n += len(d)
+	}
+	buf = binary.AppendUvarint(buf, uint64(n))
+	for _, d := range data {
+		buf = append(buf, d...)
+	}
+
+	return buf
+}
+
+// sessionRecorder records the traffic of a session
+type sessionRecorder struct {
+	r     *Recorder
+	id    uint32
+	bytes uatomic.Int64
+}
+
+// record records the @data of a read or a write.
+func (sr *sessionRecorder) record(kind RecordKind, data ...[]byte) {
+	n := 0
+	for _, d := range data {
+		n += len(d)
+	}
+	if n == 0 {
+		return
+	}
+	if limit := sr.r.sessionMaxBytes; limit > 0 && sr.bytes.Add(int64(n)) > int64(limit) {
+		sr.r.dropped.Inc()
+		return
+	}
+	sr.r.write(kind, sr.id, func(buf []byte) []byte {
+		return appendRecordBytes(buf, data...)
+	})
+}
+
+// stream records a read of a tcp stream in the RecordStream mode.
+func (sr *sessionRecorder) stream(data []byte) {
+	if sr != nil && sr.r.mode == RecordStream {
+		sr.record(RecordInbound, data)
+	}
+}
+
+// frame records the frame of a package decoded from a tcp stream in the RecordPackages mode.
+func (sr *sessionRecorder) frame(data []byte) {
+	if sr != nil && sr.r.mode == RecordPackages {
+		sr.record(RecordInbound, data)
+	}
+}
+
+// message records a websocket message or an udp datagram.
+func (sr *sessionRecorder) message(data []byte) {
+	if sr != nil {
+		sr.record(RecordInbound, data)
+	}
+}
+
+// write records the bytes written by the session.
+func (sr *sessionRecorder) write(data ...[]byte) {
+	if sr != nil {
+		sr.record(RecordOutbound, data...)
+	}
+}
+
+func (sr *sessionRecorder) close() {
+	if sr != nil {
+		sr.r.write(RecordClose, sr.id, func(buf []byte) []byte { return buf })
+	}
+}
+
+// RecordReader reads the records of a recording
+type RecordReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+// NewRecordReader returns a reader of the recording @r.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(recordMagic)+9)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "header: %v", err)
+	}
+	if string(header[:len(recordMagic)]) != recordMagic {
+		return nil, perrors.Wrap(ErrRecordInvalid, "magic")
+	}
+	if version := header[len(recordMagic)]; version != recordVersion {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "version %d", version)
+	}
+
+	return &RecordReader{
+		r:    br,
+		last: time.Unix(0, int64(binary.BigEndian.Uint64(header[len(recordMagic)+1:]))),
+	}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the recording.
+func (rr *RecordReader) Next() (*Record, error) {
+	kind, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	id, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "session id: %v", err)
+	}
+	delta, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "time: %v", err)
+	}
+	rr.last = rr.last.Add(time.Duration(delta))
+	record := &Record{Kind: RecordKind(kind), Time: rr.last, SessionID: uint32(id)}
+
+	switch record.Kind {
+	case RecordOpen:
+		var typ uint64
+		if typ, err = binary.ReadUvarint(rr.r); err != nil {
+			return nil, perrors.Wrapf(ErrRecordInvalid, "endpoint type: %v", err)
+		}
+		record.EndPointType = EndPointType(typ)
+		var local, remote []byte
+		if local, err = rr.readBytes(); err == nil {
+			remote, err = rr.readBytes()
+		}
+		record.LocalAddr, record.RemoteAddr = string(local), string(remote)
+	case RecordInbound, RecordOutbound:
+		record.Data, err = rr.readBytes()
+	case RecordClose:
+	default:
+		err = perrors.Wrapf(ErrRecordInvalid, "record kind %d", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (rr *RecordReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "length: %v", err)
+	}
+	if n > maxRecordBytesLen {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "length %d", n)
+	}
+	// the buffer grows with the data read, a corrupted length does not allocate a huge buffer up front
+	var data bytes.Buffer
+	if _, err = io.CopyN(&data, rr.r, int64(n)); err != nil {
+		return nil, perrors.Wrapf(ErrRecordInvalid, "data: %v", err)
+	}
+
+	return data.Bytes(), nil
+}
+
+// initRecorder starts recording the session if its endpoint has a recorder.
+func (s *session) initRecorder() {
+	var recorder *Recorder
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		recorder = endPoint.recorder
+	case *client:
+		recorder = endPoint.recorder
+	}
+	if recorder != nil {
+		s.recorder = recorder.open(s)
+	}
+}
+
+// isStreamEndPoint reports whether the inbound records of an endpoint are a tcp stream
+func isStreamEndPoint(typ EndPointType) bool {
+	return typ == TCP_SERVER || typ == TCP_CLIENT
+}
+
+// Replay feeds the inbound records of the session @sessionID of the recording @src through @reader and
+// dispatches the decoded packages to @listener like the read loop of the session, so that a bug can be
+// reproduced offline. @ss is passed to @reader and @listener, it may be nil. The packages of an udp
+// session are dispatched as UDPContext without the peer address.
+func Replay(src io.Reader, sessionID uint32, ss Session, reader Reader, listener EventListener) error {
+	rr, err := NewRecordReader(src)
+	if err != nil {
+		return err
+	}
+
+	var (
+		stream bytes.Buffer
+		opened bool
+		typ    EndPointType
+	)
+	for {
+		record, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if record.SessionID != sessionID {
+			continue
+		}
+
+		switch record.Kind {
+		case RecordOpen:
+			opened, typ = true, record.EndPointType
+			if err = listener.OnOpen(ss); err != nil {
+				return err
+			}
+		case RecordInbound:
+			if !opened {
+				continue
+			}
+			if !isStreamEndPoint(typ) {
+				stream.Reset()
+			}
+			stream.Write(record.Data)
+			if err = replayPackages(&stream, typ, ss, reader, listener); err != nil {
+				listener.OnError(ss, err)
+				listener.OnClose(ss)
+				return err
+			}
+		case RecordClose:
+			if opened {
+				listener.OnClose(ss)
+				return nil
+			}
+		}
+	}
+	if !opened {
+		return perrors.Errorf("session %d is not found in the recording", sessionID)
+	}
+	listener.OnClose(ss)
+
+	return nil
+}
+
+// replayPackages decodes the packages in @stream and dispatches them.
+func replayPackages(stream *bytes.Buffer, typ EndPointType, ss Session, reader Reader, listener EventListener) error {
+	for stream.Len() != 0 {
+		pkg, pkgLen, err := reader.Read(ss, stream.Bytes())
+		if err != nil {
+			return &DecodeError{PkgLen: pkgLen, Err: err}
+		}
+		if pkg == nil {
+			if !isStreamEndPoint(typ) {
+				return perrors.Errorf("no package in the message of length %d", stream.Len())
+			}
+			return nil
+		}
+		if typ == UDP_ENDPOINT || typ == UDP_CLIENT {
+			pkg = UDPContext{Pkg: pkg}
+		}
+		listener.OnMessage(ss, pkg)
+		if !isStreamEndPoint(typ) {
+			stream.Reset()
+			return nil
+		}
+		stream.Next(pkgLen)
+	}
+
+	return nil
+}
+
+// ReplayTo writes the inbound records of the session @sessionID of the recording @src to @conn, e.g. a
+// connection to a live server, to reproduce a bug against it. The intervals between the records are kept
+// and divided by @speed if it is positive, otherwise the records are written at once. The records of a tcp
+// session are written as a stream and the records of an udp session as datagrams, a websocket session can
+// not be replayed by a net.Conn.
+func ReplayTo(src io.Reader, sessionID uint32, conn net.Conn, speed float64) error {
+	rr, err := NewRecordReader(src)
+	if err != nil {
+		return err
+	}
+
+	var (
+		opened bool
+		last   time.Time
+	)
+	for {
+		record, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if record.SessionID != sessionID {
+			continue
+		}
+
+		switch record.Kind {
+		case RecordOpen:
+			switch record.EndPointType {
+			case WS_SERVER, WS_CLIENT, WSS_SERVER, WSS_CLIENT:
+				return perrors.Errorf("websocket session %d can not be replayed by a net.Conn", sessionID)
+			}
+			opened, last = true, record.Time
+		case RecordInbound:
+			if !opened {
+				continue
+			}
+			if speed > 0 {
+				if wait := time.Duration(float64(record.Time.Sub(last)) / speed); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			last = record.Time
+			if _, err = conn.Write(record.Data); err != nil {
+				return perrors.WithStack(err)
+			}
+		case RecordClose:
+			if opened {
+				return nil
+			}
+		}
+	}
+	if !opened {
+		return perrors.Errorf("session %d is not found in the recording", sessionID)
+	}
+
+	return nil
+}
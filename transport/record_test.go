@@ -0,0 +1,188 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// recordSession runs a memory server recorded by @recorder and calls @write with a client session. It returns
+// the id and the remote address of the server session after the session closes.
+func recordSession(t *testing.T, recorder *Recorder, write func(cs Session)) (uint32, string) {
+	listener := NewMemoryListener()
+	var serverHandler lineHandler
+	server := NewMemoryServer(listener, WithServerRecorder(recorder))
+	serverSessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		serverSessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := NewMemoryClient(listener)
+	clientSessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		clientSessions <- session
+		return nil
+	})
+	ss, cs := <-serverSessions, <-clientSessions
+	id, remote := ss.ID(), ss.RemoteAddr()
+	write(cs)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+	_, _, err := ss.WritePkg("pong", 0)
+	assert.Nil(t, err)
+	client.Close()
+	assert.Eventually(t, func() bool {
+		_, closed := serverHandler.snapshot()
+		return closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Nil(t, recorder.Flush())
+
+	return id, remote
+}
+
+func readRecords(t *testing.T, recording []byte) []*Record {
+	rr, err := NewRecordReader(bytes.NewReader(recording))
+	assert.Nil(t, err)
+	var records []*Record
+	for {
+		record, err := rr.Next()
+		if err == io.EOF {
+			return records
+		}
+		assert.Nil(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder, err := NewRecorder(&recording)
+	assert.Nil(t, err)
+	id, remote := recordSession(t, recorder, func(cs Session) {
+		_, err := cs.WriteBytes([]byte("a\nb"))
+		assert.Nil(t, err)
+		time.Sleep(50 * time.Millisecond)
+		_, err = cs.WriteBytes([]byte("\nc\n"))
+		assert.Nil(t, err)
+	})
+
+	records := readRecords(t, recording.Bytes())
+	assert.Equal(t, 5, len(records))
+	assert.Equal(t, RecordOpen, records[0].Kind)
+	assert.Equal(t, id, records[0].SessionID)
+	assert.Equal(t, TCP_SERVER, records[0].EndPointType)
+	assert.Equal(t, remote, records[0].RemoteAddr)
+	assert.Equal(t, []byte("a\nb"), records[1].Data)
+	assert.Equal(t, RecordInbound, records[2].Kind)
+	assert.Equal(t, []byte("\nc\n"), records[2].Data)
+	assert.True(t, records[2].Time.Sub(records[1].Time) >= 50*time.Millisecond)
+	assert.Equal(t, RecordOutbound, records[3].Kind)
+	assert.Equal(t, []byte("pong\n"), records[3].Data)
+	assert.Equal(t, RecordClose, records[4].Kind)
+
+	var handler lineHandler
+	assert.Nil(t, Replay(bytes.NewReader(recording.Bytes()), id, nil, &handler, &handler))
+	got, closed := handler.snapshot()
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+	assert.Equal(t, 1, closed)
+	assert.NotNil(t, Replay(bytes.NewReader(recording.Bytes()), id+1, nil, &handler, &handler))
+
+	// replay the session against a live server
+	listener := NewMemoryListener()
+	var serverHandler lineHandler
+	server := NewMemoryServer(listener)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		return nil
+	})
+	defer server.Close()
+	conn, err := listener.Dial("tcp", "")
+	assert.Nil(t, err)
+	defer conn.Close()
+	start := time.Now()
+	assert.Nil(t, ReplayTo(bytes.NewReader(recording.Bytes()), id, conn, 2))
+	assert.True(t, time.Since(start) >= 25*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		got, _ := serverHandler.snapshot()
+		return len(got) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestRecordPackages(t *testing.T) {
+	var recording bytes.Buffer
+	recorder, err := NewRecorder(&recording, WithRecordMode(RecordPackages), WithRecordSessionMaxBytes(5))
+	assert.Nil(t, err)
+	recordSession(t, recorder, func(cs Session) {
+		_, err := cs.WriteBytes([]byte("a\nb\nc\n"))
+		assert.Nil(t, err)
+	})
+
+	// the frame "c\n" and the outbound "pong\n" exceed the session max bytes
+	records := readRecords(t, recording.Bytes())
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, []byte("a\n"), records[1].Data)
+	assert.Equal(t, []byte("b\n"), records[2].Data)
+	assert.Equal(t, RecordClose, records[3].Kind)
+	assert.Equal(t, uint64(2), recorder.Dropped())
+}
+
+func TestRecordLimits(t *testing.T) {
+	var recording bytes.Buffer
+	recorder, err := NewRecorder(&recording, WithRecordMaxBytes(40))
+	assert.Nil(t, err)
+	recordSession(t, recorder, func(cs Session) {
+		_, err := cs.WriteBytes([]byte("a\nb\nc\n"))
+		assert.Nil(t, err)
+	})
+	assert.True(t, recording.Len() <= 40)
+	assert.True(t, recorder.Dropped() > 0)
+	assert.Nil(t, recorder.Close())
+
+	_, err = NewRecordReader(bytes.NewReader([]byte("GETTYREC")))
+	assert.ErrorIs(t, err, ErrRecordInvalid)
+	// the lengths of the data beyond the max record length or the recording are rejected
+	header := recording.Bytes()[:len(recordMagic)+9]
+	for _, n := range []uint64{maxRecordBytesLen + 1, 1 << 40, 1 << 20} {
+		data := append(append([]byte(nil), header...), byte(RecordInbound), 1, 0)
+		data = append(binary.AppendUvarint(data, n), "data"...)
+		rr, err := NewRecordReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		_, err = rr.Next()
+		assert.ErrorIs(t, err, ErrRecordInvalid)
+	}
+	assert.Panics(t, func() { WithRecordSampleRate(0) })
+	assert.Panics(t, func() { WithRecordMaxBytes(0) })
+	assert.Panics(t, func() { WithRecordMode(RecordMode(2)) })
+}
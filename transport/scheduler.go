@@ -0,0 +1,271 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	perrors "github.com/pkg/errors"
+)
+
+const (
+	// DefaultSchedulerGranularity is the default tick of a Scheduler, the same as the timer wheel of gost
+	DefaultSchedulerGranularity = 10 * time.Millisecond
+)
+
+// ErrSchedulerClosed is returned when a timer is added to a closed Scheduler
+var ErrSchedulerClosed = perrors.New("scheduler closed")
+
+// timerWheel runs the timers of the sessions of an endpoint: the cron, the heartbeat and the session timers.
+type timerWheel interface {
+	// addTimer runs @f after @period, and every @period if @loop is true until @f returns an error.
+	// It returns the function stopping the timer.
+	addTimer(f gxtime.TimerFunc, loop bool, period time.Duration, arg any) (func(), error)
+}
+
+// gxTimerWheel is a timerWheel of a gost timer wheel, which runs the timer functions in its own goroutine.
+type gxTimerWheel struct {
+	wheel *gxtime.TimerWheel
+}
+
+func (w gxTimerWheel) addTimer(f gxtime.TimerFunc, loop bool, period time.Duration, arg any) (func(), error) {
+	typ := gxtime.TimerOnce
+	if loop {
+		typ = gxtime.TimerLoop
+	}
+	timer, err := w.wheel.AddTimer(f, typ, period, arg)
+	if err != nil {
+		return nil, perrors.WithStack(err)
+	}
+
+	return timer.Stop, nil
+}
+
+// timerWheel returns the timer wheel of the endpoint of the session, the global timer wheel by default.
+func (s *session) timerWheel() timerWheel {
+	var wheel timerWheel
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		wheel = endPoint.timerWheel
+	case *client:
+		wheel = endPoint.timerWheel
+	}
+	if wheel == nil {
+		return gxTimerWheel{wheel: defaultTimerWheel}
+	}
+
+	return wheel
+}
+
+// schedulerTimer is a timer of a Scheduler
+type schedulerTimer struct {
+	id      gxtime.TimerID
+	f       gxtime.TimerFunc
+	arg     any
+	loop    bool
+	period  time.Duration
+	when    time.Time
+	index   int // the index in the heap, -1 if it is running or stopped
+	stopped bool
+}
+
+type schedulerTimerHeap []*schedulerTimer
+
+func (h schedulerTimerHeap) Len() int           { return len(h) }
+func (h schedulerTimerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h schedulerTimerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *schedulerTimerHeap) Push(x any) {
+	t := x.(*schedulerTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *schedulerTimerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.index = -1
+	return t
+}
+
+// Scheduler is a timer scheduler which an endpoint can use instead of the global timer wheel, see
+// WithServerScheduler/WithClientScheduler. It checks its timers every tick of its granularity and runs the
+// expired timer functions on its worker goroutines, so a slow EventListener.OnCron only delays the timers of
+// the endpoints sharing the Scheduler. A loop timer does not fire again until its last run returns.
+type Scheduler struct {
+	granularity time.Duration
+
+	lock   sync.Mutex
+	timers schedulerTimerHeap
+	nextID gxtime.TimerID
+
+	tasks chan *schedulerTimer
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// NewScheduler starts a Scheduler ticking every @granularity with @workerNum worker goroutines. The default
+// granularity is DefaultSchedulerGranularity and the default worker number is 1.
+func NewScheduler(granularity time.Duration, workerNum int) *Scheduler {
+	if granularity <= 0 {
+		granularity = DefaultSchedulerGranularity
+	}
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+
+	s := &Scheduler{
+		granularity: granularity,
+		tasks:       make(chan *schedulerTimer, workerNum),
+		done:        make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.tick()
+	for i := 0; i < workerNum; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+
+	return s
+}
+
+func (s *Scheduler) tick() {
+	defer s.wg.Done()
+	defer close(s.tasks)
+
+	ticker := time.NewTicker(s.granularity)
+	defer ticker.Stop()
+	var expired []*schedulerTimer
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			expired = expired[:0]
+			s.lock.Lock()
+			for len(s.timers) > 0 && !s.timers[0].when.After(now) {
+				expired = append(expired, heap.Pop(&s.timers).(*schedulerTimer))
+			}
+			s.lock.Unlock()
+			for _, t := range expired {
+				select {
+				case s.tasks <- t:
+				case <-s.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+	for t := range s.tasks {
+		s.lock.Lock()
+		stopped := t.stopped
+		s.lock.Unlock()
+		if stopped || s.isClosed() {
+			continue
+		}
+
+		err := t.f(t.id, time.Now(), t.arg)
+
+		s.lock.Lock()
+		if err == nil && t.loop && !t.stopped {
+			t.when = t.when.Add(t.period)
+			if now := time.Now(); t.when.Before(now) {
+				t.when = now.Add(t.period)
+			}
+			heap.Push(&s.timers, t)
+		} else {
+			t.stopped = true
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *Scheduler) addTimer(f gxtime.TimerFunc, loop bool, period time.Duration, arg any) (func(), error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isClosed() {
+		return nil, ErrSchedulerClosed
+	}
+
+	s.nextID++
+	t := &schedulerTimer{
+		id:     s.nextID,
+		f:      f,
+		arg:    arg,
+		loop:   loop,
+		period: period,
+		when:   time.Now().Add(period),
+	}
+	heap.Push(&s.timers, t)
+
+	return func() { s.stop(t) }, nil
+}
+
+// stop stops @t. It is safe to stop a timer more than once.
+func (s *Scheduler) stop(t *schedulerTimer) {
+	s.lock.Lock()
+	t.stopped = true
+	if t.index >= 0 {
+		heap.Remove(&s.timers, t.index)
+	}
+	s.lock.Unlock()
+}
+
+func (s *Scheduler) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// TimerNumber returns the number of the pending timers.
+func (s *Scheduler) TimerNumber() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.timers)
+}
+
+// Close stops the Scheduler and waits for the running timer functions. The pending timers never fire. Pls
+// do not call it in a timer function of the Scheduler.
+func (s *Scheduler) Close() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		close(s.done)
+		s.lock.Unlock()
+		s.wg.Wait()
+	})
+}
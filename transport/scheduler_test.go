@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	"github.com/stretchr/testify/assert"
+
+	uatomic "go.uber.org/atomic"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(5*time.Millisecond, 2)
+
+	var once, loop, failed, stopped uatomic.Int32
+	counter := func(n *uatomic.Int32, err error) gxtime.TimerFunc {
+		return func(gxtime.TimerID, time.Time, any) error {
+			n.Inc()
+			return err
+		}
+	}
+	_, err := s.addTimer(counter(&once, nil), false, 10*time.Millisecond, nil)
+	assert.Nil(t, err)
+	stopLoop, err := s.addTimer(counter(&loop, nil), true, 10*time.Millisecond, nil)
+	assert.Nil(t, err)
+	// a loop timer is stopped when its function returns an error
+	_, err = s.addTimer(counter(&failed, errors.New("stop")), true, 10*time.Millisecond, nil)
+	assert.Nil(t, err)
+	stop, err := s.addTimer(counter(&stopped, nil), false, 50*time.Millisecond, nil)
+	assert.Nil(t, err)
+	stop()
+	stop()
+
+	// a slow timer function does not block the other worker
+	var slow uatomic.Int32
+	_, err = s.addTimer(func(gxtime.TimerID, time.Time, any) error {
+		slow.Inc()
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	}, true, 5*time.Millisecond, nil)
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return loop.Load() >= 5 }, 3*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), once.Load())
+	assert.Equal(t, int32(1), failed.Load())
+	assert.Equal(t, int32(0), stopped.Load())
+	assert.Equal(t, int32(1), slow.Load())
+	stopLoop()
+	// only the slow timer is left after it returns
+	assert.Eventually(t, func() bool { return s.TimerNumber() == 1 }, 3*time.Second, 5*time.Millisecond)
+
+	s.Close()
+	_, err = s.addTimer(counter(&once, nil), false, time.Millisecond, nil)
+	assert.ErrorIs(t, err, ErrSchedulerClosed)
+}
+
+// cronHandler counts the cron of its sessions
+type cronHandler struct {
+	lineHandler
+	crons uatomic.Int32
+}
+
+func (h *cronHandler) OnCron(Session) { h.crons.Inc() }
+
+func TestEndpointScheduler(t *testing.T) {
+	scheduler := NewScheduler(5*time.Millisecond, 1)
+	defer scheduler.Close()
+
+	var handler cronHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerScheduler(scheduler))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetCronPeriod(20)
+		return nil
+	})
+	defer server.Close()
+
+	var clientHandler lineHandler
+	wheel := gxtime.NewTimerWheel()
+	defer wheel.Stop()
+	sessions := make(chan Session, 1)
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1),
+		WithClientTimerWheel(wheel))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&clientHandler)
+		session.SetEventListener(&clientHandler)
+		sessions <- session
+		return nil
+	})
+	defer client.Close()
+	ss := <-sessions
+
+	assert.Eventually(t, func() bool { return handler.crons.Load() >= 3 }, 3*time.Second, 10*time.Millisecond)
+	// the cron of the server session runs on the scheduler
+	assert.Equal(t, 1, scheduler.TimerNumber())
+
+	var fired uatomic.Int32
+	ss.AfterFunc(10*time.Millisecond, func() { fired.Inc() })
+	assert.Eventually(t, func() bool { return fired.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), int64(wheel.TimerNumber()))
+}
+
+// blockingCronHandler blocks the cron of its sessions until release is closed
+type blockingCronHandler struct {
+	cronHandler
+	release chan struct{}
+}
+
+func (h *blockingCronHandler) OnCron(ss Session) {
+	h.cronHandler.OnCron(ss)
+	<-h.release
+}
+
+func TestCronOffTimerWheel(t *testing.T) {
+	wheel := gxtime.NewTimerWheel()
+	defer wheel.Stop()
+
+	handler := blockingCronHandler{release: make(chan struct{})}
+	sessions := make(chan Session, 1)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerTimerWheel(wheel))
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&handler)
+		session.SetEventListener(&handler)
+		session.SetCronPeriod(10)
+		sessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1))
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		return nil
+	})
+	defer client.Close()
+	ss := <-sessions
+
+	// the blocked cron neither blocks the other timers of the wheel nor overlaps with the next cron
+	assert.Eventually(t, func() bool { return handler.crons.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	var fired uatomic.Int32
+	ss.AfterFunc(10*time.Millisecond, func() { fired.Inc() })
+	assert.Eventually(t, func() bool { return fired.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), handler.crons.Load())
+
+	close(handler.release)
+	assert.Eventually(t, func() bool { return handler.crons.Load() >= 3 }, 3*time.Second, 10*time.Millisecond)
+}
@@ -56,6 +56,9 @@ var (
 // Server interface
 type Server interface {
 	EndPoint
+	// Drain stops accepting new connections and waits at most @timeout for the alive sessions to
+	// be closed. The sessions that are still alive after @timeout are closed by force.
+	Drain(timeout time.Duration)
 }
 
 // StreamServer is like tcp/websocket/wss server
@@ -81,9 +84,20 @@ type server struct {
 	// net
 	pktListener    net.PacketConn
 	streamListener net.Listener
-	lock           sync.Mutex // for server
-	endPointType   EndPointType
-	server         *http.Server // for ws or wss server
+	// all of the SO_REUSEPORT sockets, the first one is @pktListener or @streamListener
+	pktListeners    []net.PacketConn
+	streamListeners []net.Listener
+	// tls config to wrap accepted connections
+	tlsConfig *tls.Config
+	// alive sessions, used to drain the server
+	sessions map[Session]struct{}
+	// traffic totals of the sessions
+	stats endPointStats
+	// epoll reactor of the tcp server, it is nil in the default goroutine per session mode
+	reactor      *reactor
+	lock         sync.Mutex // for server
+	endPointType EndPointType
+	server       *http.Server // for ws or wss server
 	sync.Once
 	done chan struct{}
 	wg   sync.WaitGroup
@@ -93,6 +107,10 @@ func (s *server) init(opts ...ServerOption) {
 	for _, opt := range opts {
 		opt(&(s.ServerOptions))
 	}
+	s.logger = log.With(s.logger, "endpoint", s.endPointType.String(), "endpointID", s.endPointID)
+	for _, err := range s.optionErrs {
+		s.logger.Errorw("[server.init] illegal option", "error", err)
+	}
 }
 
 func newServer(t EndPointType, opts ...ServerOption) *server {
@@ -155,7 +173,7 @@ func (s *server) stop() {
 				if err := s.server.Shutdown(ctx); err != nil {
 					// if the log output is "shutdown ctx: context deadline exceeded"， it means that
 					// there are still some active connections.
-					log.Errorf("server shutdown ctx:%s error:%v", ctx, err)
+					s.logger.Errorw("server shutdown", "error", err)
 				}
 				cancel()
 			}
@@ -163,12 +181,22 @@ func (s *server) stop() {
 			s.lock.Unlock()
 			if s.streamListener != nil {
 				// let the server exit asap when got error from RunEventLoop.
-				_ = s.streamListener.Close()
+				for _, listener := range s.streamListeners {
+					_ = listener.Close()
+				}
 				s.streamListener = nil
+				s.streamListeners = nil
 			}
 			if s.pktListener != nil {
-				_ = s.pktListener.Close()
+				for _, listener := range s.pktListeners {
+					_ = listener.Close()
+				}
 				s.pktListener = nil
+				s.pktListeners = nil
+			}
+			if s.reactor != nil {
+				// the sessions served by the reactor are closed
+				s.reactor.close()
 			}
 		})
 	}
@@ -196,30 +224,92 @@ func (s *server) listenTCP() error {
 		streamListener net.Listener
 	)
 
+	if s.listener != nil || len(s.listenerFds) != 0 {
+		return perrors.WithStack(s.listenTCPInherited())
+	}
+
 	if len(s.addr) == 0 || !strings.Contains(s.addr, ":") {
 		streamListener, err = gxnet.ListenOnTCPRandomPort(s.addr)
 		if err != nil {
 			return perrors.Wrapf(err, "gxnet.ListenOnTCPRandomPort(addr:%s)", s.addr)
 		}
 	} else {
-		if s.sslEnabled {
-			if sslConfig, buildTlsConfErr := s.tlsConfigBuilder.BuildTlsConfig(); buildTlsConfErr == nil && sslConfig != nil {
-				streamListener, err = tls.Listen("tcp", s.addr, sslConfig)
-			}
-		} else {
-			streamListener, err = net.Listen("tcp", s.addr)
-		}
+		streamListener, err = net.Listen("tcp", s.addr)
 		if err != nil {
 			return perrors.Wrapf(err, "net.Listen(tcp, addr:%s)", s.addr)
 		}
+		if err = s.buildTlsConfig(); err != nil {
+			_ = streamListener.Close()
+			return perrors.WithStack(err)
+		}
 	}
 
 	s.streamListener = streamListener
+	s.streamListeners = []net.Listener{streamListener}
 	s.addr = s.streamListener.Addr().String()
 
 	return nil
 }
 
+// buildTlsConfig builds the tls config used to wrap accepted connections if tls is enabled.
+// The listeners themselves are kept raw so that they can be handed over to another process.
+func (s *server) buildTlsConfig() error {
+	if !s.sslEnabled {
+		return nil
+	}
+
+	sslConfig, err := s.tlsConfigBuilder.BuildTlsConfig()
+	if err != nil {
+		return perrors.Wrap(err, "BuildTlsConfig()")
+	}
+	s.tlsConfig = sslConfig
+
+	return nil
+}
+
+// reusePortAddr returns the address to bind SO_REUSEPORT sockets on. The port of @addr is
+// chosen by the kernel if it is not specified.
+func reusePortAddr(addr string) string {
+	if len(addr) == 0 || !strings.Contains(addr, ":") {
+		return net.JoinHostPort(addr, "0")
+	}
+
+	return addr
+}
+
+// listenTCPReusePort opens @s.reusePortNum listeners on the same address.
+func (s *server) listenTCPReusePort() error {
+	var (
+		err       error
+		listener  net.Listener
+		listeners []net.Listener
+	)
+
+	if err = s.buildTlsConfig(); err != nil {
+		return perrors.WithStack(err)
+	}
+
+	addr := reusePortAddr(s.addr)
+	lc := net.ListenConfig{Control: reusePortControl}
+	for i := 0; i < s.reusePortNum; i++ {
+		if listener, err = lc.Listen(context.Background(), "tcp", addr); err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return perrors.Wrapf(err, "net.ListenConfig.Listen(tcp, addr:%s)", addr)
+		}
+		// the following sockets must bind the port that the kernel assigned to the first one
+		addr = listener.Addr().String()
+		listeners = append(listeners, listener)
+	}
+
+	s.streamListener = listeners[0]
+	s.streamListeners = listeners
+	s.addr = addr
+
+	return nil
+}
+
 func (s *server) listenUDP() error {
 	var (
 		err         error
@@ -227,6 +317,10 @@ func (s *server) listenUDP() error {
 		pktListener *net.UDPConn
 	)
 
+	if s.pktConn != nil || len(s.listenerFds) != 0 {
+		return perrors.WithStack(s.listenUDPInherited())
+	}
+
 	if len(s.addr) == 0 || !strings.Contains(s.addr, ":") {
 		pktListener, err = gxnet.ListenOnUDPRandomPort(s.addr)
 		if err != nil {
@@ -244,13 +338,54 @@ func (s *server) listenUDP() error {
 	}
 
 	s.pktListener = pktListener
+	s.pktListeners = []net.PacketConn{pktListener}
 	s.addr = s.pktListener.LocalAddr().String()
 
 	return nil
 }
 
+// listenUDPReusePort opens @s.reusePortNum udp sockets on the same address.
+func (s *server) listenUDPReusePort() error {
+	var (
+		err          error
+		pktListener  net.PacketConn
+		pktListeners []net.PacketConn
+	)
+
+	addr := reusePortAddr(s.addr)
+	lc := net.ListenConfig{Control: reusePortControl}
+	for i := 0; i < s.reusePortNum; i++ {
+		if pktListener, err = lc.ListenPacket(context.Background(), "udp", addr); err != nil {
+			for _, l := range pktListeners {
+				_ = l.Close()
+			}
+			return perrors.Wrapf(err, "net.ListenConfig.ListenPacket(udp, addr:%s)", addr)
+		}
+		addr = pktListener.LocalAddr().String()
+		pktListeners = append(pktListeners, pktListener)
+	}
+
+	s.pktListener = pktListeners[0]
+	s.pktListeners = pktListeners
+	s.addr = addr
+
+	return nil
+}
+
 // Listen announces on the local network address.
 func (s *server) listen() error {
+	if s.reusePortNum > 1 {
+		if reusePortSupported {
+			switch s.endPointType {
+			case TCP_SERVER, WS_SERVER, WSS_SERVER:
+				return perrors.WithStack(s.listenTCPReusePort())
+			case UDP_ENDPOINT:
+				return perrors.WithStack(s.listenUDPReusePort())
+			}
+		}
+		s.logger.Warnw("SO_REUSEPORT is not supported on this platform, listen on a single socket", "addr", s.addr)
+	}
+
 	switch s.endPointType {
 	case TCP_SERVER, WS_SERVER, WSS_SERVER:
 		return perrors.WithStack(s.listenTCP())
@@ -261,44 +396,148 @@ func (s *server) listen() error {
 	return nil
 }
 
-func (s *server) accept(newSession NewSessionCallback) (Session, error) {
-	conn, err := s.streamListener.Accept()
+func (s *server) accept(listener net.Listener) (net.Conn, error) {
+	conn, err := listener.Accept()
 	if err != nil {
 		return nil, perrors.WithStack(err)
 	}
 	if gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
-		log.Warnf("conn.localAddr{%s} == conn.RemoteAddr", conn.LocalAddr().String(), conn.RemoteAddr().String())
+		s.logger.Warnw("connect self", "local", conn.LocalAddr().String(), "remote", conn.RemoteAddr().String())
+		_ = conn.Close()
 		return nil, perrors.WithStack(errSelfConnect)
 	}
+	if s.faultInjector != nil {
+		conn = s.faultInjector.WrapConn(conn)
+	}
+	if s.tlsConfig != nil {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+
+	return conn, nil
+}
+
+// buildSession admits @conn, finishes the tls handshake, the pre-shared key handshake and the compression
+// negotiation of @conn if necessary and then hands the new session to @newSession.
+func (s *server) buildSession(ctx context.Context, conn net.Conn, newSession NewSessionCallback) (_ Session, err error) {
+	var peer net.Addr
+
+	if s.proxyProtocol && proxyProtocolTrusted(conn.RemoteAddr(), s.proxyTrustedSources) {
+		// the PROXY header precedes the tls handshake
+		rawConn := conn
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			rawConn = tlsConn.NetConn()
+		}
+		if peer, err = readProxyHeader(rawConn, s.proxyHeaderTimeout); err != nil {
+			_ = conn.Close()
+			return nil, perrors.WithStack(err)
+		}
+	}
+
+	// the connection from a proxy is admitted by the address of its client like a websocket connection
+	addr := conn.RemoteAddr()
+	if peer != nil {
+		addr = peer
+	}
+	if err = s.admission.admit(addr); err != nil {
+		_ = conn.Close()
+		return nil, perrors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			s.admission.release(addr)
+		}
+	}()
+
+	if _, ok := conn.(*tls.Conn); ok {
+		_, span := startSpan(s.tracer, ctx, SpanTLSHandshake,
+			TraceAttribute{Key: TraceAttrEndPointType, Value: s.endPointType.String()},
+			TraceAttribute{Key: TraceAttrPeerAddr, Value: conn.RemoteAddr().String()})
+		err = handshakeTLS(conn, s.tlsHandshakeTimeout)
+		endSpan(span, err)
+		if err != nil {
+			_ = conn.Close()
+			return nil, perrors.WithStack(err)
+		}
+	}
+
+	if s.pskKeyring != nil {
+		secured, pskErr := acceptPSKConn(conn, s.pskCipher, s.pskKeyring)
+		if pskErr != nil {
+			_ = conn.Close()
+			return nil, perrors.WithStack(pskErr)
+		}
+		conn = secured
+	}
+
+	compress := CompressNone
+	if len(s.msgCompressTypes) != 0 {
+		if compress, err = acceptMessageCompression(conn, s.msgCompressTypes); err != nil {
+			_ = conn.Close()
+			return nil, perrors.WithStack(err)
+		}
+	}
 
 	ss := newTCPSession(conn, s)
-	err = newSession(ss)
-	if err != nil {
+	if peer != nil {
+		ss.(*session).gettyConn().peer = peer.String()
+		ss.(*session).initLogger()
+	}
+	if compress != CompressNone {
+		ss.(*session).Connection.(*gettyTCPConn).setMessageCompression(compress, s.msgCompressThreshold)
+	}
+	if err = newSession(ss); err != nil {
 		_ = conn.Close()
 		return nil, perrors.WithStack(err)
 	}
+	s.admission.releaseOnClose(ss, addr)
 
 	return ss, nil
 }
 
+func (s *server) serveConn(conn net.Conn, newSession NewSessionCallback) {
+	ctx, span := startSpan(s.tracer, context.Background(), SpanConnect,
+		TraceAttribute{Key: TraceAttrEndPointType, Value: s.endPointType.String()},
+		TraceAttribute{Key: TraceAttrPeerAddr, Value: conn.RemoteAddr().String()})
+	ss, err := s.buildSession(ctx, conn, newSession)
+	if span != nil {
+		if err == nil {
+			span.SetAttributes(TraceAttribute{Key: TraceAttrSessionID, Value: ss.ID()})
+		}
+		endSpan(span, err)
+	}
+	if err != nil {
+		s.logger.Warnw("failed to build the session", "addr", s.addr, "peer", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	s.addSession(ss)
+	ss.(*session).run()
+}
+
 func (s *server) runTCPEventLoop(newSession NewSessionCallback) {
+	// every SO_REUSEPORT listener has its own accept loop
+	for _, listener := range s.streamListeners {
+		s.runTCPAcceptLoop(listener, newSession)
+	}
+}
+
+func (s *server) runTCPAcceptLoop(listener net.Listener, newSession NewSessionCallback) {
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
 		var (
-			err    error
-			client Session
-			delay  time.Duration
+			err   error
+			conn  net.Conn
+			delay time.Duration
 		)
 		for {
 			if s.IsClosed() {
-				log.Infof("server{%s} stop accepting client connect request.", s.addr)
+				s.logger.Infow("stop accepting client connect request", "addr", s.addr)
 				return
 			}
 			if delay != 0 {
 				<-gxtime.After(delay)
 			}
-			client, err = s.accept(newSession)
+			conn, err = s.accept(listener)
 			if err != nil {
 				//	change the error checking from "netErr.Temporary()" to "netErr.Timeout()".
 				//  as per https://github.com/golang/go/issues/45729,
@@ -315,16 +554,23 @@ func (s *server) runTCPEventLoop(newSession NewSessionCallback) {
 					}
 					continue
 				}
-				log.Warnf("server{%s}.Accept() = err {%+v}", s.addr, perrors.WithStack(err))
+				s.logger.Warnw("failed to accept", "addr", s.addr, "error", err)
 				continue
 			}
 			delay = 0
-			client.(*session).run()
+			if _, ok := conn.(*tls.Conn); ok || s.proxyProtocol || s.pskKeyring != nil || len(s.msgCompressTypes) != 0 {
+				// a slow tls, PROXY protocol, encryption or compression negotiation peer should not block the accept loop
+				go s.serveConn(conn, newSession)
+				continue
+			}
+			s.serveConn(conn, newSession)
 		}
 	}()
 }
 
 func (s *server) runUDPEventLoop(newSession NewSessionCallback) {
+	// stop resets s.pktListeners, which may happen before the goroutine ranges over them
+	pktListeners := s.pktListeners
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -334,13 +580,27 @@ func (s *server) runUDPEventLoop(newSession NewSessionCallback) {
 			ss   Session
 		)
 
-		conn = s.pktListener.(*net.UDPConn)
-		ss = newUDPSession(conn, s)
-		if err = newSession(ss); err != nil {
-			_ = conn.Close()
-			panic(err.Error())
+		// every SO_REUSEPORT socket is served by its own session and read loop
+		for _, pktListener := range pktListeners {
+			conn = pktListener.(*net.UDPConn)
+			if s.faultInjector != nil {
+				ss = newUDPSession(s.faultInjector.wrapUDPConn(conn), s)
+			} else {
+				ss = newUDPSession(conn, s)
+			}
+			if s.datagramCompressThreshold > 0 {
+				ss.(*session).Connection.(*gettyUDPConn).compressThreshold = s.datagramCompressThreshold
+			}
+			if s.pskKeyring != nil {
+				ss.(*session).Connection.(*gettyUDPConn).psk = newUDPPSK(s.pskCipher, s.pskKeyring, false)
+			}
+			if err = newSession(ss); err != nil {
+				_ = conn.Close()
+				panic(err.Error())
+			}
+			s.addSession(ss)
+			ss.(*session).run()
 		}
-		ss.(*session).run()
 	}()
 }
 
@@ -373,33 +633,107 @@ func (s *wsHandler) serveWSRequest(w http.ResponseWriter, r *http.Request) {
 
 	if s.server.IsClosed() {
 		http.Error(w, "HTTP server is closed(code:500-11).", http.StatusInternalServerError)
-		log.Warnf("server{%s} stop acceptting client connect request.", s.server.addr)
+		s.server.logger.Warnw("stop accepting client connect request", "addr", s.server.addr)
 		return
 	}
 
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	addr := stringAddr(r.RemoteAddr)
+	if err := s.server.admission.admit(addr); err != nil {
+		code := http.StatusServiceUnavailable
+		if perrors.Is(err, ErrConnectionDenied) {
+			code = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(code), code)
+		s.server.logger.Warnw("reject websocket request", "addr", s.server.addr, "peer", r.RemoteAddr, "error", err)
+		return
+	}
+	var (
+		err    error
+		conn   *websocket.Conn
+		ss     Session
+		served bool
+	)
+	_, span := startSpan(s.server.tracer, r.Context(), SpanConnect,
+		TraceAttribute{Key: TraceAttrEndPointType, Value: s.server.endPointType.String()},
+		TraceAttribute{Key: TraceAttrPeerAddr, Value: r.RemoteAddr})
+	defer func() {
+		if span != nil {
+			if served {
+				span.SetAttributes(TraceAttribute{Key: TraceAttrSessionID, Value: ss.ID()})
+			}
+			endSpan(span, err)
+		}
+		if !served {
+			s.server.admission.release(addr)
+		}
+	}()
+
+	conn, err = s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Warnf("upgrader.Upgrader(http.Request{%#v}) = error:%+v", r, err)
+		s.server.logger.Warnw("failed to upgrade the websocket request", "peer", r.RemoteAddr, "error", err)
 		return
 	}
 	if conn.RemoteAddr().String() == conn.LocalAddr().String() {
-		log.Warnf("conn.localAddr{%s} == conn.RemoteAddr", conn.LocalAddr().String(), conn.RemoteAddr().String())
+		err = errSelfConnect
+		s.server.logger.Warnw("connect self", "local", conn.LocalAddr().String(), "remote", conn.RemoteAddr().String())
 		return
 	}
+	var pskSend, pskRecv *pskStream
+	if s.server.pskKeyring != nil {
+		if pskSend, pskRecv, err = acceptPSKWS(conn, s.server.pskCipher, s.server.pskKeyring); err != nil {
+			_ = conn.Close()
+			s.server.logger.Warnw("failed to accept the pre-shared key handshake", "addr", s.server.addr, "peer", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+	}
 	// conn.SetReadLimit(int64(handler.maxMsgLen))
-	ss := newWSSession(conn, s.server)
+	ss = newWSSession(conn, s.server)
+	ss.(*session).Connection.(*gettyWSConn).setPSK(pskSend, pskRecv)
 	err = s.newSession(ss)
 	if err != nil {
 		_ = conn.Close()
-		log.Warnf("server{%s}.newSession(ss{%#v}) = err {%s}", s.server.addr, ss, err)
+		ss.Logger().Warnw("failed to build the session", "addr", s.server.addr, "error", err)
 		return
 	}
 	if ss.(*session).maxMsgLen > 0 {
-		conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
+		ss.(*session).Connection.(*gettyWSConn).setReadLimit(ss.(*session).maxMsgLen)
 	}
+	s.server.admission.releaseOnClose(ss, addr)
+	served = true
+	s.server.addSession(ss)
 	ss.(*session).run()
 }
 
+// wsListener returns the listener that the ws/wss http server serves on.
+func (s *server) wsListener(listener net.Listener) net.Listener {
+	if s.faultInjector != nil {
+		listener = s.faultInjector.WrapListener(listener)
+	}
+	if s.proxyProtocol {
+		listener = &proxyProtocolListener{
+			Listener: listener,
+			trusted:  s.proxyTrustedSources,
+			timeout:  s.proxyHeaderTimeout,
+		}
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	return listener
+}
+
+// serveWS serves an extra SO_REUSEPORT listener of a ws/wss server.
+func (s *server) serveWS(server *http.Server, listener net.Listener) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorw("http.server.Serve failed", "addr", s.addr, "error", err)
+		}
+	}()
+}
+
 // runWSEventLoop serve websocket client request
 // @newSession: new websocket connection callback
 func (s *server) runWSEventLoop(newSession NewSessionCallback) {
@@ -422,9 +756,12 @@ func (s *server) runWSEventLoop(newSession NewSessionCallback) {
 		s.lock.Lock()
 		s.server = server
 		s.lock.Unlock()
-		err = server.Serve(s.streamListener)
+		for _, listener := range s.streamListeners[1:] {
+			s.serveWS(server, s.wsListener(listener))
+		}
+		err = server.Serve(s.wsListener(s.streamListener))
 		if err != nil {
-			log.Errorf("http.server.Serve(addr{%s}) = err:%+v", s.addr, perrors.WithStack(err))
+			s.logger.Errorw("http.server.Serve failed", "addr", s.addr, "error", err)
 		}
 	}()
 }
@@ -482,9 +819,12 @@ func (s *server) runWSSEventLoop(newSession NewSessionCallback) {
 		s.lock.Lock()
 		s.server = server
 		s.lock.Unlock()
-		err = server.Serve(tls.NewListener(s.streamListener, config))
+		for _, listener := range s.streamListeners[1:] {
+			s.serveWS(server, tls.NewListener(s.wsListener(listener), config))
+		}
+		err = server.Serve(tls.NewListener(s.wsListener(s.streamListener), config))
 		if err != nil {
-			log.Errorf("http.server.Serve(addr{%s}) = err:%+v", s.addr, perrors.WithStack(err))
+			s.logger.Errorw("http.server.Serve failed", "addr", s.addr, "error", err)
 			panic(err)
 		}
 	}()
@@ -497,8 +837,23 @@ func (s *server) RunEventLoop(newSession NewSessionCallback) {
 		panic(fmt.Errorf("server.listen() = error:%+v", perrors.WithStack(err)))
 	}
 
+	if s.socketOptions != nil {
+		for _, listener := range s.streamListeners {
+			if err := s.socketOptions.applyListener(listener); err != nil {
+				s.logger.Warnw("failed to set the socket options of the listener", "addr", s.addr, "error", err)
+			}
+		}
+	}
+	newSession = chainSessionInits(sessionInits(s.socketOptions, s.sessionInits), newSession)
 	switch s.endPointType {
 	case TCP_SERVER:
+		if s.reactorPollerNum > 0 {
+			if reactor, err := newReactor(s.reactorPollerNum); err == nil {
+				s.reactor = reactor
+			} else {
+				s.logger.Warnw("failed to create the reactor, serve sessions by goroutines", "addr", s.addr, "error", err)
+			}
+		}
 		s.runTCPEventLoop(newSession)
 	case UDP_ENDPOINT:
 		s.runUDPEventLoop(newSession)
@@ -185,3 +185,41 @@ func TestServer(t *testing.T) {
 	addr = "127.0.0.9999"
 	testTCPTlsServer(t, addr)
 }
+
+func TestReusePortServer(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+
+	var serverMsgHandler MessageHandler
+	newServerSession := func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	}
+
+	tcpServer := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1"), WithServerReusePort(4))
+	tcpServer.RunEventLoop(newServerSession)
+	assert.Equal(t, 4, len(tcpServer.streamListeners))
+	for _, listener := range tcpServer.streamListeners {
+		assert.Equal(t, tcpServer.Listener().Addr().String(), listener.Addr().String())
+	}
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(tcpServer.Listener().Addr().String()),
+		WithConnectionNumber(8),
+		WithReconnectInterval(1e7),
+	)
+	var msgHandler MessageHandler
+	clt.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &msgHandler)
+	})
+	assert.Eventually(t, func() bool { return serverMsgHandler.SessionNumber() == 8 }, 5*time.Second, 10*time.Millisecond)
+	clt.Close()
+	tcpServer.Close()
+	assert.Nil(t, tcpServer.streamListeners)
+
+	udpServer := newServer(UDP_ENDPOINT, WithLocalAddress("127.0.0.1:0"), WithServerReusePort(2))
+	udpServer.RunEventLoop(newServerSession)
+	assert.Equal(t, 2, len(udpServer.pktListeners))
+	assert.Eventually(t, func() bool { return serverMsgHandler.SessionNumber() == 10 }, 5*time.Second, 10*time.Millisecond)
+	udpServer.Close()
+}
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
@@ -56,6 +57,11 @@ const (
 
 	defaultTLSHandshakeTimeout = time.Second * 3
 
+	// a session logs at most decodeErrLogBurst decode errors every decodeErrLogInterval, and passes at most
+	// decodeErrLogBurst decode errors of the udp/websocket messages per second to its listener
+	decodeErrLogInterval = time.Second
+	decodeErrLogBurst    = 10
+
 	defaultSessionName    = "session"
 	defaultTCPSessionName = "tcp-session"
 	defaultUDPSessionName = "udp-session"
@@ -88,16 +94,44 @@ type Session interface {
 	SetWriter(Writer)
 	SetCronPeriod(int)
 	SetWaitTime(time.Duration)
+	// SetWriteCoalescing lets a tcp session gather the packages written concurrently and flush them in
+	// batches of at most @maxBytes by writev, waiting at most @maxDelay for a batch to fill.
+	SetWriteCoalescing(maxBytes int, maxDelay time.Duration)
+	// SetReadRateLimit limits the packages read by the session to @rate, the excess packages are handled
+	// by @policy. A session served by the reactor refuses the InboundRatePause policy.
+	SetReadRateLimit(rate Rate, policy InboundRatePolicy)
+	// SetWriteRateLimit limits the packages written by the session to @rate, the excess packages are handled
+	// by @policy.
+	SetWriteRateLimit(rate Rate, policy OutboundRatePolicy)
+	// SetHeartbeat enables the ping/pong heartbeat of the session, see HeartbeatFactory
+	SetHeartbeat(factory HeartbeatFactory, interval time.Duration, maxMissed int)
+	// HeartbeatRTT returns the round trip time of the last answered heartbeat ping
+	HeartbeatRTT() time.Duration
+	// AfterFunc runs @f after @d, and Every runs @f every @interval. Their timers are stopped when the
+	// session is closed.
+	AfterFunc(d time.Duration, f func()) *SessionTimer
+	Every(interval time.Duration, f func()) *SessionTimer
 	GetAttribute(any) any
 	SetAttribute(any, any)
 	RemoveAttribute(any)
+	// TLSConnectionState returns the tls state of a tcp+tls or wss session. The second return value is false
+	// if the session does not run over tls.
+	TLSConnectionState() (tls.ConnectionState, bool)
+	// PeerCertificates returns the certificates presented by the peer during the tls handshake.
+	PeerCertificates() []*x509.Certificate
+	// Logger returns the logger of the endpoint with the fields of the session: the endpoint, the session ID,
+	// the local address and the remote address.
+	Logger() log.StructuredLogger
 
 	// WritePkg the Writer will invoke this function. Pls attention that if timeout is less than 0, WritePkg will send @pkg asap.
 	// for udp session, the first parameter should be UDPContext.
 	// totalBytesLength: @pkg stream bytes length after encoding @pkg.
 	// sendBytesLength: stream bytes length that sent out successfully.
-	// err: maybe it has illegal data, encoding error, or write out system error.
+	// err: maybe it has illegal data, encoding error, or write out system error. A write error matches
+	// ErrSessionClosed, ErrWriteTimeout or ErrPeerReset by errors.Is if it is caused by them.
 	WritePkg(pkg any, timeout time.Duration) (totalBytesLength int, sendBytesLength int, err error)
+	// WritePkgContext is WritePkg whose encode and write spans are the children of the span in @ctx, see Tracer.
+	WritePkgContext(ctx context.Context, pkg any, timeout time.Duration) (totalBytesLength int, sendBytesLength int, err error)
 	WriteBytes([]byte) (int, error)
 	WriteBytesArray(...[]byte) (int, error)
 	Close()
@@ -110,6 +144,7 @@ type Session interface {
 type session struct {
 	name     string
 	endPoint EndPoint
+	created  time.Time
 
 	// net read Write
 	Connection
@@ -123,8 +158,39 @@ type session struct {
 	// handle logic
 	maxMsgLen int32
 
+	// logger with the session fields, and its rate limited one for the hot error paths
+	logger          log.StructuredLogger
+	decodeErrLogger log.StructuredLogger
+	// limits the decode errors of the udp/websocket messages passed to the listener
+	decodeErrReports *tokenBucket
+
+	// records the traffic, nil if the endpoint has no recorder or the session is not sampled
+	recorder *sessionRecorder
+
 	// heartbeat
-	period time.Duration
+	period    time.Duration
+	heartbeat *heartbeatState
+	// startHeartbeat has been called, SetHeartbeat reschedules the ping timer since then
+	heartbeatStarted bool
+
+	// timers started by AfterFunc/Every
+	timers map[*SessionTimer]struct{}
+	// stop the cron and the heartbeat ping timers
+	stopCron func()
+	stopPing func()
+	// the cron is running in its own goroutine
+	cronRunning uatomic.Bool
+	// the heartbeat ping is being written in its own goroutine
+	pingRunning uatomic.Bool
+
+	// write coalescing
+	coalescer *writeCoalescer
+
+	// rate limits
+	readLimit  *readRateLimit
+	writeLimit *writeRateLimit
+	// the session is served by a poller of the reactor, which can not pause reading
+	polled bool
 
 	// done
 	wait time.Duration
@@ -148,6 +214,7 @@ func newSession(endPoint EndPoint, conn Connection) *session {
 	ss := &session{
 		name:     defaultSessionName,
 		endPoint: endPoint,
+		created:  time.Now(),
 
 		Connection: conn,
 
@@ -162,8 +229,12 @@ func newSession(endPoint EndPoint, conn Connection) *session {
 	}
 
 	ss.Connection.SetSession(ss)
+	if c := ss.gettyConn(); c != nil {
+		c.stats = endPointStatsOf(endPoint)
+	}
 	ss.SetWriteTimeout(netIOTimeout)
 	ss.SetReadTimeout(netIOTimeout)
+	ss.initLogger()
 
 	return ss
 }
@@ -176,7 +247,7 @@ func newTCPSession(conn net.Conn, endPoint EndPoint) Session {
 	return session
 }
 
-func newUDPSession(conn *net.UDPConn, endPoint EndPoint) Session {
+func newUDPSession(conn udpConn, endPoint EndPoint) Session {
 	c := newGettyUDPConn(conn)
 	session := newSession(endPoint, c)
 	session.name = defaultUDPSessionName
@@ -194,13 +265,36 @@ func newWSSession(conn *websocket.Conn, endPoint EndPoint) Session {
 
 func (s *session) Reset() {
 	*s = session{
-		name:   defaultSessionName,
-		once:   &sync.Once{},
-		done:   make(chan struct{}),
-		period: period,
-		wait:   pendingDuration,
-		attrs:  gxcontext.NewValuesContext(context.Background()),
+		name:    defaultSessionName,
+		created: time.Now(),
+		once:    &sync.Once{},
+		done:    make(chan struct{}),
+		period:  period,
+		wait:    pendingDuration,
+		attrs:   gxcontext.NewValuesContext(context.Background()),
+	}
+	s.initLogger()
+}
+
+// initLogger sets the loggers of the session with the session fields, and the limit of the decode errors
+// passed to the listener along with decodeErrLogger.
+func (s *session) initLogger() {
+	var logger log.StructuredLogger
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		logger = endPoint.logger
+	case *client:
+		logger = endPoint.logger
 	}
+
+	s.logger = log.With(logger, "sessionID", s.ID(), "local", s.LocalAddr(), "remote", s.RemoteAddr())
+	s.decodeErrLogger = log.RateLimited(s.logger, decodeErrLogInterval, decodeErrLogBurst)
+	s.decodeErrReports = newTokenBucket(decodeErrLogBurst)
+}
+
+// Logger returns the logger with the session fields.
+func (s *session) Logger() log.StructuredLogger {
+	return s.logger
 }
 
 func (s *session) Conn() net.Conn {
@@ -274,6 +368,15 @@ func (s *session) SetMaxMsgLen(length int) {
 	s.maxMsgLen = int32(length)
 }
 
+// maxDecompressedLen returns the max length of a decompressed message, which is the max message length
+// of the session if it is set.
+func (s *session) maxDecompressedLen() int {
+	if s.maxMsgLen > 0 {
+		return int(s.maxMsgLen)
+	}
+	return maxCompressedMessageLen
+}
+
 // SetName set session name
 func (s *session) SetName(name string) {
 	s.lock.Lock()
@@ -335,6 +438,32 @@ func (s *session) SetWaitTime(waitTime time.Duration) {
 	s.wait = waitTime
 }
 
+// SetWriteCoalescing enables write coalescing of a tcp session. Pls call it in NewSessionCallback, and
+// every write of the session will wait until its batch is flushed. The default @maxBytes is
+// DefaultWriteCoalescingBytes. It can not be changed after being enabled.
+func (s *session) SetWriteCoalescing(maxBytes int, maxDelay time.Duration) {
+	if maxDelay < 0 {
+		panic("@maxDelay < 0")
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultWriteCoalescingBytes
+	}
+
+	tcpConn, ok := s.Connection.(*gettyTCPConn)
+	if !ok {
+		s.logger.Warnw("write coalescing only works for tcp sessions")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.coalescer != nil {
+		return
+	}
+	s.coalescer = newWriteCoalescer(tcpConn, maxBytes, maxDelay, s.done)
+	go s.coalescer.run()
+}
+
 // GetAttribute get attribute of key @session:key
 func (s *session) GetAttribute(key any) any {
 	s.lock.RLock()
@@ -370,6 +499,29 @@ func (s *session) RemoveAttribute(key any) {
 	s.lock.Unlock()
 }
 
+// TLSConnectionState get the tls connection state of the underlying connection
+func (s *session) TLSConnectionState() (tls.ConnectionState, bool) {
+	conn := s.Conn()
+	if secured, ok := conn.(*secureConn); ok {
+		conn = secured.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState(), true
+	}
+
+	return tls.ConnectionState{}, false
+}
+
+// PeerCertificates get the peer certificates, it returns nil if the session does not run over tls
+func (s *session) PeerCertificates() []*x509.Certificate {
+	state, ok := s.TLSConnectionState()
+	if !ok {
+		return nil
+	}
+
+	return state.PeerCertificates
+}
+
 func (s *session) sessionToken() string {
 	if s.IsClosed() || s.Connection == nil {
 		return "session-closed"
@@ -380,6 +532,10 @@ func (s *session) sessionToken() string {
 }
 
 func (s *session) WritePkg(pkg any, timeout time.Duration) (pkgBytesLenth int, successCount int, err error) {
+	return s.WritePkgContext(context.Background(), pkg, timeout)
+}
+
+func (s *session) WritePkgContext(ctx context.Context, pkg any, timeout time.Duration) (pkgBytesLenth int, successCount int, err error) {
 	if pkg == nil {
 		return 0, 0, fmt.Errorf("@pkg is nil")
 	}
@@ -393,14 +549,42 @@ func (s *session) WritePkg(pkg any, timeout time.Duration) (pkgBytesLenth int, s
 			rBuf := make([]byte, size)
 			rBuf = rBuf[:runtime.Stack(rBuf, false)]
 			err = perrors.WithStack(fmt.Errorf("[session.WritePkg] panic session %s: err=%v\n%s", s.sessionToken(), r, rBuf))
-			log.Error(err)
+			s.logger.Error(err)
 		}
 	}()
 
-	pkgBytes, err := s.writer.Write(s, pkg)
+	var (
+		pkgBytes []byte
+		buffers  [][]byte
+		pkgLen   int
+	)
+	tcpConn, isTCP := s.Connection.(*gettyTCPConn)
+	encodeCtx, span := s.startSpan(ctx, SpanEncode)
+	if bw, ok := s.writer.(BuffersWriter); ok {
+		buffers, err = bw.WriteBuffers(s, pkg)
+		for _, buf := range buffers {
+			pkgLen += len(buf)
+		}
+		if err == nil && !isTCP {
+			pkgBytes, buffers = bytes.Join(buffers, nil), nil
+		}
+	} else if tw, ok := s.writer.(TracingWriter); ok {
+		pkgBytes, err = tw.WriteContext(encodeCtx, s, pkg)
+		pkgLen = len(pkgBytes)
+	} else {
+		pkgBytes, err = s.writer.Write(s, pkg)
+		pkgLen = len(pkgBytes)
+	}
+	if span != nil {
+		span.SetAttributes(TraceAttribute{Key: TraceAttrPkgLen, Value: pkgLen})
+		endSpan(span, err)
+	}
 	if err != nil {
-		log.Warnf("%s, [session.WritePkg] session.writer.Write(@pkg:%#v) = error:%+v", s.Stat(), pkg, err)
-		return len(pkgBytes), 0, perrors.WithStack(err)
+		s.logger.Warnw("[session.WritePkg] failed to encode the package", "pkg", pkg, "error", err)
+		return pkgLen, 0, perrors.WithStack(err)
+	}
+	if err = s.limitWrite(pkgLen, 1); err != nil {
+		return pkgLen, 0, err
 	}
 	var udpCtxPtr *UDPContext
 	if udpCtx, ok := pkg.(UDPContext); ok {
@@ -414,17 +598,37 @@ func (s *session) WritePkg(pkg any, timeout time.Duration) (pkgBytesLenth int, s
 	} else {
 		pkg = pkgBytes
 	}
+	_, span = s.startSpan(ctx, SpanWrite, TraceAttribute{Key: TraceAttrPkgLen, Value: pkgLen})
+	defer func() { endSpan(span, err) }()
 	s.packetLock.RLock()
 	defer s.packetLock.RUnlock()
 	if 0 < timeout {
 		s.gettyConn().SetWriteTimeout(timeout)
 	}
-	successCount, err = s.Connection.Send(pkg)
+	switch {
+	case s.coalescer != nil:
+		if buffers == nil {
+			buffers = [][]byte{pkgBytes}
+		}
+		successCount, err = s.coalescer.write(buffers, 1)
+	case buffers != nil:
+		if _, err = tcpConn.updateWriteDeadline(); err == nil {
+			successCount, err = tcpConn.sendBuffers(buffers, 1)
+		}
+	default:
+		successCount, err = s.Connection.Send(pkg)
+	}
 	if err != nil {
-		log.Warnf("%s, [session.WritePkg] @s.Connection.Write(pkg:%#v) = err:%+v", s.Stat(), pkg, err)
-		return len(pkgBytes), successCount, perrors.WithStack(err)
+		err = classifyWriteError(err)
+		s.logger.Warnw("[session.WritePkg] failed to send the package", "pkgLen", pkgLen, "error", err)
+		return pkgLen, successCount, perrors.WithStack(err)
+	}
+	if buffers != nil {
+		s.recorder.write(buffers...)
+	} else {
+		s.recorder.write(pkgBytes)
 	}
-	return len(pkgBytes), successCount, nil
+	return pkgLen, successCount, nil
 }
 
 // WriteBytes for codecs
@@ -432,6 +636,20 @@ func (s *session) WriteBytes(pkg []byte) (int, error) {
 	if s.IsClosed() {
 		return 0, ErrSessionClosed
 	}
+	if err := s.limitWrite(len(pkg), 1); err != nil {
+		return 0, err
+	}
+
+	if s.coalescer != nil {
+		s.packetLock.RLock()
+		defer s.packetLock.RUnlock()
+		n, err := s.coalescer.write([][]byte{pkg}, 1)
+		if err != nil {
+			return n, perrors.Wrapf(classifyWriteError(err), "s.coalescer.write(pkg len:%d)", len(pkg))
+		}
+		s.recorder.write(pkg)
+		return n, nil
+	}
 
 	leftPackageSize, totalSize, writeSize := len(pkg), len(pkg), 0
 	if leftPackageSize > maxPacketLen {
@@ -445,7 +663,7 @@ func (s *session) WriteBytes(pkg []byte) (int, error) {
 	for leftPackageSize > maxPacketLen {
 		_, err := s.Connection.Send(pkg[writeSize:(writeSize + maxPacketLen)])
 		if err != nil {
-			return writeSize, perrors.Wrapf(err, "s.Connection.Write(pkg len:%d)", len(pkg))
+			return writeSize, perrors.Wrapf(classifyWriteError(err), "s.Connection.Write(pkg len:%d)", len(pkg))
 		}
 		leftPackageSize -= maxPacketLen
 		writeSize += maxPacketLen
@@ -457,8 +675,9 @@ func (s *session) WriteBytes(pkg []byte) (int, error) {
 
 	_, err := s.Connection.Send(pkg[writeSize:])
 	if err != nil {
-		return writeSize, perrors.Wrapf(err, "s.Connection.Write(pkg len:%d)", len(pkg))
+		return writeSize, perrors.Wrapf(classifyWriteError(err), "s.Connection.Write(pkg len:%d)", len(pkg))
 	}
+	s.recorder.write(pkg)
 
 	return totalSize, nil
 }
@@ -474,12 +693,27 @@ func (s *session) WriteBytesArray(pkgs ...[]byte) (int, error) {
 
 	// reduce syscall and memcopy for multiple packages
 	if _, ok := s.Connection.(*gettyTCPConn); ok {
+		var (
+			lg  int
+			err error
+		)
+		for _, pkg := range pkgs {
+			lg += len(pkg)
+		}
+		if err = s.limitWrite(lg, len(pkgs)); err != nil {
+			return 0, err
+		}
 		s.packetLock.RLock()
 		defer s.packetLock.RUnlock()
-		lg, err := s.Connection.Send(pkgs)
+		if s.coalescer != nil {
+			lg, err = s.coalescer.write(append([][]byte(nil), pkgs...), len(pkgs))
+		} else {
+			lg, err = s.Connection.Send(pkgs)
+		}
 		if err != nil {
-			return 0, perrors.Wrapf(err, "s.Connection.Write(pkgs num:%d)", len(pkgs))
+			return 0, perrors.Wrapf(classifyWriteError(err), "s.Connection.Write(pkgs num:%d)", len(pkgs))
 		}
+		s.recorder.write(pkgs...)
 		return lg, nil
 	}
 
@@ -508,6 +742,10 @@ func (s *session) WriteBytesArray(pkgs ...[]byte) (int, error) {
 		l += len(pkgs[i])
 	}
 
+	// WriteBytes takes the tokens of the bytes and the first package
+	if err = s.limitWrite(0, len(pkgs)-1); err != nil {
+		return 0, err
+	}
 	wlg, err = s.WriteBytes(arr)
 	if err != nil {
 		return 0, perrors.WithStack(err)
@@ -528,15 +766,23 @@ func heartbeat(_ gxtime.TimerID, _ time.Time, arg any) error {
 	}
 
 	f := func() {
-		wsConn, wsFlag := ss.Connection.(*gettyWSConn)
+		// the session may be closed and collected by gc before @f runs
+		ss.lock.RLock()
+		conn, listener := ss.Connection, ss.listener
+		ss.lock.RUnlock()
+		if conn == nil || listener == nil {
+			return
+		}
+
+		wsConn, wsFlag := conn.(*gettyWSConn)
 		if wsFlag {
 			err := wsConn.writePing()
 			if err != nil {
-				log.Warnf("wsConn.writePing() = error:%+v", perrors.WithStack(err))
+				ss.logger.Warnw("failed to write the websocket ping", "error", err)
 			}
 		}
 
-		ss.listener.OnCron(ss)
+		listener.OnCron(ss)
 	}
 
 	// if enable task pool, run @f asynchronously.
@@ -544,7 +790,21 @@ func heartbeat(_ gxtime.TimerID, _ time.Time, arg any) error {
 		taskPool.AddTaskAlways(f)
 		return nil
 	}
-	f()
+	// a Scheduler runs @f in the goroutine advancing it. The goroutine of a timer wheel is shared by the
+	// timers of all of its sessions, so @f runs in its own goroutine, and is skipped if the last one is
+	// still running.
+	if _, ok := ss.timerWheel().(*Scheduler); ok {
+		f()
+		return nil
+	}
+	if !ss.cronRunning.CAS(false, true) {
+		ss.logger.Warnw("[session.heartbeat] skip the cron, the last one is still running")
+		return nil
+	}
+	go func() {
+		defer ss.cronRunning.Store(false)
+		f()
+	}()
 	return nil
 }
 
@@ -553,20 +813,34 @@ func (s *session) run() {
 	if s.Connection == nil || s.listener == nil || s.writer == nil {
 		errStr := fmt.Sprintf("session{name:%s, conn:%#v, listener:%#v, writer:%#v}",
 			s.name, s.Connection, s.listener, s.writer)
-		log.Error(errStr)
+		s.logger.Error(errStr)
 		panic(errStr)
 	}
 
 	// call session opened
 	s.UpdateActive()
+	s.initRecorder()
 	if err := s.listener.OnOpen(s); err != nil {
-		log.Errorf("[OnOpen] session %s, error: %#v", s.Stat(), err)
+		s.logger.Errorw("[OnOpen] failed to open the session", "error", err)
 		s.Close()
 		return
 	}
 
-	if _, err := defaultTimerWheel.AddTimer(heartbeat, gxtime.TimerLoop, s.period, s); err != nil {
-		panic(fmt.Sprintf("failed to add session %s to defaultTimerWheel err:%v", s.Stat(), err))
+	stopCron, err := s.timerWheel().addTimer(heartbeat, true, s.period, s)
+	if err != nil {
+		panic(fmt.Sprintf("failed to add session %s to the timer wheel err:%v", s.Stat(), err))
+	}
+	s.lock.Lock()
+	s.stopCron = stopCron
+	s.lock.Unlock()
+	s.startHeartbeat()
+
+	if srv, ok := s.endPoint.(*server); ok && srv.reactor != nil {
+		err := srv.reactor.register(s)
+		if err == nil {
+			return
+		}
+		s.logger.Infow("failed to register the session to the reactor, serve it by a read gr", "error", err)
 	}
 
 	s.grNum.Add(1)
@@ -574,14 +848,29 @@ func (s *session) run() {
 	go s.handlePackage()
 }
 
-func (s *session) addTask(pkg any) {
+// addTask dispatches @pkg decoded within the decode span in @ctx to the listener. If @pkg references @buf,
+// @buf is retained until OnMessage returns.
+func (s *session) addTask(ctx context.Context, pkg any, buf *ReadBuffer) {
+	if s.handleHeartbeat(pkg) {
+		return
+	}
+	if buf != nil {
+		buf.Retain()
+	}
+	// the dispatch span lasts until the task starts
+	ctx, span := s.startDispatchSpan(ctx, pkg)
 	f := func() {
+		if buf != nil {
+			defer buf.Release()
+		}
 		// If the session is closed, there is no need to perform CPU-intensive operations.
 		if s.IsClosed() {
-			log.Errorf("[Id:%d, name=%s, endpoint=%s] Session is closed", s.ID(), s.name, s.EndPoint())
+			endSpan(span, ErrSessionClosed)
+			s.logger.Errorw("session is closed, drop the package")
 			return
 		}
-		s.listener.OnMessage(s, pkg)
+		endSpan(span, nil)
+		s.onMessage(ctx, pkg)
 		s.IncReadPkgNum()
 	}
 	if taskPool := s.EndPoint().GetTaskPool(); taskPool != nil {
@@ -591,6 +880,59 @@ func (s *session) addTask(pkg any) {
 	f()
 }
 
+// onMessage invokes the listener within the OnMessage span.
+func (s *session) onMessage(ctx context.Context, pkg any) {
+	ctx, span := s.startSpan(ctx, SpanOnMessage)
+	if span != nil {
+		defer span.End()
+	}
+	if tl, ok := s.listener.(TracingEventListener); ok {
+		tl.OnMessageContext(ctx, s, pkg)
+		return
+	}
+	s.listener.OnMessage(s, pkg)
+}
+
+// decode unmarshals a package from @data within the decode span, and returns the context of the span. If @rb is not nil, @data belongs to @rb
+// and the package is decoded by the ZeroCopyReader of the session. The error of the Reader is returned as a
+// DecodeError, as is ErrMsgTooLong for a package longer than the max message length of the session, or for
+// an udp message if @msgLen, the length of the whole message, is positive.
+func (s *session) decode(data []byte, rb *ReadBuffer, msgLen int) (any, int, context.Context, error) {
+	ctx, span := s.startSpan(context.Background(), SpanDecode)
+	var (
+		pkg    any
+		pkgLen int
+		err    error
+	)
+	if rb != nil {
+		pkg, pkgLen, err = s.reader.(ZeroCopyReader).ReadZeroCopy(s, data, rb)
+	} else {
+		pkg, pkgLen, err = s.reader.Read(s, data)
+	}
+	if msgLen <= 0 {
+		msgLen = pkgLen
+	}
+	if err == nil && s.maxMsgLen > 0 && msgLen > int(s.maxMsgLen) {
+		err = newMsgTooLongError(msgLen, s.maxMsgLen)
+	} else if err != nil {
+		err = &DecodeError{PkgLen: pkgLen, Err: err}
+	}
+	if span != nil {
+		span.SetAttributes(TraceAttribute{Key: TraceAttrPkgLen, Value: pkgLen})
+		endSpan(span, err)
+	}
+
+	return pkg, pkgLen, ctx, err
+}
+
+// reportDecodeError passes the error @err of a dropped udp/websocket message to the listener. At most
+// decodeErrLogBurst errors are passed per second, like the logs of decodeErrLogger.
+func (s *session) reportDecodeError(err error) {
+	if s.decodeErrReports.take(1, time.Now()) {
+		s.listener.OnError(s, err)
+	}
+}
+
 func (s *session) handlePackage() {
 	var err error
 
@@ -599,26 +941,17 @@ func (s *session) handlePackage() {
 			const size = 64 << 10
 			rBuf := make([]byte, size)
 			rBuf = rBuf[:runtime.Stack(rBuf, false)]
-			log.Errorf("[session.handlePackage] panic session %s: err=%s\n%s", s.sessionToken(), r, rBuf)
+			s.logger.Errorf("[session.handlePackage] panic err=%s\n%s", r, rBuf)
 		}
 		grNum := s.grNum.Add(-1)
-		log.Infof("%s, [session.handlePackage] gr will exit now, left gr num %d", s.sessionToken(), grNum)
-		s.stop()
-		if err != nil {
-			log.Errorf("%s, [session.handlePackage] error:%+v", s.sessionToken(), perrors.WithStack(err))
-			if s != nil || s.listener != nil {
-				s.listener.OnError(s, err)
-			}
-		}
-
-		s.listener.OnClose(s)
-		s.gc()
+		s.logger.Infow("[session.handlePackage] gr will exit now", "grNum", grNum)
+		s.finishRead(err)
 	}()
 
 	if _, ok := s.Connection.(*gettyTCPConn); ok {
 		if s.reader == nil {
 			errStr := fmt.Sprintf("session{name:%s, conn:%#v, reader:%#v}", s.name, s.Connection, s.reader)
-			log.Error(errStr)
+			s.logger.Error(errStr)
 			panic(errStr)
 		}
 
@@ -632,6 +965,23 @@ func (s *session) handlePackage() {
 	}
 }
 
+// finishRead stops the session and notifies the listener after the session stops reading.
+func (s *session) finishRead(err error) {
+	_, span := s.startSpan(context.Background(), SpanClose)
+	defer func() { endSpan(span, err) }()
+	s.stop()
+	if err != nil {
+		s.logger.Errorw("[session.handlePackage] stop reading", "error", err)
+		if s != nil || s.listener != nil {
+			s.listener.OnError(s, err)
+		}
+	}
+
+	s.listener.OnClose(s)
+	s.recorder.close()
+	s.gc()
+}
+
 // get package from tcp stream(packet)
 func (s *session) handleTCPPackage() error {
 	var (
@@ -641,27 +991,16 @@ func (s *session) handleTCPPackage() error {
 		conn     *gettyTCPConn
 		exit     bool
 		bufLen   int
-		pkgLen   int
 		buf      []byte
-		pktBuf   *gxbytes.Buffer
-		pkg      any
+		pktBuf   *streamBuffer
+		rb       *ReadBuffer
 	)
 
-	pktBuf = gxbytes.NewBuffer(nil)
+	pktBuf = newStreamBuffer()
+	defer pktBuf.release()
+	_, zeroCopy := s.reader.(ZeroCopyReader)
 
 	conn = s.Connection.(*gettyTCPConn)
-	if tlsConn, ok := conn.conn.(*tls.Conn); ok {
-		tlsHandshaketime := defaultTLSHandshakeTimeout
-		if s.ReadTimeout() > 0 {
-			tlsHandshaketime = s.ReadTimeout()
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), tlsHandshaketime)
-		defer cancel()
-		if err := tlsConn.HandshakeContext(ctx); err != nil {
-			log.Errorf("[tlsConn.HandshakeContext] = error:%+v", err)
-			return perrors.Wrap(err, "tlsConn.HandshakeContext")
-		}
-	}
 	for {
 		if s.IsClosed() {
 			err = nil
@@ -674,14 +1013,14 @@ func (s *session) handleTCPPackage() error {
 		for {
 			// for clause for the network timeout condition check
 			// s.conn.SetReadTimeout(time.Now().Add(s.rTimeout))
-			buf = pktBuf.WriteNextBegin(maxReadBufLen)
+			buf = pktBuf.writeNextBegin()
 			bufLen, err = conn.recv(buf)
 			if err != nil {
 				if netError, ok = perrors.Cause(err).(net.Error); ok && netError.Timeout() {
 					break
 				}
 				if perrors.Cause(err) == io.EOF {
-					log.Infof("%s, session.conn read EOF, client send over, session exit", s.sessionToken())
+					s.logger.Infow("session.conn read EOF, client send over, session exit")
 					//when read EOF, means that the peer has closed the connection, stop to reconnect to maintain the connection pool.
 					s.SetAttribute(ignoreReconnectKey, true)
 					err = nil
@@ -691,41 +1030,28 @@ func (s *session) handleTCPPackage() error {
 						// this branch is impossible. Even if it happens, the bufLen will be zero and the error
 						// is io.EOF when getty continues to read the socket.
 						exit = false
-						log.Infof("%s, session.conn read EOF, while the bufLen(%d) is non-zero.", s.sessionToken())
+						s.logger.Infow("session.conn read EOF, while the buffered stream is not empty")
 					}
 					break
 				}
-				log.Errorf("%s, [session.conn.read] = error:%+v", s.sessionToken(), perrors.WithStack(err))
+				err = classifyReadError(err)
+				s.logger.Errorw("[session.conn.read] failed to read", "error", err)
 				exit = true
 			}
 			break
 		}
 		if bufLen != 0 {
-			if _, werr := pktBuf.WriteNextEnd(bufLen); werr != nil {
-				log.Warnf("%s, pktBuf.WriteNextEnd(%d) error:%+v", s.sessionToken(), bufLen, perrors.WithStack(werr))
+			s.recorder.stream(buf[:bufLen])
+			pktBuf.writeNextEnd(bufLen)
+			rb = nil
+			if zeroCopy {
+				rb = pktBuf.rb
 			}
-			for pktBuf.Len() > 0 {
-				pkg, pkgLen, err = s.reader.Read(s, pktBuf.Bytes())
-				// for case 3/case 4
-				if err == nil && s.maxMsgLen > 0 && pkgLen > int(s.maxMsgLen) {
-					err = perrors.Errorf("pkgLen %d > session max message len %d", pkgLen, s.maxMsgLen)
-				}
-				// handle case 1
-				if err != nil {
-					log.Warnf("%s, [session.handleTCPPackage] = len{%d}, error:%+v",
-						s.sessionToken(), pkgLen, perrors.WithStack(err))
-					exit = true
-					break
-				}
-				// handle case 2/case 3
-				if pkg == nil {
-					break
-				}
-				// handle case 4
-				s.UpdateActive()
-				s.addTask(pkg)
-				pktBuf.Next(pkgLen)
-				// continue to handle case 5
+			pkgLen, perr := s.parseTCPPackages(pktBuf.bytes(), rb)
+			pktBuf.next(pkgLen)
+			if perr != nil {
+				err = perr
+				exit = true
 			}
 		}
 		if exit {
@@ -736,20 +1062,66 @@ func (s *session) handleTCPPackage() error {
 	return perrors.WithStack(err)
 }
 
+// parseTCPPackages unmarshals as many packages as possible from the tcp stream @buf and dispatches them.
+// It returns the length of the consumed stream. If @rb is not nil, @buf belongs to @rb and the packages
+// are decoded by the ZeroCopyReader of the session.
+func (s *session) parseTCPPackages(buf []byte, rb *ReadBuffer) (int, error) {
+	var (
+		err      error
+		pkg      any
+		pkgLen   int
+		consumed int
+		ctx      context.Context
+	)
+
+	for consumed < len(buf) {
+		// for case 3/case 4
+		pkg, pkgLen, ctx, err = s.decode(buf[consumed:], rb, 0)
+		// handle case 1
+		if err != nil {
+			s.logger.Warnw("[session.handleTCPPackage] failed to decode", "pkgLen", pkgLen, "error", err)
+			return consumed, err
+		}
+		// handle case 2/case 3
+		if pkg == nil {
+			break
+		}
+		// handle case 4
+		s.UpdateActive()
+		dispatch, lerr := s.limitRead(pkgLen)
+		if lerr != nil {
+			return consumed, lerr
+		}
+		s.recorder.frame(buf[consumed : consumed+pkgLen])
+		if dispatch {
+			s.addTask(ctx, pkg, rb)
+		}
+		consumed += pkgLen
+		// continue to handle case 5
+	}
+
+	return consumed, nil
+}
+
 // get package from udp packet
 func (s *session) handleUDPPackage() error {
 	var (
-		ok        bool
-		err       error
-		netError  net.Error
-		conn      *gettyUDPConn
-		bufLen    int
-		maxBufLen int
-		bufp      *[]byte
-		buf       []byte
-		addr      *net.UDPAddr
-		pkgLen    int
-		pkg       any
+		ok         bool
+		err        error
+		netError   net.Error
+		conn       *gettyUDPConn
+		bufLen     int
+		maxBufLen  int
+		bufp       *[]byte
+		buf        []byte
+		addr       *net.UDPAddr
+		pkgLen     int
+		pkg        any
+		rb         *ReadBuffer
+		data       []byte
+		compressed bool
+		derr       error
+		ctx        context.Context
 	)
 
 	conn = s.Connection.(*gettyUDPConn)
@@ -757,50 +1129,96 @@ func (s *session) handleUDPPackage() error {
 	if int(s.maxMsgLen<<1) < bufLen {
 		maxBufLen = int(s.maxMsgLen << 1)
 	}
-	bufp = gxbytes.AcquireBytes(maxBufLen)
-	defer gxbytes.ReleaseBytes(bufp)
-	buf = *bufp
+	_, zeroCopy := s.reader.(ZeroCopyReader)
+	if !zeroCopy {
+		bufp = gxbytes.AcquireBytes(maxBufLen)
+		defer gxbytes.ReleaseBytes(bufp)
+		buf = *bufp
+	}
 	for !s.IsClosed() {
+		if rb != nil {
+			rb.Release()
+			rb = nil
+		}
+		if zeroCopy {
+			// every datagram gets its own buffer which may be referenced by the pkg
+			rb = newReadBuffer(maxBufLen)
+			buf = rb.buf[:maxBufLen]
+		}
 
 		bufLen, addr, err = conn.recv(buf)
-		log.Debugf("conn.read() = bufLen:%d, addr:%#v, err:%+v", bufLen, addr, perrors.WithStack(err))
+		s.logger.Debugw("[session.handleUDPPackage] read", "bufLen", bufLen, "addr", addr, "error", err)
 		if netError, ok = perrors.Cause(err).(net.Error); ok && netError.Timeout() {
 			continue
 		}
 		if err != nil {
-			log.Errorf("%s, [session.handleUDPPackage] = len:%d, error:%+v",
-				s.sessionToken(), bufLen, perrors.WithStack(err))
+			err = classifyReadError(err)
+			s.logger.Errorw("[session.handleUDPPackage] failed to read", "bufLen", bufLen, "error", err)
 			err = perrors.Wrapf(err, "conn.read()")
 			break
 		}
 
 		if bufLen == 0 {
-			log.Errorf("conn.read() = bufLen:%d, addr:%s, err:%+v", bufLen, addr, perrors.WithStack(err))
+			s.decodeErrLogger.Errorw("[session.handleUDPPackage] empty datagram", "addr", addr)
 			continue
 		}
 
 		if bufLen == len(connectPingPackage) && bytes.Equal(connectPingPackage, buf[:bufLen]) {
-			log.Infof("got %s connectPingPackage", addr)
+			s.logger.Infow("[session.handleUDPPackage] got connectPingPackage", "addr", addr)
 			continue
 		}
 
-		pkg, pkgLen, err = s.reader.Read(s, buf[:bufLen])
-		log.Debugf("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%+v", pkg, pkgLen, perrors.WithStack(err))
-		if err == nil && s.maxMsgLen > 0 && bufLen > int(s.maxMsgLen) {
-			err = perrors.Errorf("Message Too Long, bufLen %d, session max message len %d", bufLen, s.maxMsgLen)
+		data = buf[:bufLen]
+		if conn.psk != nil {
+			if data, derr = conn.psk.open(data); derr != nil {
+				s.decodeErrLogger.Warnw("[session.handleUDPPackage] failed to open datagram",
+					"bufLen", bufLen, "addr", addr, "error", derr)
+				continue
+			}
 		}
+		if conn.compressType != CompressNone {
+			data, compressed, derr = conn.decompressDatagram(data, s.maxDecompressedLen())
+			if derr != nil {
+				s.decodeErrLogger.Warnw("[session.handleUDPPackage] failed to decompress datagram",
+					"bufLen", bufLen, "addr", addr, "error", derr)
+				continue
+			}
+			if compressed && zeroCopy {
+				// the pkg should reference a ReadBuffer
+				rb.Release()
+				rb = newReadBuffer(len(data))
+				data = rb.buf[:copy(rb.buf, data)]
+			}
+		}
+
+		s.recorder.message(data)
+		// @rb is nil unless the reader is a ZeroCopyReader
+		pkg, pkgLen, ctx, err = s.decode(data, rb, len(data))
+		s.logger.Debugw("[session.handleUDPPackage] decode", "pkg", pkg, "pkgLen", pkgLen, "error", err)
 		if err != nil {
-			log.Warnf("%s, [session.handleUDPPackage] = len:%d, error:%+v",
-				s.sessionToken(), pkgLen, perrors.WithStack(err))
+			s.decodeErrLogger.Warnw("[session.handleUDPPackage] failed to decode", "addr", addr, "pkgLen", pkgLen, "error", err)
+			s.reportDecodeError(err)
+			err = nil
 			continue
 		}
 		if pkgLen == 0 {
-			log.Errorf("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%+v", pkg, pkgLen, perrors.WithStack(err))
+			s.decodeErrLogger.Errorw("[session.handleUDPPackage] no package in datagram", "addr", addr, "bufLen", bufLen)
 			continue
 		}
 
 		s.UpdateActive()
-		s.addTask(UDPContext{Pkg: pkg, PeerAddr: addr})
+		dispatch, lerr := s.limitRead(len(data))
+		if lerr != nil {
+			s.logger.Warnw("[session.handleUDPPackage] rate limit", "addr", addr, "error", lerr)
+			err = lerr
+			break
+		}
+		if dispatch {
+			s.addTask(ctx, UDPContext{Pkg: pkg, PeerAddr: addr}, rb)
+		}
+	}
+	if rb != nil {
+		rb.Release()
 	}
 
 	return perrors.WithStack(err)
@@ -816,35 +1234,93 @@ func (s *session) handleWSPackage() error {
 		conn         *gettyWSConn
 		pkg          []byte
 		unmarshalPkg any
+		ctx          context.Context
 	)
 
 	conn = s.Connection.(*gettyWSConn)
+	if _, ok := s.reader.(ZeroCopyReader); ok {
+		return s.handleWSPackageZeroCopy(conn)
+	}
 	for !s.IsClosed() {
 		pkg, err = conn.recv()
 		if netError, ok = perrors.Cause(err).(net.Error); ok && netError.Timeout() {
 			continue
 		}
 		if err != nil {
-			log.Warnf("%s, [session.handleWSPackage] = error:%+v",
-				s.sessionToken(), perrors.WithStack(err))
+			err = classifyReadError(err)
+			s.logger.Warnw("[session.handleWSPackage] failed to read", "error", err)
 			return perrors.WithStack(err)
 		}
 		s.UpdateActive()
+		s.recorder.message(pkg)
+		dispatch, lerr := s.limitRead(len(pkg))
+		if lerr != nil {
+			s.logger.Warnw("[session.handleWSPackage] rate limit", "error", lerr)
+			return lerr
+		}
+		if !dispatch {
+			continue
+		}
 		if s.reader != nil {
-			unmarshalPkg, length, err = s.reader.Read(s, pkg)
-			if err == nil && s.maxMsgLen > 0 && length > int(s.maxMsgLen) {
-				err = perrors.Errorf("Message Too Long, length %d, session max message len %d", length, s.maxMsgLen)
-			}
+			unmarshalPkg, length, ctx, err = s.decode(pkg, nil, 0)
 			if err != nil {
-				log.Warnf("%s, [session.handleWSPackage] = len:%d, error:%+v",
-					s.sessionToken(), length, perrors.WithStack(err))
+				s.decodeErrLogger.Warnw("[session.handleWSPackage] failed to decode", "pkgLen", length, "error", err)
+				s.reportDecodeError(err)
 				continue
 			}
 
-			s.addTask(unmarshalPkg)
+			s.addTask(ctx, unmarshalPkg, nil)
+		} else {
+			s.addTask(context.Background(), pkg, nil)
+		}
+	}
+
+	return nil
+}
+
+// get package from websocket stream by a ZeroCopyReader
+func (s *session) handleWSPackageZeroCopy(conn *gettyWSConn) error {
+	var (
+		ok       bool
+		err      error
+		netError net.Error
+		length   int
+		n        int
+		rb       *ReadBuffer
+		pkg      any
+		ctx      context.Context
+	)
+
+	for !s.IsClosed() {
+		rb, n, err = conn.recvBuffer()
+		if netError, ok = perrors.Cause(err).(net.Error); ok && netError.Timeout() {
+			continue
+		}
+		if err != nil {
+			err = classifyReadError(err)
+			s.logger.Warnw("[session.handleWSPackage] failed to read", "error", err)
+			return perrors.WithStack(err)
+		}
+		s.UpdateActive()
+		s.recorder.message(rb.buf[:n])
+		dispatch, lerr := s.limitRead(n)
+		if lerr != nil {
+			rb.Release()
+			s.logger.Warnw("[session.handleWSPackage] rate limit", "error", lerr)
+			return lerr
+		}
+		if !dispatch {
+			rb.Release()
+			continue
+		}
+		pkg, length, ctx, err = s.decode(rb.buf[:n], rb, 0)
+		if err != nil {
+			s.decodeErrLogger.Warnw("[session.handleWSPackage] failed to decode", "pkgLen", length, "error", err)
+			s.reportDecodeError(err)
 		} else {
-			s.addTask(pkg)
+			s.addTask(ctx, pkg, rb)
 		}
+		rb.Release()
 	}
 
 	return nil
@@ -861,13 +1337,14 @@ func (s *session) stop() {
 			now := time.Now()
 			if conn := s.Conn(); conn != nil {
 				if err := conn.SetReadDeadline(now.Add(s.ReadTimeout())); err != nil {
-					log.Warnf("failed to set read deadline: %+v", err)
+					s.logger.Warnw("failed to set read deadline", "error", err)
 				}
 				if err := conn.SetWriteDeadline(now.Add(s.WriteTimeout())); err != nil {
-					log.Warnf("failed to set write deadline: %+v", err)
+					s.logger.Warnw("failed to set write deadline", "error", err)
 				}
 			}
 			close(s.done)
+			s.stopTimers()
 
 			go func(sessionToken string) {
 				defer func() {
@@ -877,7 +1354,7 @@ func (s *session) stop() {
 						rBuf = rBuf[:runtime.Stack(rBuf, false)]
 						err := perrors.WithStack(fmt.Errorf("[session.invokeCloseCallbacks] panic session %s: err=%v\n%s",
 							sessionToken, r, rBuf))
-						log.Error(err)
+						s.logger.Error(err)
 					}
 				}()
 
@@ -915,7 +1392,7 @@ func (s *session) gc() {
 // or (session)handleLoop automatically. It's thread safe.
 func (s *session) Close() {
 	s.stop()
-	log.Infof("%s closed now. its current gr num is %d", s.sessionToken(), s.grNum.Load())
+	s.logger.Infow("session closed now", "grNum", s.grNum.Load())
 }
 
 // GetActive return connection's time
@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"runtime"
+	"time"
+)
+
+import (
+	gxtime "github.com/dubbogo/gost/time"
+
+	perrors "github.com/pkg/errors"
+
+	uatomic "go.uber.org/atomic"
+)
+
+var errSessionTimerStopped = perrors.New("session timer stopped")
+
+// SessionTimer is a timer started by Session.AfterFunc or Session.Every. It is stopped automatically
+// when its session is closed.
+type SessionTimer struct {
+	ss      *session
+	f       func()
+	loop    bool
+	stop    func()
+	stopped uatomic.Bool
+}
+
+// Stop stops the timer. It returns false if the timer has already fired(AfterFunc) or been stopped.
+func (t *SessionTimer) Stop() bool {
+	if !t.stopped.CAS(false, true) {
+		return false
+	}
+
+	t.ss.lock.Lock()
+	delete(t.ss.timers, t)
+	stop := t.stop
+	t.ss.lock.Unlock()
+	if stop != nil {
+		stop()
+	}
+
+	return true
+}
+
+func fireSessionTimer(_ gxtime.TimerID, _ time.Time, arg any) error {
+	t := arg.(*SessionTimer)
+	if t.stopped.Load() || t.ss.IsClosed() {
+		return errSessionTimerStopped
+	}
+	if !t.loop {
+		if !t.stopped.CAS(false, true) {
+			return errSessionTimerStopped
+		}
+		t.ss.lock.Lock()
+		delete(t.ss.timers, t)
+		t.ss.lock.Unlock()
+	}
+
+	f := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				const size = 64 << 10
+				rBuf := make([]byte, size)
+				rBuf = rBuf[:runtime.Stack(rBuf, false)]
+				t.ss.logger.Errorf("[SessionTimer] panic err=%s\n%s", r, rBuf)
+			}
+		}()
+		if t.loop && (t.stopped.Load() || t.ss.IsClosed()) {
+			return
+		}
+		t.f()
+	}
+	// if enable task pool, run @f asynchronously, otherwise run it in its own goroutine so that
+	// it does not block the timer wheel.
+	if taskPool := t.ss.EndPoint().GetTaskPool(); taskPool != nil {
+		taskPool.AddTaskAlways(f)
+	} else {
+		go f()
+	}
+
+	if !t.loop {
+		return errSessionTimerStopped
+	}
+	return nil
+}
+
+// addTimer starts a timer running @f after @d, every @d if @loop is true.
+func (s *session) addTimer(d time.Duration, f func(), loop bool) *SessionTimer {
+	t := &SessionTimer{ss: s, f: f, loop: loop}
+	wheel := s.timerWheel()
+	s.lock.Lock()
+	if s.IsClosed() {
+		s.lock.Unlock()
+		t.stopped.Store(true)
+		return t
+	}
+	stop, err := wheel.addTimer(fireSessionTimer, loop, d, t)
+	if err == nil {
+		if s.timers == nil {
+			s.timers = make(map[*SessionTimer]struct{})
+		}
+		s.timers[t] = struct{}{}
+		t.stop = stop
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		s.logger.Errorw("failed to add a session timer to the timer wheel", "error", err)
+		t.stopped.Store(true)
+	}
+	return t
+}
+
+// stopTimers stops all of the timers of the session, so that a stopped session is not referenced by the
+// timer wheel any more.
+func (s *session) stopTimers() {
+	s.lock.Lock()
+	timers := s.timers
+	s.timers = nil
+	stops := []func(){s.stopCron, s.stopPing}
+	s.stopCron, s.stopPing = nil, nil
+	s.lock.Unlock()
+
+	for t := range timers {
+		t.Stop()
+	}
+	for _, stop := range stops {
+		if stop != nil {
+			stop()
+		}
+	}
+}
+
+// AfterFunc runs @f after @d on the task pool of the endpoint if it is set, or in its own goroutine. @f is
+// not run if the session is closed before then.
+func (s *session) AfterFunc(d time.Duration, f func()) *SessionTimer {
+	return s.addTimer(d, f, false)
+}
+
+// Every runs @f every @interval like AfterFunc until the timer is stopped or the session is closed.
+func (s *session) Every(interval time.Duration, f func()) *SessionTimer {
+	if interval <= 0 {
+		panic("@interval <= 0")
+	}
+
+	return s.addTimer(interval, f, true)
+}
@@ -0,0 +1,62 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	uatomic "go.uber.org/atomic"
+)
+
+func TestSessionTimer(t *testing.T) {
+	var handler lineHandler
+	server, client, ss := rateLimitPair(t, &handler, nil)
+	defer server.Close()
+	defer client.Close()
+
+	var fired, stopped, ticks uatomic.Int32
+	once := ss.AfterFunc(20*time.Millisecond, func() { fired.Inc() })
+	canceled := ss.AfterFunc(100*time.Millisecond, func() { stopped.Inc() })
+	assert.True(t, canceled.Stop())
+	assert.False(t, canceled.Stop())
+	every := ss.Every(20*time.Millisecond, func() { ticks.Inc() })
+
+	assert.Eventually(t, func() bool { return ticks.Load() >= 3 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), fired.Load())
+	assert.False(t, once.Stop())
+	assert.True(t, every.Stop())
+	n := ticks.Load()
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, ticks.Load() <= n+1)
+	assert.Equal(t, int32(0), stopped.Load())
+	assert.Panics(t, func() { ss.Every(0, func() {}) })
+
+	// the timers are stopped when the session is closed
+	pending := ss.AfterFunc(100*time.Millisecond, func() { stopped.Inc() })
+	ss.Every(20*time.Millisecond, func() { stopped.Inc() })
+	ss.Close()
+	assert.False(t, pending.Stop())
+	assert.False(t, ss.AfterFunc(0, func() { stopped.Inc() }).Stop())
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(0), stopped.Load())
+}
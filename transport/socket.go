@@ -0,0 +1,243 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+)
+
+// fastOpenQueueLen is the TCP_FASTOPEN queue length of a listening socket
+const fastOpenQueueLen = 256
+
+var errSocketOptionUnsupported = perrors.New("socket option is not supported on this platform")
+
+// SocketOptions are the options of the tcp socket of every session of a tcp/ws/wss endpoint. They are set
+// before the session is handed to the NewSessionCallback. The zero value of a field keeps the system default.
+type SocketOptions struct {
+	// DisableNoDelay enables the Nagle's algorithm. TCP_NODELAY is set by default.
+	DisableNoDelay bool
+	// KeepAlive is the TCP keep-alive period. A negative value disables the keep-alive.
+	KeepAlive time.Duration
+	// ReadBuffer and WriteBuffer are SO_RCVBUF and SO_SNDBUF.
+	ReadBuffer  int
+	WriteBuffer int
+	// Linger is SO_LINGER. A positive value makes Close wait that long for the unsent data, and a negative
+	// value makes Close discard the unsent data and reset the connection.
+	Linger time.Duration
+
+	// the following options are only supported on linux
+
+	// TOS is IP_TOS of an ipv4 socket or IPV6_TCLASS of an ipv6 socket. The DSCP of the traffic is TOS >> 2,
+	// see DSCP.
+	TOS int
+	// UserTimeout is TCP_USER_TIMEOUT, the maximum time that the transmitted data may stay unacknowledged.
+	UserTimeout time.Duration
+	// QuickAck sets TCP_QUICKACK when the session is opened. The kernel may leave the quick ack mode later.
+	QuickAck bool
+	// NotSentLowat is TCP_NOTSENT_LOWAT, the maximum bytes of the unsent data in the socket write buffer.
+	NotSentLowat int
+	// FastOpen sets TCP_FASTOPEN on the listening sockets of a server and TCP_FASTOPEN_CONNECT on the sockets
+	// dialed by a client. It does not take effect on the connections of WithClientDialer.
+	FastOpen bool
+}
+
+// DSCP returns the TOS of the differentiated services code point @dscp, e.g. 46 for expedited forwarding.
+func DSCP(dscp int) int {
+	if dscp < 0 || dscp > 63 {
+		panic(fmt.Sprintf("illegal dscp %d", dscp))
+	}
+	return dscp << 2
+}
+
+// check panics if any option is illegal.
+func (o *SocketOptions) check() {
+	if o.ReadBuffer < 0 || o.WriteBuffer < 0 {
+		panic(fmt.Sprintf("illegal socket buffer size, read:%d, write:%d", o.ReadBuffer, o.WriteBuffer))
+	}
+	if o.TOS < 0 || o.TOS > 255 {
+		panic(fmt.Sprintf("illegal tos %d", o.TOS))
+	}
+	if o.UserTimeout < 0 {
+		panic(fmt.Sprintf("illegal tcp user timeout %s", o.UserTimeout))
+	}
+	if o.NotSentLowat < 0 {
+		panic(fmt.Sprintf("illegal tcp notsent lowat %d", o.NotSentLowat))
+	}
+}
+
+// Apply sets the options to the tcp socket under the tls, PROXY protocol, pre-shared key and fault injecting
+// wrappers of @conn. It does nothing if there is no tcp socket under @conn, e.g. a memory connection.
+func (o *SocketOptions) Apply(conn net.Conn) error {
+	tcpConn := underlyingTCPConn(conn)
+	if tcpConn == nil {
+		return nil
+	}
+
+	if err := tcpConn.SetNoDelay(!o.DisableNoDelay); err != nil {
+		return perrors.Wrap(err, "SetNoDelay")
+	}
+	if o.KeepAlive < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return perrors.Wrap(err, "SetKeepAlive")
+		}
+	} else if o.KeepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return perrors.Wrap(err, "SetKeepAlive")
+		}
+		if err := tcpConn.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return perrors.Wrap(err, "SetKeepAlivePeriod")
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return perrors.Wrap(err, "SetReadBuffer")
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return perrors.Wrap(err, "SetWriteBuffer")
+		}
+	}
+	if o.Linger < 0 {
+		if err := tcpConn.SetLinger(0); err != nil {
+			return perrors.Wrap(err, "SetLinger")
+		}
+	} else if o.Linger > 0 {
+		// round up, a linger of 0 seconds resets the connection
+		if err := tcpConn.SetLinger(int((o.Linger + time.Second - 1) / time.Second)); err != nil {
+			return perrors.Wrap(err, "SetLinger")
+		}
+	}
+	if o.TOS == 0 && o.UserTimeout == 0 && !o.QuickAck && o.NotSentLowat == 0 {
+		return nil
+	}
+
+	ipv6 := false
+	if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return perrors.WithStack(err)
+	}
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		opErr = setSocketOptions(fd, o, ipv6)
+	})
+	if err != nil {
+		return perrors.WithStack(err)
+	}
+	return opErr
+}
+
+// initSession applies the options to the socket of @ss. A failure is logged and does not close the session.
+func (o *SocketOptions) initSession(ss Session) error {
+	conn := underlyingConn(ss.Conn())
+	if _, ok := conn.(*net.TCPConn); !ok {
+		// a memory connection has no socket by design
+		typ := ss.EndPoint().EndPointType()
+		if _, ok = conn.(*memoryConn); !ok && typ != UDP_ENDPOINT && typ != UDP_CLIENT {
+			ss.Logger().Warnw("no tcp socket under the connection, the socket options are not set",
+				"conn", fmt.Sprintf("%T", conn))
+		}
+		return nil
+	}
+	if err := o.Apply(ss.Conn()); err != nil {
+		ss.Logger().Warnw("failed to set the socket options", "error", err)
+	}
+	return nil
+}
+
+// applyListener sets TCP_FASTOPEN on @listener if FastOpen is enabled.
+func (o *SocketOptions) applyListener(listener net.Listener) error {
+	if !o.FastOpen {
+		return nil
+	}
+	sc, ok := listener.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return perrors.WithStack(err)
+	}
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		opErr = setFastOpen(fd)
+	})
+	if err != nil {
+		return perrors.WithStack(err)
+	}
+	return opErr
+}
+
+// dialControl sets TCP_FASTOPEN_CONNECT on a socket before it connects if FastOpen is enabled.
+func (o *SocketOptions) dialControl(_, _ string, c syscall.RawConn) error {
+	if !o.FastOpen {
+		return nil
+	}
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = setFastOpenConnect(fd)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// underlyingConn returns the connection under the tls, PROXY protocol, pre-shared key and fault injecting
+// wrappers of @conn.
+func underlyingConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *proxyProtocolConn:
+			conn = c.NetConn()
+		case *secureConn:
+			conn = c.Conn
+		case *faultConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
+
+// underlyingTCPConn returns the *net.TCPConn under the wrappers of @conn, or nil.
+func underlyingTCPConn(conn net.Conn) *net.TCPConn {
+	tcpConn, _ := underlyingConn(conn).(*net.TCPConn)
+	return tcpConn
+}
+
+// sessionInits returns the session inits of the endpoint, the socket options are applied first.
+func sessionInits(socketOptions *SocketOptions, inits []NewSessionCallback) []NewSessionCallback {
+	if socketOptions == nil {
+		return inits
+	}
+	return append([]NewSessionCallback{socketOptions.initSession}, inits...)
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"time"
+)
+
+import (
+	perrors "github.com/pkg/errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketOptions sets the linux only options of @o on the tcp socket @fd.
+func setSocketOptions(fd uintptr, o *SocketOptions, ipv6 bool) error {
+	if o.TOS > 0 {
+		level, opt := unix.IPPROTO_IP, unix.IP_TOS
+		if ipv6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+		}
+		if err := unix.SetsockoptInt(int(fd), level, opt, o.TOS); err != nil {
+			return perrors.Wrap(err, "setsockopt tos")
+		}
+	}
+	if o.UserTimeout > 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT,
+			int(o.UserTimeout/time.Millisecond)); err != nil {
+			return perrors.Wrap(err, "setsockopt TCP_USER_TIMEOUT")
+		}
+	}
+	if o.QuickAck {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_QUICKACK, 1); err != nil {
+			return perrors.Wrap(err, "setsockopt TCP_QUICKACK")
+		}
+	}
+	if o.NotSentLowat > 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, o.NotSentLowat); err != nil {
+			return perrors.Wrap(err, "setsockopt TCP_NOTSENT_LOWAT")
+		}
+	}
+	return nil
+}
+
+// setFastOpen sets TCP_FASTOPEN on the listening socket @fd.
+func setFastOpen(fd uintptr) error {
+	return perrors.Wrap(unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, fastOpenQueueLen),
+		"setsockopt TCP_FASTOPEN")
+}
+
+// setFastOpenConnect sets TCP_FASTOPEN_CONNECT on the socket @fd before it connects.
+func setFastOpenConnect(fd uintptr) error {
+	return perrors.Wrap(unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1),
+		"setsockopt TCP_FASTOPEN_CONNECT")
+}
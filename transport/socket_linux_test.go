@@ -0,0 +1,117 @@
+//go:build linux
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"golang.org/x/sys/unix"
+)
+
+// sockoptInt returns the int option @opt of the socket of @ss.
+func sockoptInt(t *testing.T, ss Session, level, opt int) int {
+	rawConn, err := underlyingTCPConn(ss.Conn()).SyscallConn()
+	assert.Nil(t, err)
+	var value int
+	assert.Nil(t, rawConn.Control(func(fd uintptr) {
+		value, err = unix.GetsockoptInt(int(fd), level, opt)
+	}))
+	assert.Nil(t, err)
+	return value
+}
+
+func TestSocketOptionsLinux(t *testing.T) {
+	opts := SocketOptions{
+		DisableNoDelay: true,
+		KeepAlive:      time.Minute,
+		ReadBuffer:     16384,
+		Linger:         1500 * time.Millisecond,
+		TOS:            DSCP(46),
+		UserTimeout:    10 * time.Second,
+		QuickAck:       true,
+		NotSentLowat:   16384,
+		FastOpen:       true,
+	}
+	var serverHandler lineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerSocketOptions(opts))
+	serverSessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		serverSessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1),
+		WithClientSocketOptions(SocketOptions{Linger: -1, KeepAlive: -1}))
+	clientSessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineHandler{})
+		session.SetEventListener(&lineHandler{})
+		clientSessions <- session
+		return nil
+	})
+	defer client.Close()
+
+	ss, cs := <-serverSessions, <-clientSessions
+	rawConn, err := server.streamListener.(*net.TCPListener).SyscallConn()
+	assert.Nil(t, err)
+	var fastOpen int
+	assert.Nil(t, rawConn.Control(func(fd uintptr) {
+		fastOpen, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN)
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, fastOpenQueueLen, fastOpen)
+
+	assert.Equal(t, 0, sockoptInt(t, ss, unix.IPPROTO_TCP, unix.TCP_NODELAY))
+	assert.Equal(t, 1, sockoptInt(t, ss, unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+	assert.Equal(t, 60, sockoptInt(t, ss, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+	// the kernel doubles SO_RCVBUF for its bookkeeping
+	assert.True(t, sockoptInt(t, ss, unix.SOL_SOCKET, unix.SO_RCVBUF) >= 16384)
+	assert.Equal(t, DSCP(46), sockoptInt(t, ss, unix.IPPROTO_IP, unix.IP_TOS))
+	assert.Equal(t, 10000, sockoptInt(t, ss, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT))
+	assert.Equal(t, 16384, sockoptInt(t, ss, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT))
+
+	rawConn, err = underlyingTCPConn(ss.Conn()).SyscallConn()
+	assert.Nil(t, err)
+	var linger *unix.Linger
+	assert.Nil(t, rawConn.Control(func(fd uintptr) {
+		linger, err = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, unix.Linger{Onoff: 1, Linger: 2}, *linger)
+
+	assert.Equal(t, 1, sockoptInt(t, cs, unix.IPPROTO_TCP, unix.TCP_NODELAY))
+	assert.Equal(t, 0, sockoptInt(t, cs, unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+	rawConn, err = underlyingTCPConn(cs.Conn()).SyscallConn()
+	assert.Nil(t, err)
+	assert.Nil(t, rawConn.Control(func(fd uintptr) {
+		linger, err = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, unix.Linger{Onoff: 1, Linger: 0}, *linger)
+}
@@ -0,0 +1,55 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketOptions(t *testing.T) {
+	assert.Equal(t, 184, DSCP(46))
+	assert.Panics(t, func() { DSCP(64) })
+	assert.Panics(t, func() { WithServerSocketOptions(SocketOptions{ReadBuffer: -1}) })
+	assert.Panics(t, func() { WithClientSocketOptions(SocketOptions{TOS: 256}) })
+	assert.Panics(t, func() { WithClientSocketOptions(SocketOptions{UserTimeout: -time.Second}) })
+
+	// there is no tcp socket under a memory connection
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	opts := SocketOptions{ReadBuffer: 4096, TOS: DSCP(46)}
+	assert.Nil(t, opts.Apply(local))
+	assert.Nil(t, underlyingTCPConn(local))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	fi := NewFaultInjector(FaultConfig{})
+	wrapped := tls.Client(&proxyProtocolConn{Conn: &secureConn{Conn: fi.WrapConn(conn)}}, &tls.Config{})
+	assert.Equal(t, conn, underlyingTCPConn(wrapped))
+}
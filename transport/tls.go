@@ -18,10 +18,13 @@
 package getty
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
+	"time"
 )
 
 import (
@@ -115,3 +118,26 @@ func (c *ClientTlsConfigBuilder) BuildTlsConfig() (*tls.Config, error) {
 		InsecureSkipVerify: true,
 	}, nil
 }
+
+// handshakeTLS completes the tls handshake of @conn within @timeout. It does nothing if @conn is not a tls connection.
+// Performing the handshake before the session is created lets NewSessionCallback and OnOpen inspect the peer
+// certificates, and a failed handshake is reported on its own instead of as a read loop error. The error of a
+// failed handshake matches ErrTLSHandshake.
+func handshakeTLS(conn net.Conn, timeout time.Duration) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	if timeout <= 0 {
+		timeout = defaultTLSHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return &classifiedError{kind: ErrTLSHandshake,
+			err: perrors.Wrapf(err, "tlsConn.HandshakeContext(peer:%s)", conn.RemoteAddr())}
+	}
+
+	return nil
+}
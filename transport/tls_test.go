@@ -0,0 +1,134 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type memTlsConfigBuilder struct {
+	config *tls.Config
+}
+
+func (b *memTlsConfigBuilder) BuildTlsConfig() (*tls.Config, error) {
+	return b.config, nil
+}
+
+func newTestCertificate(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type tlsStateHandler struct {
+	MessageHandler
+	lock     sync.Mutex
+	complete bool
+	peerName string
+}
+
+func (h *tlsStateHandler) OnOpen(session Session) error {
+	state, ok := session.TLSConnectionState()
+	h.lock.Lock()
+	h.complete = ok && state.HandshakeComplete
+	if certs := session.PeerCertificates(); len(certs) > 0 {
+		h.peerName = certs[0].Subject.CommonName
+	}
+	h.lock.Unlock()
+
+	return h.MessageHandler.OnOpen(session)
+}
+
+func TestTLSHandshakeBeforeOnOpen(t *testing.T) {
+	serverCert := newTestCertificate(t, "getty-server")
+	clientCert := newTestCertificate(t, "getty-client")
+
+	var serverHandler tlsStateHandler
+	server := newServer(
+		TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerSslEnabled(true),
+		WithServerTlsHandshakeTimeout(time.Second),
+		WithServerTlsConfigBuilder(&memTlsConfigBuilder{config: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAnyClientCert,
+		}}),
+	)
+	server.RunEventLoop(func(session Session) error {
+		err := newSessionCallback(session, &serverHandler.MessageHandler)
+		session.SetEventListener(&serverHandler)
+		return err
+	})
+	defer server.Close()
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1),
+		WithClientSslEnabled(true),
+		WithClientTlsConfigBuilder(&memTlsConfigBuilder{config: &tls.Config{
+			Certificates:       []tls.Certificate{clientCert},
+			InsecureSkipVerify: true,
+		}}),
+	)
+	var clientHandler MessageHandler
+	clt.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &clientHandler)
+	})
+	defer clt.Close()
+
+	assert.Eventually(t, func() bool { return serverHandler.SessionNumber() == 1 }, 3*time.Second, 10*time.Millisecond)
+	serverHandler.lock.Lock()
+	assert.True(t, serverHandler.complete)
+	assert.Equal(t, "getty-client", serverHandler.peerName)
+	serverHandler.lock.Unlock()
+
+	assert.Eventually(t, func() bool { return clientHandler.SessionNumber() == 1 }, 3*time.Second, 10*time.Millisecond)
+	clientSession := clientHandler.array[0]
+	certs := clientSession.PeerCertificates()
+	assert.Equal(t, 1, len(certs))
+	assert.Equal(t, "getty-server", certs[0].Subject.CommonName)
+}
+
+func TestHandshakeTLSWithoutTLS(t *testing.T) {
+	assert.Nil(t, handshakeTLS(nil, time.Second))
+}
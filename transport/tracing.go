@@ -0,0 +1,364 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+import (
+	uatomic "go.uber.org/atomic"
+)
+
+// the names of the spans started by getty
+const (
+	SpanConnect      = "getty.connect"
+	SpanTLSHandshake = "getty.tls_handshake"
+	SpanDecode       = "getty.decode"
+	SpanDispatch     = "getty.dispatch"
+	SpanOnMessage    = "getty.on_message"
+	SpanEncode       = "getty.encode"
+	SpanWrite        = "getty.write"
+	SpanClose        = "getty.close"
+)
+
+// the keys of the span attributes set by getty
+const (
+	TraceAttrEndPointType = "getty.endpoint.type"
+	TraceAttrSessionID    = "getty.session.id"
+	TraceAttrPeerAddr     = "getty.peer.addr"
+	TraceAttrPkgLen       = "getty.pkg.len"
+)
+
+// TraceAttribute is a key-value attribute of a span
+type TraceAttribute struct {
+	Key   string
+	Value any
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...TraceAttribute)
+	RecordError(err error)
+	End()
+}
+
+// SpanLinker is implemented by a Span which can be linked to the spans besides its parent. The dispatch span
+// of a package carrying the trace context of its sender is linked to the decode span of the package.
+type SpanLinker interface {
+	// AddLink links the span to the span in @ctx.
+	AddLink(ctx context.Context)
+}
+
+// Tracer starts the spans of the session lifecycle and the message handling, see WithServerTracer and
+// WithClientTracer. An adapter of OpenTelemetry or another tracing system implements it.
+type Tracer interface {
+	// Start starts a span named @name as a child of the span in @ctx, and returns a context carrying the
+	// new span.
+	Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span)
+}
+
+// TracePropagator is implemented by a Tracer which can carry span contexts in frame headers.
+type TracePropagator interface {
+	// Inject encodes the span context of @ctx, it returns nil if there is none.
+	Inject(ctx context.Context) []byte
+	// Extract returns a child context of @ctx carrying the span context encoded in @header.
+	Extract(ctx context.Context, header []byte) context.Context
+}
+
+// TracedPackage is implemented by a package decoded with the trace context of its sender, which is the
+// parent of the dispatch and the OnMessage spans of the package instead of the decode span. See
+// ExtractTraceContext and SpanLinker.
+type TracedPackage interface {
+	TraceContext() context.Context
+}
+
+// TracingEventListener is an EventListener which receives the context of the OnMessage span, and
+// getty invokes OnMessageContext instead of OnMessage. The context should be passed to
+// Session.WritePkgContext to trace the packages written while handling @pkg.
+type TracingEventListener interface {
+	EventListener
+	OnMessageContext(ctx context.Context, session Session, pkg any)
+}
+
+// TracingWriter is a Writer which receives the context of the encode span, and getty invokes WriteContext
+// instead of Write. It can carry the trace context in the frame header by InjectTraceContext.
+type TracingWriter interface {
+	Writer
+	WriteContext(ctx context.Context, session Session, pkg any) ([]byte, error)
+}
+
+// InjectTraceContext encodes the span context of @ctx by the tracer of the endpoint of @ss for a frame
+// header. It returns nil if the tracer is not a TracePropagator.
+func InjectTraceContext(ss Session, ctx context.Context) []byte {
+	s, ok := ss.(*session)
+	if !ok {
+		return nil
+	}
+	if propagator, ok := s.tracer().(TracePropagator); ok {
+		return propagator.Inject(ctx)
+	}
+
+	return nil
+}
+
+// ExtractTraceContext decodes the span context in the frame header @header by the tracer of the endpoint of
+// @ss. The returned context should be carried by the decoded package, see TracedPackage.
+func ExtractTraceContext(ss Session, header []byte) context.Context {
+	ctx := context.Background()
+	s, ok := ss.(*session)
+	if !ok {
+		return ctx
+	}
+	if propagator, ok := s.tracer().(TracePropagator); ok {
+		return propagator.Extract(ctx, header)
+	}
+
+	return ctx
+}
+
+// startSpan starts a span by @tracer, and the span is nil if @tracer is nil.
+func startSpan(tracer Tracer, ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	return tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records @err and ends @span if it is not nil.
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// tracer returns the tracer of the endpoint of the session.
+func (s *session) tracer() Tracer {
+	switch endPoint := s.endPoint.(type) {
+	case *server:
+		return endPoint.tracer
+	case *client:
+		return endPoint.tracer
+	}
+
+	return nil
+}
+
+// startSpan starts a span with the attributes of the session.
+func (s *session) startSpan(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	tracer := s.tracer()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	attrs = append(attrs,
+		TraceAttribute{Key: TraceAttrEndPointType, Value: s.endPoint.EndPointType().String()},
+		TraceAttribute{Key: TraceAttrSessionID, Value: s.ID()},
+		TraceAttribute{Key: TraceAttrPeerAddr, Value: s.RemoteAddr()},
+	)
+	return tracer.Start(ctx, name, attrs...)
+}
+
+// pkgTraceContext returns the trace context of the sender of @pkg, and false if @pkg does not carry one.
+func pkgTraceContext(pkg any) (context.Context, bool) {
+	if ctx, ok := pkg.(UDPContext); ok {
+		pkg = ctx.Pkg
+	}
+	if traced, ok := pkg.(TracedPackage); ok {
+		if ctx := traced.TraceContext(); ctx != nil {
+			return ctx, true
+		}
+	}
+
+	return nil, false
+}
+
+// startDispatchSpan starts the dispatch span of @pkg decoded within the decode span in @ctx. The span is a
+// child of the decode span, or of the span of the sender of @pkg and linked to the decode span.
+func (s *session) startDispatchSpan(ctx context.Context, pkg any) (context.Context, Span) {
+	senderCtx, ok := pkgTraceContext(pkg)
+	if !ok {
+		return s.startSpan(ctx, SpanDispatch)
+	}
+
+	dispatchCtx, span := s.startSpan(senderCtx, SpanDispatch)
+	if linker, ok := span.(SpanLinker); ok {
+		linker.AddLink(ctx)
+	}
+	return dispatchCtx, span
+}
+
+// RecordedSpan is a span recorded by a TraceRecorder
+type RecordedSpan struct {
+	Name       string
+	TraceID    uint64
+	SpanID     uint64
+	ParentID   uint64 // zero if it is a root span
+	Links      []uint64
+	Attributes map[string]any
+	Errors     []error
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+type recordedSpanContext struct {
+	traceID uint64
+	spanID  uint64
+}
+
+type recordedSpanContextKey struct{}
+
+// recordingSpan is a Span of a TraceRecorder
+type recordingSpan struct {
+	recorder *TraceRecorder
+	lock     sync.Mutex
+	span     RecordedSpan
+	ended    bool
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...TraceAttribute) {
+	s.lock.Lock()
+	for _, attr := range attrs {
+		s.span.Attributes[attr.Key] = attr.Value
+	}
+	s.lock.Unlock()
+}
+
+// AddLink links the span to the recording span in @ctx.
+func (s *recordingSpan) AddLink(ctx context.Context) {
+	if linked, ok := ctx.Value(recordedSpanContextKey{}).(recordedSpanContext); ok {
+		s.lock.Lock()
+		s.span.Links = append(s.span.Links, linked.spanID)
+		s.lock.Unlock()
+	}
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.lock.Lock()
+	s.span.Errors = append(s.span.Errors, err)
+	s.lock.Unlock()
+}
+
+func (s *recordingSpan) End() {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.EndTime = time.Now()
+	span := s.span
+	s.lock.Unlock()
+
+	s.recorder.lock.Lock()
+	s.recorder.spans = append(s.recorder.spans, span)
+	s.recorder.lock.Unlock()
+}
+
+// TraceRecorder is an in-memory Tracer and TracePropagator which records the ended spans, for tests.
+type TraceRecorder struct {
+	nextID uatomic.Uint64
+	lock   sync.Mutex
+	spans  []RecordedSpan
+}
+
+// NewTraceRecorder returns an empty TraceRecorder.
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{}
+}
+
+// Start starts a recording span.
+func (r *TraceRecorder) Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	span := &recordingSpan{
+		recorder: r,
+		span: RecordedSpan{
+			Name:       name,
+			SpanID:     r.nextID.Inc(),
+			Attributes: make(map[string]any, len(attrs)),
+			StartTime:  time.Now(),
+		},
+	}
+	if parent, ok := ctx.Value(recordedSpanContextKey{}).(recordedSpanContext); ok {
+		span.span.TraceID, span.span.ParentID = parent.traceID, parent.spanID
+	} else {
+		span.span.TraceID = span.span.SpanID
+	}
+	span.SetAttributes(attrs...)
+
+	spanContext := recordedSpanContext{traceID: span.span.TraceID, spanID: span.span.SpanID}
+	return context.WithValue(ctx, recordedSpanContextKey{}, spanContext), span
+}
+
+// Inject encodes the trace ID and the span ID of the span in @ctx.
+func (r *TraceRecorder) Inject(ctx context.Context) []byte {
+	spanContext, ok := ctx.Value(recordedSpanContextKey{}).(recordedSpanContext)
+	if !ok {
+		return nil
+	}
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint64(header, spanContext.traceID)
+	binary.BigEndian.PutUint64(header[8:], spanContext.spanID)
+	return header
+}
+
+// Extract decodes the span context encoded by Inject.
+func (r *TraceRecorder) Extract(ctx context.Context, header []byte) context.Context {
+	if len(header) != 16 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, recordedSpanContextKey{}, recordedSpanContext{
+		traceID: binary.BigEndian.Uint64(header),
+		spanID:  binary.BigEndian.Uint64(header[8:]),
+	})
+}
+
+// Spans returns the ended spans in the order they ended.
+func (r *TraceRecorder) Spans() []RecordedSpan {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// SpansByName returns the ended spans named @name.
+func (r *TraceRecorder) SpansByName(name string) []RecordedSpan {
+	var spans []RecordedSpan
+	for _, span := range r.Spans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+// Reset drops the recorded spans.
+func (r *TraceRecorder) Reset() {
+	r.lock.Lock()
+	r.spans = nil
+	r.lock.Unlock()
+}
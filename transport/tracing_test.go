@@ -0,0 +1,205 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// tracedLine is a line decoded with the trace context of its sender
+type tracedLine struct {
+	text string
+	ctx  context.Context
+}
+
+func (l tracedLine) TraceContext() context.Context { return l.ctx }
+
+// tracedLineHandler carries the trace context in the hex header of every line: "<header> <text>\n"
+type tracedLineHandler struct {
+	lineHandler
+	ctxLock sync.Mutex
+	ctxs    []context.Context
+}
+
+func (h *tracedLineHandler) Read(ss Session, data []byte) (any, int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		return nil, 0, nil
+	}
+	header, text, _ := bytes.Cut(data[:idx], []byte(" "))
+	raw, _ := hex.DecodeString(string(header))
+	return tracedLine{text: string(text), ctx: ExtractTraceContext(ss, raw)}, idx + 1, nil
+}
+
+func (h *tracedLineHandler) WriteContext(ctx context.Context, ss Session, pkg any) ([]byte, error) {
+	return []byte(hex.EncodeToString(InjectTraceContext(ss, ctx)) + " " + pkg.(string) + "\n"), nil
+}
+
+func (h *tracedLineHandler) OnMessageContext(ctx context.Context, ss Session, pkg any) {
+	h.ctxLock.Lock()
+	h.ctxs = append(h.ctxs, ctx)
+	h.ctxLock.Unlock()
+	h.lineHandler.OnMessage(ss, pkg.(tracedLine).text)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := NewTraceRecorder()
+	var serverHandler, clientHandler tracedLineHandler
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerTracer(recorder))
+	sessions := make(chan Session, 1)
+	server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&serverHandler)
+		session.SetEventListener(&serverHandler)
+		sessions <- session
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.addr), WithConnectionNumber(1), WithClientTracer(recorder))
+	clientSessions := make(chan Session, 1)
+	client.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&clientHandler)
+		session.SetEventListener(&clientHandler)
+		clientSessions <- session
+		return nil
+	})
+	ss, cs := <-sessions, <-clientSessions
+
+	// connect
+	assert.Eventually(t, func() bool { return len(recorder.SpansByName(SpanConnect)) == 2 }, 3*time.Second, 10*time.Millisecond)
+	endPointTypes := map[any]any{}
+	for _, span := range recorder.SpansByName(SpanConnect) {
+		assert.Empty(t, span.Errors)
+		endPointTypes[span.Attributes[TraceAttrEndPointType]] = span.Attributes[TraceAttrSessionID]
+	}
+	assert.Equal(t, map[any]any{"TCP_SERVER": ss.ID(), "TCP_CLIENT": cs.ID()}, endPointTypes)
+
+	// the server spans of the message are the children of the client spans
+	ctx, root := recorder.Start(context.Background(), "request")
+	_, _, err := cs.WritePkgContext(ctx, "hello", 0)
+	assert.Nil(t, err)
+	root.End()
+	assert.Eventually(t, func() bool {
+		return len(recorder.SpansByName(SpanOnMessage)) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	got, _ := serverHandler.snapshot()
+	assert.Equal(t, []string{"hello"}, got)
+
+	request := recorder.SpansByName("request")[0]
+	encode := recorder.SpansByName(SpanEncode)[0]
+	write := recorder.SpansByName(SpanWrite)[0]
+	decode := recorder.SpansByName(SpanDecode)[0]
+	dispatch := recorder.SpansByName(SpanDispatch)[0]
+	onMessage := recorder.SpansByName(SpanOnMessage)[0]
+	assert.Equal(t, request.SpanID, encode.ParentID)
+	assert.Equal(t, request.SpanID, write.ParentID)
+	assert.Equal(t, "TCP_CLIENT", write.Attributes[TraceAttrEndPointType])
+	assert.Equal(t, cs.ID(), write.Attributes[TraceAttrSessionID])
+	assert.Equal(t, len(hex.EncodeToString(make([]byte, 16)))+len(" hello\n"), write.Attributes[TraceAttrPkgLen])
+	assert.Equal(t, "TCP_SERVER", decode.Attributes[TraceAttrEndPointType])
+	assert.Equal(t, ss.ID(), decode.Attributes[TraceAttrSessionID])
+	assert.Equal(t, request.TraceID, dispatch.TraceID)
+	assert.Equal(t, encode.SpanID, dispatch.ParentID)
+	assert.Equal(t, dispatch.SpanID, onMessage.ParentID)
+	serverHandler.ctxLock.Lock()
+	// the listener receives the context of the OnMessage span
+	assert.Equal(t, recordedSpanContext{traceID: request.TraceID, spanID: onMessage.SpanID},
+		serverHandler.ctxs[0].Value(recordedSpanContextKey{}))
+	serverHandler.ctxLock.Unlock()
+
+	// close
+	client.Close()
+	assert.Eventually(t, func() bool { return len(recorder.SpansByName(SpanClose)) == 2 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestTracingTLSHandshake(t *testing.T) {
+	recorder := NewTraceRecorder()
+	serverCert := newTestCertificate(t, "getty-server")
+	server := newServer(TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerSslEnabled(true),
+		WithServerTlsConfigBuilder(&memTlsConfigBuilder{config: &tls.Config{Certificates: []tls.Certificate{serverCert}}}),
+		WithServerTracer(recorder),
+	)
+	var serverHandler MessageHandler
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverHandler)
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT,
+		WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1),
+		WithClientSslEnabled(true),
+		WithClientTlsConfigBuilder(&memTlsConfigBuilder{config: &tls.Config{InsecureSkipVerify: true}}),
+		WithClientTracer(recorder),
+	)
+	var clientHandler MessageHandler
+	client.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &clientHandler)
+	})
+	defer client.Close()
+
+	assert.Eventually(t, func() bool { return len(recorder.SpansByName(SpanConnect)) == 2 }, 3*time.Second, 10*time.Millisecond)
+	handshakes := recorder.SpansByName(SpanTLSHandshake)
+	assert.Equal(t, 2, len(handshakes))
+	connects := map[uint64]bool{}
+	for _, span := range recorder.SpansByName(SpanConnect) {
+		connects[span.SpanID] = true
+	}
+	for _, span := range handshakes {
+		assert.Empty(t, span.Errors)
+		assert.True(t, connects[span.ParentID])
+	}
+}
+
+func TestTraceRecorderPropagation(t *testing.T) {
+	recorder := NewTraceRecorder()
+	assert.Nil(t, recorder.Inject(context.Background()))
+	assert.Equal(t, context.Background(), recorder.Extract(context.Background(), []byte("short")))
+
+	ctx, span := recorder.Start(context.Background(), "parent", TraceAttribute{Key: "k", Value: 1})
+	remote := recorder.Extract(context.Background(), recorder.Inject(ctx))
+	_, child := recorder.Start(remote, "child")
+	child.End()
+	span.RecordError(ErrSessionClosed)
+	span.End()
+	span.End()
+
+	spans := recorder.Spans()
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentID)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, uint64(0), spans[1].ParentID)
+	assert.Equal(t, 1, spans[1].Attributes["k"])
+	assert.Equal(t, []error{ErrSessionClosed}, spans[1].Errors)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Spans())
+}
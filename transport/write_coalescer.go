@@ -0,0 +1,170 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWriteCoalescingBytes is the default max size of a coalesced write
+	DefaultWriteCoalescingBytes = 64 * 1024
+)
+
+// writeBatch is a group of packages written by one vectored I/O syscall
+type writeBatch struct {
+	buffers [][]byte
+	size    int
+	pkgNum  int
+	err     error
+	done    chan struct{}
+}
+
+// writeCoalescer gathers the packages written concurrently to a tcp session and flushes them in batches,
+// so that many small writes cost one writev syscall. A batch is flushed when it reaches @maxBytes or
+// @maxDelay after its first package. With a zero @maxDelay, the packages written while the previous batch
+// is being flushed make up the next batch.
+type writeCoalescer struct {
+	conn     *gettyTCPConn
+	maxBytes int
+	maxDelay time.Duration
+
+	lock   sync.Mutex
+	batch  *writeBatch
+	closed bool
+	ready  chan struct{}
+	full   chan struct{}
+	done   chan struct{}
+}
+
+func newWriteCoalescer(conn *gettyTCPConn, maxBytes int, maxDelay time.Duration, done chan struct{}) *writeCoalescer {
+	return &writeCoalescer{
+		conn:     conn,
+		maxBytes: maxBytes,
+		maxDelay: maxDelay,
+		ready:    make(chan struct{}, 1),
+		full:     make(chan struct{}, 1),
+		done:     done,
+	}
+}
+
+func notifyChan(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// write appends @buffers of @pkgNum packages to the current batch and waits until the batch is flushed.
+// @buffers must not be modified before it returns.
+func (c *writeCoalescer) write(buffers [][]byte, pkgNum int) (int, error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return 0, ErrSessionClosed
+	}
+	b := c.batch
+	if b == nil {
+		b = &writeBatch{done: make(chan struct{})}
+		c.batch = b
+		notifyChan(c.ready)
+	}
+	size := 0
+	for _, buf := range buffers {
+		size += len(buf)
+	}
+	b.buffers = append(b.buffers, buffers...)
+	b.size += size
+	b.pkgNum += pkgNum
+	if b.size >= c.maxBytes {
+		notifyChan(c.full)
+	}
+	c.lock.Unlock()
+
+	<-b.done
+	if b.err != nil {
+		return 0, b.err
+	}
+	return size, nil
+}
+
+func (c *writeCoalescer) run() {
+	var timer *time.Timer
+	if c.maxDelay > 0 {
+		timer = time.NewTimer(c.maxDelay)
+		timer.Stop()
+		defer timer.Stop()
+	}
+
+	for {
+		select {
+		case <-c.done:
+			c.close()
+			return
+		case <-c.ready:
+		}
+
+		if timer != nil {
+			timer.Reset(c.maxDelay)
+			select {
+			case <-c.done:
+				c.close()
+				return
+			case <-c.full:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+
+		// a stale signal of the flushed batch at most makes the next batch flushed earlier
+		select {
+		case <-c.full:
+		default:
+		}
+		c.lock.Lock()
+		b := c.batch
+		c.batch = nil
+		c.lock.Unlock()
+		c.flush(b)
+	}
+}
+
+func (c *writeCoalescer) flush(b *writeBatch) {
+	if b == nil {
+		return
+	}
+	if _, b.err = c.conn.updateWriteDeadline(); b.err == nil {
+		_, b.err = c.conn.sendBuffers(b.buffers, b.pkgNum)
+	}
+	close(b.done)
+}
+
+// close fails the pending batch and the later writes
+func (c *writeCoalescer) close() {
+	c.lock.Lock()
+	b := c.batch
+	c.batch = nil
+	c.closed = true
+	c.lock.Unlock()
+
+	if b != nil {
+		b.err = ErrSessionClosed
+		close(b.done)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// buffersLineHandler writes a line and its terminator as two buffers
+type buffersLineHandler struct {
+	lineHandler
+}
+
+func (h *buffersLineHandler) WriteBuffers(_ Session, pkg any) ([][]byte, error) {
+	return [][]byte{[]byte(pkg.(string)), []byte("\n")}, nil
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	for _, maxDelay := range []time.Duration{0, 5 * time.Millisecond} {
+		var handler buffersLineHandler
+		sessions := make(chan Session, 1)
+		server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+		server.RunEventLoop(func(session Session) error {
+			session.SetPkgHandler(&handler)
+			session.SetEventListener(&handler)
+			session.SetWriteCoalescing(1024, maxDelay)
+			sessions <- session
+			return nil
+		})
+
+		conn, err := net.Dial("tcp", server.addr)
+		assert.Nil(t, err)
+		ss := <-sessions
+		assert.NotNil(t, ss.(*session).coalescer)
+
+		const writers, num = 8, 50
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < num; j++ {
+					line := fmt.Sprintf("%d-%d", i, j)
+					pkgLen, sent, werr := ss.WritePkg(line, 0)
+					assert.Nil(t, werr)
+					assert.Equal(t, len(line)+1, pkgLen)
+					assert.Equal(t, len(line)+1, sent)
+				}
+			}(i)
+		}
+		_, err = ss.WriteBytes([]byte("bytes\n"))
+		assert.Nil(t, err)
+		_, err = ss.WriteBytesArray([]byte("array-0\n"), []byte("array-1\n"))
+		assert.Nil(t, err)
+		wg.Wait()
+
+		got := make(map[string]bool)
+		scanner := bufio.NewScanner(conn)
+		for len(got) < writers*num+3 && scanner.Scan() {
+			got[scanner.Text()] = true
+		}
+		assert.Equal(t, writers*num+3, len(got))
+		assert.True(t, got["7-49"])
+		assert.True(t, got["array-1"])
+		assert.Equal(t, uint32(writers*num+3), ss.(*session).gettyConn().writePkgNum.Load())
+
+		_ = conn.Close()
+		server.Close()
+		assert.Eventually(t, ss.IsClosed, 3*time.Second, 10*time.Millisecond)
+		_, _, err = ss.WritePkg("closed", 0)
+		assert.NotNil(t, err)
+	}
+}
@@ -106,18 +106,31 @@ func GetLogger() Logger {
 	return log
 }
 
-// SetLoggerLevel set logger level
+// SetLoggerLevel set logger level. The level of the default zap logger is changed in place and it is safe to
+// call it at any time. The loggers set by SetLogger or by the logger options of the endpoints are not affected.
 func SetLoggerLevel(level LoggerLevel) error {
-	var err error
-	zapLoggerConfig.Level = zap.NewAtomicLevelAt(zapcore.Level(level))
-	zapLogger, err = zapLoggerConfig.Build()
-	if err != nil {
-		return err
-	}
-	log = zapLogger.Sugar()
+	zapLoggerConfig.Level.SetLevel(zapcore.Level(level))
 	return nil
 }
 
+// GetLoggerLevel get logger level
+func GetLoggerLevel() LoggerLevel {
+	return LoggerLevel(zapLoggerConfig.Level.Level())
+}
+
+// ParseLoggerLevel parses a level name such as "debug" or "ERROR"
+func ParseLoggerLevel(text string) (LoggerLevel, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return 0, err
+	}
+	return LoggerLevel(level), nil
+}
+
+func (l LoggerLevel) String() string {
+	return zapcore.Level(l).String()
+}
+
 // SetLoggerCallerDisable disable caller info in production env for performance improve.
 // It is highly recommended that you execute this method in a production environment.
 func SetLoggerCallerDisable() error {
@@ -0,0 +1,267 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package getty
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructuredLogger is a Logger which logs messages with key-value pairs, *zap.SugaredLogger implements it.
+type StructuredLogger interface {
+	Logger
+	Debugw(msg string, keysAndValues ...any)
+	Infow(msg string, keysAndValues ...any)
+	Warnw(msg string, keysAndValues ...any)
+	Errorw(msg string, keysAndValues ...any)
+}
+
+// Structured returns @logger if it is a StructuredLogger, or a StructuredLogger which appends the key-value
+// pairs to the messages of @logger. It returns the global logger set by SetLogger if @logger is nil.
+func Structured(logger Logger) StructuredLogger {
+	switch l := logger.(type) {
+	case nil:
+		return globalLogger{}
+	case StructuredLogger:
+		return l
+	default:
+		return plainLogger{Logger: l}
+	}
+}
+
+// With returns a StructuredLogger of @logger which adds @keysAndValues to every message. It uses the
+// global logger set by SetLogger if @logger is nil.
+func With(logger Logger, keysAndValues ...any) StructuredLogger {
+	if len(keysAndValues) == 0 {
+		return Structured(logger)
+	}
+	if l, ok := logger.(*fieldLogger); ok {
+		return &fieldLogger{base: l.base, fields: append(append([]any(nil), l.fields...), keysAndValues...)}
+	}
+
+	return &fieldLogger{base: Structured(logger), fields: keysAndValues}
+}
+
+// formatFields formats the key-value pairs as " k1=v1 k2=v2".
+func formatFields(keysAndValues []any) string {
+	var b strings.Builder
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keysAndValues[i])
+		}
+	}
+
+	return b.String()
+}
+
+// plainLogger is the StructuredLogger of a Logger without key-value pairs
+type plainLogger struct {
+	Logger
+}
+
+func (l plainLogger) Debugw(msg string, keysAndValues ...any) {
+	l.Debug(msg + formatFields(keysAndValues))
+}
+
+func (l plainLogger) Infow(msg string, keysAndValues ...any) {
+	l.Info(msg + formatFields(keysAndValues))
+}
+
+func (l plainLogger) Warnw(msg string, keysAndValues ...any) {
+	l.Warn(msg + formatFields(keysAndValues))
+}
+
+func (l plainLogger) Errorw(msg string, keysAndValues ...any) {
+	l.Error(msg + formatFields(keysAndValues))
+}
+
+// globalLogger is the StructuredLogger of the global logger, it follows SetLogger.
+type globalLogger struct{}
+
+func (globalLogger) Info(args ...any)                    { log.Info(args...) }
+func (globalLogger) Warn(args ...any)                    { log.Warn(args...) }
+func (globalLogger) Error(args ...any)                   { log.Error(args...) }
+func (globalLogger) Debug(args ...any)                   { log.Debug(args...) }
+func (globalLogger) Infof(template string, args ...any)  { log.Infof(template, args...) }
+func (globalLogger) Warnf(template string, args ...any)  { log.Warnf(template, args...) }
+func (globalLogger) Errorf(template string, args ...any) { log.Errorf(template, args...) }
+func (globalLogger) Debugf(template string, args ...any) { log.Debugf(template, args...) }
+
+func (globalLogger) Debugw(msg string, keysAndValues ...any) {
+	Structured(log).Debugw(msg, keysAndValues...)
+}
+
+func (globalLogger) Infow(msg string, keysAndValues ...any) {
+	Structured(log).Infow(msg, keysAndValues...)
+}
+
+func (globalLogger) Warnw(msg string, keysAndValues ...any) {
+	Structured(log).Warnw(msg, keysAndValues...)
+}
+
+func (globalLogger) Errorw(msg string, keysAndValues ...any) {
+	Structured(log).Errorw(msg, keysAndValues...)
+}
+
+// fieldLogger adds its key-value pairs to every message
+type fieldLogger struct {
+	base   StructuredLogger
+	fields []any
+}
+
+func (l *fieldLogger) with(keysAndValues []any) []any {
+	if len(keysAndValues) == 0 {
+		return l.fields
+	}
+
+	return append(append(make([]any, 0, len(l.fields)+len(keysAndValues)), l.fields...), keysAndValues...)
+}
+
+func (l *fieldLogger) Info(args ...any)  { l.base.Infow(fmt.Sprint(args...), l.fields...) }
+func (l *fieldLogger) Warn(args ...any)  { l.base.Warnw(fmt.Sprint(args...), l.fields...) }
+func (l *fieldLogger) Error(args ...any) { l.base.Errorw(fmt.Sprint(args...), l.fields...) }
+func (l *fieldLogger) Debug(args ...any) { l.base.Debugw(fmt.Sprint(args...), l.fields...) }
+
+func (l *fieldLogger) Infof(template string, args ...any) {
+	l.base.Infow(fmt.Sprintf(template, args...), l.fields...)
+}
+
+func (l *fieldLogger) Warnf(template string, args ...any) {
+	l.base.Warnw(fmt.Sprintf(template, args...), l.fields...)
+}
+
+func (l *fieldLogger) Errorf(template string, args ...any) {
+	l.base.Errorw(fmt.Sprintf(template, args...), l.fields...)
+}
+
+func (l *fieldLogger) Debugf(template string, args ...any) {
+	l.base.Debugw(fmt.Sprintf(template, args...), l.fields...)
+}
+
+func (l *fieldLogger) Debugw(msg string, keysAndValues ...any) {
+	l.base.Debugw(msg, l.with(keysAndValues)...)
+}
+
+func (l *fieldLogger) Infow(msg string, keysAndValues ...any) {
+	l.base.Infow(msg, l.with(keysAndValues)...)
+}
+
+func (l *fieldLogger) Warnw(msg string, keysAndValues ...any) {
+	l.base.Warnw(msg, l.with(keysAndValues)...)
+}
+
+func (l *fieldLogger) Errorw(msg string, keysAndValues ...any) {
+	l.base.Errorw(msg, l.with(keysAndValues)...)
+}
+
+// rateLimitedLogger logs at most @burst messages every @interval
+type rateLimitedLogger struct {
+	base     StructuredLogger
+	interval time.Duration
+	burst    int
+
+	lock    sync.Mutex
+	start   time.Time
+	count   int
+	dropped int
+}
+
+// RateLimited returns a StructuredLogger of @logger which logs at most @burst messages every @interval and
+// drops the others, for hot error paths. The number of the dropped messages is added to the next message
+// as the "dropped" field.
+func RateLimited(logger Logger, interval time.Duration, burst int) StructuredLogger {
+	if interval <= 0 {
+		panic("@interval <= 0")
+	}
+	if burst <= 0 {
+		panic("@burst <= 0")
+	}
+
+	return &rateLimitedLogger{base: Structured(logger), interval: interval, burst: burst}
+}
+
+// allow returns the number of the dropped messages and true if a message can be logged now.
+func (l *rateLimitedLogger) allow() (int, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now := time.Now(); now.Sub(l.start) >= l.interval {
+		l.start, l.count = now, 0
+	}
+	if l.count >= l.burst {
+		l.dropped++
+		return 0, false
+	}
+	l.count++
+	dropped := l.dropped
+	l.dropped = 0
+	return dropped, true
+}
+
+// log logs the message by @f if it is allowed.
+func (l *rateLimitedLogger) log(f func(msg string, keysAndValues ...any), msg string, keysAndValues []any) {
+	dropped, ok := l.allow()
+	if !ok {
+		return
+	}
+	if dropped > 0 {
+		keysAndValues = append(append(make([]any, 0, len(keysAndValues)+2), keysAndValues...), "dropped", dropped)
+	}
+	f(msg, keysAndValues...)
+}
+
+func (l *rateLimitedLogger) Info(args ...any)  { l.log(l.base.Infow, fmt.Sprint(args...), nil) }
+func (l *rateLimitedLogger) Warn(args ...any)  { l.log(l.base.Warnw, fmt.Sprint(args...), nil) }
+func (l *rateLimitedLogger) Error(args ...any) { l.log(l.base.Errorw, fmt.Sprint(args...), nil) }
+func (l *rateLimitedLogger) Debug(args ...any) { l.log(l.base.Debugw, fmt.Sprint(args...), nil) }
+
+func (l *rateLimitedLogger) Infof(template string, args ...any) {
+	l.log(l.base.Infow, fmt.Sprintf(template, args...), nil)
+}
+
+func (l *rateLimitedLogger) Warnf(template string, args ...any) {
+	l.log(l.base.Warnw, fmt.Sprintf(template, args...), nil)
+}
+
+func (l *rateLimitedLogger) Errorf(template string, args ...any) {
+	l.log(l.base.Errorw, fmt.Sprintf(template, args...), nil)
+}
+
+func (l *rateLimitedLogger) Debugf(template string, args ...any) {
+	l.log(l.base.Debugw, fmt.Sprintf(template, args...), nil)
+}
+
+func (l *rateLimitedLogger) Debugw(msg string, keysAndValues ...any) {
+	l.log(l.base.Debugw, msg, keysAndValues)
+}
+
+func (l *rateLimitedLogger) Infow(msg string, keysAndValues ...any) {
+	l.log(l.base.Infow, msg, keysAndValues)
+}
+
+func (l *rateLimitedLogger) Warnw(msg string, keysAndValues ...any) {
+	l.log(l.base.Warnw, msg, keysAndValues)
+}
+
+func (l *rateLimitedLogger) Errorw(msg string, keysAndValues ...any) {
+	l.log(l.base.Errorw, msg, keysAndValues)
+}